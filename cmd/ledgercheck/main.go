@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+)
+
+// exitDrift is the exit status when drift is found. Errors that stop the
+// check exit with status 1, so scripts can tell the two apart.
+const exitDrift = 2
+
+// ledgercheck replays the transaction history of every wallet and reports
+// balances that drifted, orphan receive records and transactions pointing at
+// missing wallets. It exits with status 2 when drift is found and 1 when the
+// check can not be run.
+func main() {
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		log.Fatal("DATABASE_URL environment variable is not set")
+	}
+
+	dbConn, err := db.NewDB(databaseURL)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	report, err := dbConn.CheckLedger()
+	dbConn.Close()
+	if err != nil {
+		log.Fatalf("ledger check failed: %v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("failed to encode report: %v", err)
+		}
+	} else {
+		printReport(os.Stdout, report)
+	}
+
+	if report.HasDrift() {
+		os.Exit(exitDrift)
+	}
+}
+
+func printReport(w io.Writer, report *db.LedgerReport) {
+	fmt.Fprintf(w, "Ledger check at %s\n", report.CheckedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(w, "Wallets checked: %d, transactions replayed: %d\n\n", report.WalletsChecked, report.TransactionsReplayed)
+
+	if !report.HasDrift() {
+		fmt.Fprintln(w, "OK: no drift found")
+		return
+	}
+
+	if len(report.Mismatches) > 0 {
+		fmt.Fprintf(w, "Balance mismatches (%d):\n", len(report.Mismatches))
+		for _, m := range report.Mismatches {
+			fmt.Fprintf(w, "  wallet %d: stored %s %s, replayed %s, ledger %s\n", m.WalletID, m.StoredBalance, m.Currency, m.ReplayedBalance, m.LedgerBalance)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(report.OrphanReceives) > 0 {
+		fmt.Fprintf(w, "Receive transactions without a matching send (%d):\n", len(report.OrphanReceives))
+		for _, t := range report.OrphanReceives {
+			fmt.Fprintf(w, "  transaction %d: wallet %d received %s %s from wallet %d at %s\n", t.ID, t.FromWalletID, t.Amount, t.Currency, t.ToWalletID, t.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(report.MissingWallets) > 0 {
+		fmt.Fprintf(w, "Transactions referencing missing wallets (%d):\n", len(report.MissingWallets))
+		for _, ref := range report.MissingWallets {
+			fmt.Fprintf(w, "  transaction %d: %s %d does not exist\n", ref.TransactionID, ref.Column, ref.WalletID)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "DRIFT: ledger is inconsistent")
+}
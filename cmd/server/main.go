@@ -1,13 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
 	"github.com/masudcsesust04/ewallet-api/internal/handlers"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
 	"github.com/masudcsesust04/ewallet-api/internal/utils"
 )
 
@@ -24,26 +32,143 @@ func main() {
 	}
 	defer dbConn.Close()
 
+	// access tokens are signed with the key ring in JWT_KEYS_DIR
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		log.Fatal("JWT_KEYS_DIR environment variable is not set")
+	}
+	keyRing, err := utils.LoadKeyRing(keysDir)
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+	utils.SetKeyRing(keyRing)
+
+	// access tokens of revoked sessions and inactive users are rejected;
+	// the check is cached for TOKEN_STATE_CACHE_TTL
+	tokenStateTTL := utils.DefaultTokenStateTTL
+	if ttl := os.Getenv("TOKEN_STATE_CACHE_TTL"); ttl != "" {
+		tokenStateTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid TOKEN_STATE_CACHE_TTL: %v", err)
+		}
+	}
+	utils.SetTokenStateCache(utils.NewTokenStateCache(dbConn, tokenStateTTL))
+
+	// NOTIFIER selects how reset tokens and verification codes are
+	// delivered: "log" (default) or "file", which appends to NOTIFY_FILE
+	notifier, err := notify.New(os.Getenv("NOTIFIER"), os.Getenv("NOTIFY_FILE"))
+	if err != nil {
+		log.Fatalf("invalid notifier: %v", err)
+	}
+
 	// Initialize user handler
 	userHandler := handlers.NewUserHandler(dbConn)
+	userHandler.Notifier = notifier
 
 	// Setup router
 
 	router := mux.NewRouter()
 	// user routes
-	router.HandleFunc("/users", utils.JWTMiddleware(userHandler.GetUsers)).Methods("GET")
+	router.HandleFunc("/users", utils.JWTMiddleware(utils.RequirePermission(utils.PermListUsers, userHandler.GetUsers))).Methods("GET")
 	router.HandleFunc("/users", userHandler.CreateUsers).Methods("POST")
 	router.HandleFunc("/users/{id}", utils.JWTMiddleware(userHandler.GetUser)).Methods("GET")
 	router.HandleFunc("/users/{id}", utils.JWTMiddleware(userHandler.UpdateUser)).Methods("PUT")
-	router.HandleFunc("/users/{id}", utils.JWTMiddleware(userHandler.DeleteUser)).Methods("DELETE")
+	router.HandleFunc("/users/{id}", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageUsers, userHandler.DeleteUser))).Methods("DELETE")
+	router.HandleFunc("/admin/users/{id}/unlock", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageUsers, userHandler.UnlockUser))).Methods("POST")
+	router.HandleFunc("/admin/lockouts", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageUsers, userHandler.GetLockoutEvents))).Methods("GET")
 
 	// Auth routes
 	router.HandleFunc("/login", userHandler.Login).Methods("POST")
 	router.HandleFunc("/logout", utils.JWTMiddleware(userHandler.Logout)).Methods("POST")
 	router.HandleFunc("/token/refresh", userHandler.RefreshToken).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", handlers.JWKS).Methods("GET")
+	router.HandleFunc("/login/mfa", userHandler.LoginMFA).Methods("POST")
+	router.HandleFunc("/password/change", utils.JWTMiddleware(userHandler.ChangePassword)).Methods("POST")
+	router.HandleFunc("/password/forgot", userHandler.ForgotPassword).Methods("POST")
+	router.HandleFunc("/password/reset", userHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/verify/{channel}/send", utils.JWTMiddleware(userHandler.SendVerificationCode)).Methods("POST")
+	router.HandleFunc("/verify/{channel}", utils.JWTMiddleware(userHandler.VerifyContact)).Methods("POST")
+	router.HandleFunc("/mfa/totp/enroll", utils.JWTMiddleware(userHandler.EnrollTOTP)).Methods("POST")
+	router.HandleFunc("/mfa/totp/confirm", utils.JWTMiddleware(userHandler.ConfirmTOTP)).Methods("POST")
+	router.HandleFunc("/mfa/totp/disable", utils.JWTMiddleware(userHandler.DisableTOTP)).Methods("POST")
+	router.HandleFunc("/sessions", utils.JWTMiddleware(userHandler.GetSessions)).Methods("GET")
+	router.HandleFunc("/sessions/{id}", utils.JWTMiddleware(userHandler.DeleteSession)).Methods("DELETE")
 
 	// register wallet handler
-	handlers.RegisterWalletRoutes(router, dbConn)
+	walletConfig := handlers.WalletConfig{IdempotencyTTL: handlers.DefaultIdempotencyTTL}
+	if ttl := os.Getenv("IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		walletConfig.IdempotencyTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid IDEMPOTENCY_KEY_TTL: %v", err)
+		}
+	}
+
+	// currency conversion is enabled when a rate file is configured
+	walletConfig.QuoteTTL = handlers.DefaultQuoteTTL
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		walletConfig.Rates, err = fx.LoadFile(path)
+		if err != nil {
+			log.Fatalf("failed to load exchange rates: %v", err)
+		}
+	}
+	if ttl := os.Getenv("FX_QUOTE_TTL"); ttl != "" {
+		walletConfig.QuoteTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid FX_QUOTE_TTL: %v", err)
+		}
+	}
+	if spread := os.Getenv("FX_SPREAD"); spread != "" {
+		var ok bool
+		walletConfig.FXSpread, ok = new(big.Rat).SetString(spread)
+		if !ok || walletConfig.FXSpread.Sign() < 0 {
+			log.Fatalf("invalid FX_SPREAD: %q", spread)
+		}
+	}
+
+	// transfers above these amounts need a TOTP code, e.g. "USD:1000,EUR:1000"
+	if thresholds := os.Getenv("TOTP_TRANSFER_THRESHOLDS"); thresholds != "" {
+		walletConfig.TOTPThresholds, err = parseThresholds(thresholds)
+		if err != nil {
+			log.Fatalf("invalid TOTP_TRANSFER_THRESHOLDS: %v", err)
+		}
+	}
+
+	// holds expire unless captured or voided within HOLD_TTL
+	walletConfig.HoldTTL = handlers.DefaultHoldTTL
+	if ttl := os.Getenv("HOLD_TTL"); ttl != "" {
+		walletConfig.HoldTTL, err = time.ParseDuration(ttl)
+		if err != nil || walletConfig.HoldTTL <= 0 {
+			log.Fatalf("invalid HOLD_TTL: %q", ttl)
+		}
+	}
+
+	handlers.RegisterWalletRoutes(router, dbConn, walletConfig)
+	handlers.RegisterFeeRoutes(router, dbConn)
+	go purgeExpired(dbConn)
+
+	// due scheduled transfers are run every SCHEDULER_INTERVAL; a run short
+	// of funds is retried SCHEDULE_MAX_RETRIES times, SCHEDULE_RETRY_DELAY apart
+	schedulerInterval := time.Minute
+	if interval := os.Getenv("SCHEDULER_INTERVAL"); interval != "" {
+		schedulerInterval, err = time.ParseDuration(interval)
+		if err != nil || schedulerInterval <= 0 {
+			log.Fatalf("invalid SCHEDULER_INTERVAL: %q", interval)
+		}
+	}
+	retryPolicy := db.ScheduleRetryPolicy{MaxRetries: 3, Delay: time.Hour}
+	if retries := os.Getenv("SCHEDULE_MAX_RETRIES"); retries != "" {
+		retryPolicy.MaxRetries, err = strconv.Atoi(retries)
+		if err != nil || retryPolicy.MaxRetries < 0 {
+			log.Fatalf("invalid SCHEDULE_MAX_RETRIES: %q", retries)
+		}
+	}
+	if delay := os.Getenv("SCHEDULE_RETRY_DELAY"); delay != "" {
+		retryPolicy.Delay, err = time.ParseDuration(delay)
+		if err != nil || retryPolicy.Delay <= 0 {
+			log.Fatalf("invalid SCHEDULE_RETRY_DELAY: %q", delay)
+		}
+	}
+	go runScheduler(dbConn, schedulerInterval, retryPolicy)
 
 	// Start server
 	addr := ":8080"
@@ -53,3 +178,71 @@ func main() {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// purgeExpired periodically removes idempotency keys whose replay window has
+// passed, currency quotes that expired unused, abandoned MFA challenges and
+// spent password reset tokens, and marks holds and payment requests past
+// their expiry as expired
+func purgeExpired(dbConn *db.DB) {
+	for range time.Tick(time.Hour) {
+		if _, err := dbConn.DeleteExpiredIdempotencyKeys(); err != nil {
+			log.Printf("failed to purge idempotency keys: %v", err)
+		}
+		if _, err := dbConn.DeleteExpiredFXQuotes(); err != nil {
+			log.Printf("failed to purge fx quotes: %v", err)
+		}
+		if _, err := dbConn.DeleteExpiredMFAChallenges(); err != nil {
+			log.Printf("failed to purge MFA challenges: %v", err)
+		}
+		if _, err := dbConn.DeleteExpiredPasswordResets(); err != nil {
+			log.Printf("failed to purge password resets: %v", err)
+		}
+		if _, err := dbConn.ExpireHolds(); err != nil {
+			log.Printf("failed to expire holds: %v", err)
+		}
+		if _, err := dbConn.ExpirePaymentRequests(); err != nil {
+			log.Printf("failed to expire payment requests: %v", err)
+		}
+	}
+}
+
+// runScheduler runs the scheduled transfers that are due every interval.
+// Each run claims one schedule with SKIP LOCKED, so several servers can run
+// the scheduler side by side.
+func runScheduler(dbConn *db.DB, interval time.Duration, policy db.ScheduleRetryPolicy) {
+	for range time.Tick(interval) {
+		for {
+			run, err := dbConn.RunDueScheduledTransfer(policy)
+			if err != nil {
+				log.Printf("failed to run scheduled transfer: %v", err)
+				break
+			}
+			if run == nil {
+				break
+			}
+
+			if run.Status != db.RunCompleted {
+				log.Printf("scheduled transfer %d attempt %d %s: %v", run.ScheduleID, run.Attempt, run.Status, run.Cause)
+			}
+		}
+	}
+}
+
+// parseThresholds parses comma separated CURRENCY:AMOUNT pairs
+func parseThresholds(s string) (map[string]money.Money, error) {
+	thresholds := make(map[string]money.Money)
+	for _, pair := range strings.Split(s, ",") {
+		currency, amount, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not CURRENCY:AMOUNT", pair)
+		}
+
+		m, err := money.Parse(amount, currency)
+		if err != nil {
+			return nil, err
+		}
+		thresholds[currency] = m
+	}
+
+	return thresholds, nil
+}
@@ -22,7 +22,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Clean tabels before running tests
-	_, err = testDB.pool.Exec(context.Background(), "TRUNCATE TABLE transactions, wallets, refresh_tokens, users RESTART IDENTITY CASCADE;")
+	_, err = testDB.pool.Exec(context.Background(), "TRUNCATE TABLE payment_requests, scheduled_transfer_runs, scheduled_transfers, holds, fee_rules, fx_quotes, transactions, postings, journal_entries, ledger_accounts, wallets, refresh_tokens, sessions, mfa_challenges, recovery_codes, password_resets, verification_codes, lockout_events, login_failures, users RESTART IDENTITY CASCADE;")
 	if err != nil {
 		panic("failed to truncate tables: " + err.Error())
 	}
@@ -50,7 +50,7 @@ func setupTestDB(t *testing.T) (*DB, func()) {
 
 	// Clean up tables before and after tests
 	cleanup := func() {
-		db.pool.Exec(context.Background(), "TRUNCATE TABLE transactions, wallets, users, refresh_tokens RESTART IDENTITY CASCADE")
+		db.pool.Exec(context.Background(), "TRUNCATE TABLE payment_requests, scheduled_transfer_runs, scheduled_transfers, holds, fee_rules, fx_quotes, transactions, postings, journal_entries, ledger_accounts, wallets, users, refresh_tokens RESTART IDENTITY CASCADE")
 		// Do not close db here to keep connection alive for tests
 	}
 
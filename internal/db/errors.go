@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The general errors below are wrapped by the specific ones, e.g.
+// errors.Is(ErrWalletNotFound, ErrNotFound) holds, so callers can check for
+// either. Errors from the database driver stay in the chain for logging.
+var (
+	// ErrNotFound is returned when a looked up row does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrDuplicate is returned when a write collides with a unique constraint
+	ErrDuplicate = errors.New("already exists")
+
+	ErrUserNotFound   = fmt.Errorf("user %w", ErrNotFound)
+	ErrWalletNotFound = fmt.Errorf("wallet %w", ErrNotFound)
+
+	ErrTransactionNotFound = fmt.Errorf("transaction %w", ErrNotFound)
+
+	ErrEmailTaken = fmt.Errorf("email %w", ErrDuplicate)
+	ErrPhoneTaken = fmt.Errorf("phone number %w", ErrDuplicate)
+
+	// ErrWalletFrozen is returned when money would move in or out of a
+	// wallet whose owner is not active, e.g. a banned user
+	ErrWalletFrozen = errors.New("wallet is frozen")
+)
+
+const pgUniqueViolation = "23505"
+
+// uniqueViolation returns the constraint a unique violation is about
+func uniqueViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return pgErr.ConstraintName, true
+	}
+
+	return "", false
+}
+
+// notFound wraps err in notFoundErr when no row was found
+func notFound(err, notFoundErr error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", notFoundErr, err)
+	}
+
+	return err
+}
+
+// userConflict maps a unique violation on users to ErrEmailTaken or ErrPhoneTaken
+func userConflict(err error) error {
+	constraint, ok := uniqueViolation(err)
+	switch {
+	case !ok:
+		return err
+	case constraint == "users_email_key" || constraint == "users_email_lower_key":
+		return fmt.Errorf("%w: %w", ErrEmailTaken, err)
+	case constraint == "users_phone_number_key":
+		return fmt.Errorf("%w: %w", ErrPhoneTaken, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrDuplicate, err)
+}
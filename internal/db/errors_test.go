@@ -0,0 +1,64 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedErrors(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var users []*User
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Typed",
+			LastName:    "Error",
+			PhoneNumber: fmt.Sprintf("555100%04d", i),
+			Email:       fmt.Sprintf("typed%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+		users = append(users, user)
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	_, err := db.GetUserByID(users[1].ID + 100)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = db.GetWalletByID(wallets[1].ID + 100)
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+
+	assert.ErrorIs(t, db.DeleteUser(users[1].ID+100), ErrUserNotFound)
+
+	taken := &User{FirstName: "Taken", LastName: "Email", PhoneNumber: "5551009999", Email: users[0].Email, Status: "active", Password: "password123"}
+	err = db.CreateUser(taken)
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	_, err = db.CreateWallet(users[0].ID, "USD")
+	assert.ErrorIs(t, err, ErrWalletExists)
+
+	amount, _ := money.Parse("10", "USD")
+	_, err = db.Deposit(wallets[0].ID, amount)
+	assert.NoError(t, err)
+
+	// a banned user can neither send nor receive
+	users[1].Status = "banned"
+	assert.NoError(t, db.UpdateUser(users[1]))
+
+	err = db.TransferFunds(&Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: amount})
+	assert.ErrorIs(t, err, ErrWalletFrozen)
+
+	_, err = db.Deposit(wallets[1].ID, amount)
+	assert.ErrorIs(t, err, ErrWalletFrozen)
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/fees"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// Transaction types fee rules can apply to
+const (
+	FeeTypeTransfer   = "transfer"
+	FeeTypeWithdrawal = "withdrawal"
+)
+
+// percentScale matches the NUMERIC(9, 6) fee_rules.percent column
+const percentScale = 6
+
+// ErrFeeRuleNotFound is returned when a fee rule does not exist
+var ErrFeeRuleNotFound = fmt.Errorf("fee rule %w", ErrNotFound)
+
+// FeeRule charges fees on one transaction type and currency for amounts
+// within the band described by the embedded fees.Rule. Fees are debited from
+// the sender on top of the amount and credited to the fees system account.
+type FeeRule struct {
+	ID       int64  `json:"id"`
+	TxType   string `json:"tx_type"`
+	Currency string `json:"currency"`
+	fees.Rule
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// validate checks the rule before it is written
+func (r *FeeRule) validate() error {
+	if r.TxType != FeeTypeTransfer && r.TxType != FeeTypeWithdrawal {
+		return fmt.Errorf("%w: unknown transaction type %q", fees.ErrInvalidRule, r.TxType)
+	}
+
+	if _, err := money.LookupCurrency(r.Currency); err != nil {
+		return err
+	}
+
+	if r.Percent == "" {
+		r.Percent = "0"
+	}
+
+	return r.Rule.Validate(r.Currency)
+}
+
+const feeRuleColumns = `id, tx_type, currency, min_amount, max_amount, flat_fee, percent::text, min_fee, max_fee, active, created_at, updated_at`
+
+// scanFeeRule reads a fee rule row selected as feeRuleColumns
+func scanFeeRule(row pgx.Row) (*FeeRule, error) {
+	r := &FeeRule{}
+	var minAmount, maxAmount, flatFee, minFee, maxFee pgtype.Numeric
+
+	err := row.Scan(&r.ID, &r.TxType, &r.Currency, &minAmount, &maxAmount, &flatFee, &r.Percent, &minFee, &maxFee, &r.Active, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.MinAmount, err = money.FromNumeric(minAmount, r.Currency); err != nil {
+		return nil, err
+	}
+	if r.FlatFee, err = money.FromNumeric(flatFee, r.Currency); err != nil {
+		return nil, err
+	}
+	if r.MinFee, err = money.FromNumeric(minFee, r.Currency); err != nil {
+		return nil, err
+	}
+	if r.MaxAmount, err = optionalMoney(maxAmount, r.Currency); err != nil {
+		return nil, err
+	}
+	if r.MaxFee, err = optionalMoney(maxFee, r.Currency); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// optionalMoney converts a nullable NUMERIC column, returning nil for NULL
+func optionalMoney(n pgtype.Numeric, currency string) (*money.Money, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	m, err := money.FromNumeric(n, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// GetFeeRules returns all fee rules ordered by type, currency and band
+func (db *DB) GetFeeRules() ([]*FeeRule, error) {
+	query := `SELECT ` + feeRuleColumns + ` FROM fee_rules ORDER BY tx_type, currency, min_amount, id`
+	rows, err := db.pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*FeeRule
+	for rows.Next() {
+		rule, err := scanFeeRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fee rule: %w", err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return rules, nil
+}
+
+// GetFeeRuleByID returns a fee rule or ErrFeeRuleNotFound
+func (db *DB) GetFeeRuleByID(id int64) (*FeeRule, error) {
+	query := `SELECT ` + feeRuleColumns + ` FROM fee_rules WHERE id = $1`
+
+	rule, err := scanFeeRule(db.pool.QueryRow(context.Background(), query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrFeeRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+// CreateFeeRule validates and stores a fee rule, filling in its ID and timestamps
+func (db *DB) CreateFeeRule(rule *FeeRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	percent, err := decimalNumeric(rule.Percent, percentScale)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO fee_rules (tx_type, currency, min_amount, max_amount, flat_fee, percent, min_fee, max_fee, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING ` + feeRuleColumns
+
+	created, err := scanFeeRule(db.pool.QueryRow(context.Background(), query, rule.TxType, rule.Currency, rule.MinAmount, rule.MaxAmount, rule.FlatFee, percent, rule.MinFee, rule.MaxFee, rule.Active))
+	if err != nil {
+		return fmt.Errorf("failed to create fee rule: %w", err)
+	}
+
+	*rule = *created
+	return nil
+}
+
+// UpdateFeeRule validates and replaces a fee rule, returning ErrFeeRuleNotFound
+// when it does not exist
+func (db *DB) UpdateFeeRule(rule *FeeRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	percent, err := decimalNumeric(rule.Percent, percentScale)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE fee_rules SET tx_type = $2, currency = $3, min_amount = $4, max_amount = $5, flat_fee = $6, percent = $7, min_fee = $8, max_fee = $9, active = $10, updated_at = NOW()
+		WHERE id = $1 RETURNING ` + feeRuleColumns
+
+	updated, err := scanFeeRule(db.pool.QueryRow(context.Background(), query, rule.ID, rule.TxType, rule.Currency, rule.MinAmount, rule.MaxAmount, rule.FlatFee, percent, rule.MinFee, rule.MaxFee, rule.Active))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrFeeRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update fee rule: %w", err)
+	}
+
+	*rule = *updated
+	return nil
+}
+
+// DeleteFeeRule removes a fee rule, returning ErrFeeRuleNotFound when it does not exist
+func (db *DB) DeleteFeeRule(id int64) error {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM fee_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete fee rule: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrFeeRuleNotFound
+	}
+
+	return nil
+}
+
+// feeFor computes the fee for a transaction of txType on amount from the
+// active rules, inside tx so a transfer sees one consistent rule set.
+func feeFor(ctx context.Context, tx pgx.Tx, txType string, amount money.Money) (money.Money, error) {
+	query := `SELECT ` + feeRuleColumns + ` FROM fee_rules WHERE tx_type = $1 AND currency = $2 AND active`
+	rows, err := tx.Query(ctx, query, txType, amount.Currency())
+	if err != nil {
+		return money.Money{}, fmt.Errorf("failed to get fee rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []fees.Rule
+	for rows.Next() {
+		rule, err := scanFeeRule(rows)
+		if err != nil {
+			return money.Money{}, fmt.Errorf("failed to scan fee rule: %w", err)
+		}
+
+		rules = append(rules, rule.Rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return money.Money{}, fmt.Errorf("rows error: %w", err)
+	}
+
+	return fees.Compute(rules, amount)
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+var (
+	ErrQuoteNotFound = fmt.Errorf("quote %w", ErrNotFound)
+	ErrQuoteExpired  = errors.New("quote has expired")
+	ErrQuoteUsed     = errors.New("quote has already been used")
+
+	// ErrQuoteMismatch is returned when a transfer does not match the quote it references
+	ErrQuoteMismatch = errors.New("transfer does not match quote")
+)
+
+// FXQuote locks an exchange rate for a user for a short time. Converting
+// Amount costs Amount plus Fee in FromCurrency and yields ConvertedAmount in
+// ToCurrency. A quote can be used by one transfer.
+type FXQuote struct {
+	ID              string      `json:"id"`
+	UserID          int64       `json:"user_id"`
+	FromCurrency    string      `json:"from_currency"`
+	ToCurrency      string      `json:"to_currency"`
+	Rate            string      `json:"rate"`
+	Amount          money.Money `json:"amount"`
+	Fee             money.Money `json:"fee"`
+	ConvertedAmount money.Money `json:"converted_amount"`
+	ExpiresAt       time.Time   `json:"expires_at"`
+	UsedAt          *time.Time  `json:"used_at,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+}
+
+// CreateFXQuote stores a quote and fills in its creation time
+func (db *DB) CreateFXQuote(q *FXQuote) error {
+	rate, err := rateNumeric(q.Rate)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO fx_quotes (id, user_id, from_currency, to_currency, rate, amount, fee, converted_amount, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING created_at`
+	err = db.pool.QueryRow(context.Background(), query, q.ID, q.UserID, q.FromCurrency, q.ToCurrency, rate, q.Amount, q.Fee, q.ConvertedAmount, q.ExpiresAt).Scan(&q.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create quote: %w", err)
+	}
+
+	return nil
+}
+
+const fxQuoteColumns = `id, user_id, from_currency, to_currency, rate::text, amount, fee, converted_amount, expires_at, used_at, created_at`
+
+// scanFXQuote reads a row selected as fxQuoteColumns
+func scanFXQuote(row pgx.Row) (*FXQuote, error) {
+	q := &FXQuote{}
+	var amount, fee, converted pgtype.Numeric
+	err := row.Scan(&q.ID, &q.UserID, &q.FromCurrency, &q.ToCurrency, &q.Rate, &amount, &fee, &converted, &q.ExpiresAt, &q.UsedAt, &q.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Amount, err = money.FromNumeric(amount, q.FromCurrency); err == nil {
+		if q.Fee, err = money.FromNumeric(fee, q.FromCurrency); err == nil {
+			q.ConvertedAmount, err = money.FromNumeric(converted, q.ToCurrency)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("quote %s: %w", q.ID, err)
+	}
+
+	return q, nil
+}
+
+// GetFXQuote returns a quote by ID, whether or not it is still usable
+func (db *DB) GetFXQuote(id string) (*FXQuote, error) {
+	q, err := scanFXQuote(db.pool.QueryRow(context.Background(), `SELECT `+fxQuoteColumns+` FROM fx_quotes WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrQuoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	return q, nil
+}
+
+// lockFXQuote selects a quote FOR UPDATE inside tx so it cannot be used twice
+func lockFXQuote(ctx context.Context, tx pgx.Tx, id string) (*FXQuote, error) {
+	q, err := scanFXQuote(tx.QueryRow(ctx, `SELECT `+fxQuoteColumns+` FROM fx_quotes WHERE id = $1 FOR UPDATE`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrQuoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock quote: %w", err)
+	}
+
+	return q, nil
+}
+
+// DeleteExpiredFXQuotes removes unused quotes that expired and returns how many were deleted
+func (db *DB) DeleteExpiredFXQuotes() (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM fx_quotes WHERE used_at IS NULL AND expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired quotes: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// rateNumeric encodes a decimal exchange rate for a NUMERIC(20, 10) column.
+// An empty rate is encoded as NULL.
+func rateNumeric(rate string) (pgtype.Numeric, error) {
+	n, err := decimalNumeric(rate, fx.RateScale)
+	if err != nil || (n.Valid && n.Int.Sign() <= 0) {
+		return pgtype.Numeric{}, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+
+	return n, nil
+}
+
+// decimalNumeric encodes a decimal string rounded half to even to scale
+// decimal places. An empty string is encoded as NULL.
+func decimalNumeric(s string, scale int32) (pgtype.Numeric, error) {
+	if s == "" {
+		return pgtype.Numeric{}, nil
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return pgtype.Numeric{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := money.RoundHalfEven(r.Mul(r, new(big.Rat).SetInt(factor)))
+
+	return pgtype.Numeric{Int: scaled, Exp: -scale, Valid: true}, nil
+}
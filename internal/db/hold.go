@@ -0,0 +1,324 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// Statuses of a hold
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+var (
+	ErrHoldNotFound = fmt.Errorf("hold %w", ErrNotFound)
+
+	// ErrHoldNotActive is returned when a hold that was captured, voided or
+	// has expired is captured or voided
+	ErrHoldNotActive = errors.New("hold is no longer active")
+
+	// ErrCaptureExceedsHold is returned when a capture is larger than its hold
+	ErrCaptureExceedsHold = errors.New("capture exceeds the held amount")
+)
+
+// Hold reserves Amount of a wallet's balance for a payee wallet until
+// ExpiresAt. Capturing it moves up to Amount to the payee; voiding or
+// expiring it releases the reservation. TransactionID is the hold's send
+// record, pending while the hold is active.
+type Hold struct {
+	ID             int64        `json:"id"`
+	WalletID       int64        `json:"wallet_id"`
+	PayeeWalletID  int64        `json:"payee_wallet_id"`
+	Amount         money.Money  `json:"amount"`
+	CapturedAmount *money.Money `json:"captured_amount,omitempty"`
+	Currency       string       `json:"currency"`
+	Note           string       `json:"note"`
+	Status         string       `json:"status"`
+	TransactionID  int64        `json:"transaction_id"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+const holdColumns = `h.id, h.wallet_id, h.payee_wallet_id, h.amount, h.captured_amount, h.currency, COALESCE(t.note, ''), h.status, h.transaction_id, h.expires_at, h.created_at, h.updated_at`
+
+// scanHold reads a holds row aliased h, joined with its transaction t and
+// selected as holdColumns. An active hold past its expiry is reported as
+// expired before ExpireHolds gets to it.
+func scanHold(row pgx.Row) (*Hold, error) {
+	h := &Hold{}
+	var amount, captured pgtype.Numeric
+
+	err := row.Scan(&h.ID, &h.WalletID, &h.PayeeWalletID, &amount, &captured, &h.Currency, &h.Note, &h.Status, &h.TransactionID, &h.ExpiresAt, &h.CreatedAt, &h.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Amount, err = money.FromNumeric(amount, h.Currency); err != nil {
+		return nil, fmt.Errorf("hold %d: %w", h.ID, err)
+	}
+
+	if captured.Valid {
+		c, err := money.FromNumeric(captured, h.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("hold %d: %w", h.ID, err)
+		}
+		h.CapturedAmount = &c
+	}
+
+	if h.Status == HoldActive && !h.ExpiresAt.After(time.Now()) {
+		h.Status = HoldExpired
+	}
+
+	return h, nil
+}
+
+// PlaceHold reserves h.Amount of h.WalletID for h.PayeeWalletID until
+// h.ExpiresAt and fills in the rest of h. The reservation is checked against
+// and taken off the wallet's available balance; no money moves until the hold
+// is captured.
+func (db *DB) PlaceHold(h *Hold) error {
+	if !h.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
+	}
+
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		wallet, payee, err := lockWalletPair(ctx, tx, h.WalletID, h.PayeeWalletID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkNotFrozen(ctx, tx, wallet, payee); err != nil {
+			return err
+		}
+
+		if wallet.Currency != h.Amount.Currency() || payee.Currency != h.Amount.Currency() {
+			return fmt.Errorf("%w: cannot hold %s between %s and %s wallets", money.ErrCurrencyMismatch, h.Amount.Currency(), wallet.Currency, payee.Currency)
+		}
+
+		if err := checkFunds(wallet, h.Amount); err != nil {
+			return err
+		}
+
+		send := &Transaction{Type: "send", FromWalletID: h.WalletID, ToWalletID: h.PayeeWalletID, Amount: h.Amount, Note: h.Note, Status: "pending"}
+		if err := insertTransaction(ctx, tx, send); err != nil {
+			return fmt.Errorf("failed to log hold transaction: %w", err)
+		}
+
+		h.Currency = h.Amount.Currency()
+		h.Status = HoldActive
+		h.TransactionID = send.ID
+
+		query := `INSERT INTO holds (wallet_id, payee_wallet_id, amount, currency, status, transaction_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at`
+		err = tx.QueryRow(ctx, query, h.WalletID, h.PayeeWalletID, h.Amount, h.Currency, h.Status, h.TransactionID, h.ExpiresAt).Scan(&h.ID, &h.CreatedAt, &h.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create hold: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// GetHold returns a hold by ID
+func (db *DB) GetHold(id int64) (*Hold, error) {
+	query := `SELECT ` + holdColumns + ` FROM holds h JOIN transactions t ON t.id = h.transaction_id WHERE h.id = $1`
+	h, err := scanHold(db.pool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hold: %w", notFound(err, ErrHoldNotFound))
+	}
+
+	return h, nil
+}
+
+// GetHoldsByWalletID returns the holds placed by or for a wallet, newest first
+func (db *DB) GetHoldsByWalletID(walletID int64) ([]*Hold, error) {
+	query := `SELECT ` + holdColumns + ` FROM holds h JOIN transactions t ON t.id = h.transaction_id
+		WHERE h.wallet_id = $1 OR h.payee_wallet_id = $1 ORDER BY h.id DESC`
+	rows, err := db.pool.Query(context.Background(), query, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []*Hold
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hold: %w", err)
+		}
+		holds = append(holds, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return holds, nil
+}
+
+// lockHold locks the wallets of a hold in ID order, then the hold itself, so
+// holds are changed under the same locks as the balances they reserve
+func lockHold(ctx context.Context, tx pgx.Tx, id int64) (*Hold, *Wallet, *Wallet, error) {
+	var walletID, payeeWalletID int64
+	err := tx.QueryRow(ctx, `SELECT wallet_id, payee_wallet_id FROM holds WHERE id = $1`, id).Scan(&walletID, &payeeWalletID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get hold: %w", notFound(err, ErrHoldNotFound))
+	}
+
+	wallet, payee, err := lockWalletPair(ctx, tx, walletID, payeeWalletID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	query := `SELECT ` + holdColumns + ` FROM holds h JOIN transactions t ON t.id = h.transaction_id WHERE h.id = $1 FOR UPDATE OF h`
+	h, err := scanHold(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to lock hold: %w", notFound(err, ErrHoldNotFound))
+	}
+
+	return h, wallet, payee, nil
+}
+
+// CaptureHold moves amount of an active hold from its wallet to the payee
+// and completes the hold's send record with a matching receive record. A
+// zero amount captures the whole hold; a smaller one captures part of it and
+// releases the rest. No fee is charged.
+func (db *DB) CaptureHold(id int64, amount money.Money) (*Hold, error) {
+	var h *Hold
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var wallet, payee *Wallet
+		var err error
+		h, wallet, payee, err = lockHold(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if h.Status != HoldActive {
+			return ErrHoldNotActive
+		}
+
+		if err := checkNotFrozen(ctx, tx, wallet, payee); err != nil {
+			return err
+		}
+
+		if amount.IsZero() {
+			amount = h.Amount
+		}
+		if !amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
+		}
+		if cmp, err := amount.Cmp(h.Amount); err != nil {
+			return err
+		} else if cmp > 0 {
+			return ErrCaptureExceedsHold
+		}
+
+		// the hold's own reservation is part of what is held, so only the
+		// ledger balance needs to cover the capture
+		if cmp, _ := wallet.Balance.Cmp(amount); cmp < 0 {
+			return ErrInsufficientFunds
+		}
+
+		debit, err := walletLeg(ctx, tx, wallet, amount.Neg())
+		if err != nil {
+			return err
+		}
+
+		credit, err := walletLeg(ctx, tx, payee, amount)
+		if err != nil {
+			return err
+		}
+
+		entryID, err := postJournal(ctx, tx, "hold capture", debit, credit)
+		if err != nil {
+			return err
+		}
+
+		query := `UPDATE transactions SET amount = $2, status = 'completed', journal_entry_id = $3, updated_at = NOW() WHERE id = $1`
+		if _, err := tx.Exec(ctx, query, h.TransactionID, amount, entryID); err != nil {
+			return fmt.Errorf("failed to complete hold transaction: %w", err)
+		}
+
+		receive := &Transaction{Type: "receive", FromWalletID: h.PayeeWalletID, ToWalletID: h.WalletID, Amount: amount, Note: h.Note, Status: "completed", JournalEntryID: entryID}
+		if err := insertTransaction(ctx, tx, receive); err != nil {
+			return fmt.Errorf("failed to log receiver transaction: %w", err)
+		}
+
+		h.Status, h.CapturedAmount = HoldCaptured, &amount
+		query = `UPDATE holds SET status = $2, captured_amount = $3, updated_at = NOW() WHERE id = $1 RETURNING updated_at`
+		if err := tx.QueryRow(ctx, query, h.ID, h.Status, amount).Scan(&h.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to capture hold: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// VoidHold releases an active hold without moving money and cancels its send record
+func (db *DB) VoidHold(id int64) (*Hold, error) {
+	var h *Hold
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		h, _, _, err = lockHold(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if h.Status != HoldActive {
+			return ErrHoldNotActive
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE transactions SET status = 'cancelled', updated_at = NOW() WHERE id = $1`, h.TransactionID); err != nil {
+			return fmt.Errorf("failed to cancel hold transaction: %w", err)
+		}
+
+		h.Status = HoldVoided
+		query := `UPDATE holds SET status = $2, updated_at = NOW() WHERE id = $1 RETURNING updated_at`
+		if err := tx.QueryRow(ctx, query, h.ID, h.Status).Scan(&h.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to void hold: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// ExpireHolds marks active holds past their expiry as expired, cancels their
+// send records and returns how many expired. Expired holds stop counting
+// against the available balance as soon as they expire; this only catches
+// their status up.
+func (db *DB) ExpireHolds() (int64, error) {
+	query := `WITH expired AS (
+			UPDATE holds SET status = 'expired', updated_at = NOW()
+			WHERE status = 'active' AND expires_at <= NOW()
+			RETURNING transaction_id
+		)
+		UPDATE transactions SET status = 'cancelled', updated_at = NOW() WHERE id IN (SELECT transaction_id FROM expired)`
+	tag, err := db.pool.Exec(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire holds: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldsReserveCaptureAndVoid(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Hold",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555300%04d", i),
+			Email:       fmt.Sprintf("hold%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+	payer, payee := wallets[0], wallets[1]
+
+	hundred, _ := money.Parse("100", "USD")
+	_, err := db.Deposit(payer.ID, hundred)
+	assert.NoError(t, err)
+
+	sixty, _ := money.Parse("60", "USD")
+	hold := &Hold{WalletID: payer.ID, PayeeWalletID: payee.ID, Amount: sixty, Note: "hotel", ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, db.PlaceHold(hold))
+	assert.Equal(t, HoldActive, hold.Status)
+
+	// the hold reduces the available balance but not the ledger balance
+	wallet, err := db.GetWalletByID(payer.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "100.00", wallet.Balance.String())
+	assert.Equal(t, "40.00", wallet.AvailableBalance.String())
+
+	// neither a second hold nor a withdrawal can spend the reserved money
+	assert.ErrorIs(t, db.PlaceHold(&Hold{WalletID: payer.ID, PayeeWalletID: payee.ID, Amount: sixty, ExpiresAt: time.Now().Add(time.Hour)}), ErrInsufficientFunds)
+	_, err = db.Withdraw(payer.ID, sixty)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	seventy, _ := money.Parse("70", "USD")
+	_, err = db.CaptureHold(hold.ID, seventy)
+	assert.ErrorIs(t, err, ErrCaptureExceedsHold)
+
+	// a partial capture moves that much and releases the rest
+	fortyFive, _ := money.Parse("45", "USD")
+	captured, err := db.CaptureHold(hold.ID, fortyFive)
+	assert.NoError(t, err)
+	assert.Equal(t, HoldCaptured, captured.Status)
+	assert.Equal(t, "45.00", captured.CapturedAmount.String())
+
+	wallet, _ = db.GetWalletByID(payer.ID)
+	assert.Equal(t, "55.00", wallet.Balance.String())
+	assert.Equal(t, "55.00", wallet.AvailableBalance.String())
+	wallet, _ = db.GetWalletByID(payee.ID)
+	assert.Equal(t, "45.00", wallet.Balance.String())
+
+	_, err = db.CaptureHold(hold.ID, money.Money{})
+	assert.ErrorIs(t, err, ErrHoldNotActive)
+
+	page, _, err := db.GetTransactions(TransactionQuery{WalletID: payee.ID})
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "receive", page[0].Type)
+		assert.Equal(t, "45.00", page[0].Amount.String())
+	}
+
+	// a voided hold releases its reservation and cancels its record
+	ten, _ := money.Parse("10", "USD")
+	voided := &Hold{WalletID: payer.ID, PayeeWalletID: payee.ID, Amount: ten, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, db.PlaceHold(voided))
+	_, err = db.VoidHold(voided.ID)
+	assert.NoError(t, err)
+
+	page, _, err = db.GetTransactions(TransactionQuery{WalletID: payer.ID, Statuses: []string{"cancelled"}})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+
+	// an expired hold no longer counts and cannot be captured
+	expired := &Hold{WalletID: payer.ID, PayeeWalletID: payee.ID, Amount: ten, ExpiresAt: time.Now().Add(-time.Second)}
+	assert.NoError(t, db.PlaceHold(expired))
+	wallet, _ = db.GetWalletByID(payer.ID)
+	assert.Equal(t, "55.00", wallet.AvailableBalance.String())
+
+	_, err = db.CaptureHold(expired.ID, money.Money{})
+	assert.ErrorIs(t, err, ErrHoldNotActive)
+
+	n, err := db.ExpireHolds()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	holds, err := db.GetHoldsByWalletID(payee.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, holds, 3) {
+		assert.Equal(t, HoldExpired, holds[0].Status)
+		assert.Equal(t, HoldVoided, holds[1].Status)
+		assert.Equal(t, "hotel", holds[2].Note)
+	}
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IdempotencyKey records the outcome of a request sent with an Idempotency-Key header
+type IdempotencyKey struct {
+	UserID         int64
+	Key            string
+	Fingerprint    string
+	ResponseStatus int // 0 while the first request is still in flight
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// ReserveIdempotencyKey claims key for the user. It returns reserved = true
+// when the caller should process the request, either because the key is new
+// or because its previous use has expired. Otherwise the stored record is
+// returned so the caller can replay or reject the request.
+func (db *DB) ReserveIdempotencyKey(userID int64, key, fingerprint string, ttl time.Duration) (*IdempotencyKey, bool, error) {
+	ctx := context.Background()
+	record := &IdempotencyKey{}
+	var status *int
+
+	query := `INSERT INTO idempotency_keys (user_id, key, request_fingerprint, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE SET request_fingerprint = EXCLUDED.request_fingerprint, response_status = NULL, response_body = NULL, created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING user_id, key, request_fingerprint, response_status, response_body, created_at, expires_at`
+	err := db.pool.QueryRow(ctx, query, userID, key, fingerprint, time.Now().Add(ttl)).Scan(&record.UserID, &record.Key, &record.Fingerprint, &status, &record.ResponseBody, &record.CreatedAt, &record.ExpiresAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	// The key is held by an earlier, unexpired request.
+	query = `SELECT user_id, key, request_fingerprint, response_status, response_body, created_at, expires_at FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	err = db.pool.QueryRow(ctx, query, userID, key).Scan(&record.UserID, &record.Key, &record.Fingerprint, &status, &record.ResponseBody, &record.CreatedAt, &record.ExpiresAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	if status != nil {
+		record.ResponseStatus = *status
+	}
+
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response produced for a reserved key
+func (db *DB) CompleteIdempotencyKey(userID int64, key string, status int, body []byte) error {
+	query := `UPDATE idempotency_keys SET response_status = $1, response_body = $2 WHERE user_id = $3 AND key = $4`
+	_, err := db.pool.Exec(context.Background(), query, status, body, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey removes a reserved key so the request can be retried
+func (db *DB) ReleaseIdempotencyKey(userID int64, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	_, err := db.pool.Exec(context.Background(), query, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys purges keys whose replay window has passed
+func (db *DB) DeleteExpiredIdempotencyKeys() (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// BalanceMismatch reports a wallet whose stored balance disagrees with its
+// transaction history or its ledger postings.
+type BalanceMismatch struct {
+	WalletID        int64       `json:"wallet_id"`
+	Currency        string      `json:"currency"`
+	StoredBalance   money.Money `json:"stored_balance"`
+	ReplayedBalance money.Money `json:"replayed_balance"`
+	LedgerBalance   money.Money `json:"ledger_balance"`
+}
+
+// MissingWalletRef reports a transaction that points at a wallet which does not exist
+type MissingWalletRef struct {
+	TransactionID int64  `json:"transaction_id"`
+	Column        string `json:"column"`
+	WalletID      int64  `json:"wallet_id"`
+}
+
+// LedgerReport is the result of CheckLedger
+type LedgerReport struct {
+	CheckedAt            time.Time          `json:"checked_at"`
+	WalletsChecked       int                `json:"wallets_checked"`
+	TransactionsReplayed int                `json:"transactions_replayed"`
+	Mismatches           []BalanceMismatch  `json:"mismatches"`
+	OrphanReceives       []*Transaction     `json:"orphan_receives"`
+	MissingWallets       []MissingWalletRef `json:"missing_wallets"`
+}
+
+// HasDrift reports whether the check found any problem
+func (r *LedgerReport) HasDrift() bool {
+	return len(r.Mismatches) > 0 || len(r.OrphanReceives) > 0 || len(r.MissingWallets) > 0
+}
+
+// transactionEffect returns how a completed transaction changes the balance
+// of the wallet that owns it (its from_wallet_id).
+func transactionEffect(t *Transaction) (money.Money, error) {
+	switch t.Type {
+	case "deposit", "receive":
+		return t.Amount, nil
+	case "withdrawal", "send":
+		total, err := t.Amount.Add(t.Fee)
+		if err != nil {
+			return money.Money{}, err
+		}
+		return total.Neg(), nil
+	default:
+		return money.Money{}, fmt.Errorf("unknown transaction type %q", t.Type)
+	}
+}
+
+// CheckLedger replays every completed transaction per wallet and compares the
+// result with the stored wallet balance and the wallet's ledger postings. A
+// transfer cancelled by a full reversal still moved its money and is replayed
+// along with the reversal. It also reports receive records without a matching
+// send and transactions that reference missing wallets. Every query reads the
+// same snapshot, so transfers committed during the check do not show up as
+// drift.
+func (db *DB) CheckLedger() (*LedgerReport, error) {
+	ctx := context.Background()
+	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	report := &LedgerReport{CheckedAt: time.Now()}
+
+	replayed := make(map[int64]money.Money)
+	rows, err := tx.Query(ctx, `SELECT id, type, from_wallet_id, amount, fee, currency FROM transactions WHERE status = 'completed' OR refunded_amount > 0 ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	for rows.Next() {
+		t := &Transaction{}
+		var amount, fee pgtype.Numeric
+		if err := rows.Scan(&t.ID, &t.Type, &t.FromWalletID, &amount, &fee, &t.Currency); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		if t.Amount, err = money.FromNumeric(amount, t.Currency); err == nil {
+			t.Fee, err = money.FromNumeric(fee, t.Currency)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+
+		effect, err := transactionEffect(t)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+
+		balance, ok := replayed[t.FromWalletID]
+		if !ok {
+			balance = money.Zero(t.Currency)
+		}
+
+		if replayed[t.FromWalletID], err = balance.Add(effect); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+
+		report.TransactionsReplayed++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	query := `SELECT w.id, w.currency, w.balance, COALESCE(SUM(p.amount), 0) FROM wallets w
+		LEFT JOIN ledger_accounts a ON a.wallet_id = w.id
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY w.id ORDER BY w.id`
+	rows, err = tx.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallets: %w", err)
+	}
+
+	for rows.Next() {
+		m := BalanceMismatch{}
+		var stored, ledger pgtype.Numeric
+		if err := rows.Scan(&m.WalletID, &m.Currency, &stored, &ledger); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan wallet: %w", err)
+		}
+
+		if m.StoredBalance, err = money.FromNumeric(stored, m.Currency); err == nil {
+			m.LedgerBalance, err = money.FromNumeric(ledger, m.Currency)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("wallet %d: %w", m.WalletID, err)
+		}
+
+		m.ReplayedBalance = money.Zero(m.Currency)
+		if balance, ok := replayed[m.WalletID]; ok {
+			m.ReplayedBalance = balance
+		}
+
+		if m.StoredBalance != m.ReplayedBalance || m.StoredBalance != m.LedgerBalance {
+			report.Mismatches = append(report.Mismatches, m)
+		}
+
+		report.WalletsChecked++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	if report.OrphanReceives, err = orphanReceives(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if report.MissingWallets, err = missingWalletRefs(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// orphanReceives finds receive records with no send record for the same
+// transfer. Transfers written through the ledger share a journal entry; older
+// records are matched on wallets, amount and creation time.
+func orphanReceives(ctx context.Context, tx pgx.Tx) ([]*Transaction, error) {
+	query := `SELECT r.id, r.type, r.from_wallet_id, r.to_wallet_id, r.amount, r.fee, r.currency, r.status, r.created_at FROM transactions r
+		WHERE r.type = 'receive' AND NOT EXISTS (
+			SELECT 1 FROM transactions s
+			WHERE s.type = 'send' AND s.from_wallet_id = r.to_wallet_id AND s.to_wallet_id = r.from_wallet_id
+			AND (s.journal_entry_id = r.journal_entry_id OR (r.journal_entry_id IS NULL AND s.amount = r.amount AND s.created_at = r.created_at))
+		) ORDER BY r.id`
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find orphan receive transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var orphans []*Transaction
+	for rows.Next() {
+		t := &Transaction{}
+		var amount, fee pgtype.Numeric
+		if err := rows.Scan(&t.ID, &t.Type, &t.FromWalletID, &t.ToWalletID, &amount, &fee, &t.Currency, &t.Status, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		if t.Amount, err = money.FromNumeric(amount, t.Currency); err == nil {
+			t.Fee, err = money.FromNumeric(fee, t.Currency)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+
+		orphans = append(orphans, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return orphans, nil
+}
+
+// missingWalletRefs finds transactions whose from_wallet_id or to_wallet_id
+// does not match a wallet. A to_wallet_id of 0 means the transaction has no
+// counter-party wallet.
+func missingWalletRefs(ctx context.Context, tx pgx.Tx) ([]MissingWalletRef, error) {
+	query := `SELECT t.id, 'from_wallet_id', t.from_wallet_id FROM transactions t
+			WHERE NOT EXISTS (SELECT 1 FROM wallets w WHERE w.id = t.from_wallet_id)
+		UNION ALL
+		SELECT t.id, 'to_wallet_id', t.to_wallet_id FROM transactions t
+			WHERE t.to_wallet_id IS NOT NULL AND t.to_wallet_id <> 0 AND NOT EXISTS (SELECT 1 FROM wallets w WHERE w.id = t.to_wallet_id)
+		ORDER BY 1`
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transactions with missing wallets: %w", err)
+	}
+	defer rows.Close()
+
+	var refs []MissingWalletRef
+	for rows.Next() {
+		var ref MissingWalletRef
+		if err := rows.Scan(&ref.TransactionID, &ref.Column, &ref.WalletID); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		refs = append(refs, ref)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return refs, nil
+}
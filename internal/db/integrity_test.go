@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLedger(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for _, u := range []struct{ phone, email string }{
+		{"6660001111", "checker1@example.com"},
+		{"6660002222", "checker2@example.com"},
+	} {
+		user := &User{
+			FirstName:   "Check",
+			LastName:    "User",
+			PhoneNumber: u.phone,
+			Email:       u.email,
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	deposit, _ := money.Parse("80", "USD")
+	_, err := db.Deposit(wallets[0].ID, deposit)
+	assert.NoError(t, err)
+
+	amount, _ := money.Parse("30", "USD")
+	assert.NoError(t, db.TransferFunds(&Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: amount}))
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.Equal(t, 2, report.WalletsChecked)
+	assert.Equal(t, 3, report.TransactionsReplayed)
+
+	// Drift the cached balance and record a receive with no matching send
+	_, err = db.pool.Exec(context.Background(), `UPDATE wallets SET balance = balance + 1 WHERE id = $1`, wallets[0].ID)
+	assert.NoError(t, err)
+
+	orphan := &Transaction{Type: "receive", FromWalletID: wallets[1].ID, ToWalletID: wallets[0].ID, Amount: amount, Status: "failed"}
+	assert.NoError(t, db.CreateTransaction(orphan))
+
+	report, err = db.CheckLedger()
+	assert.NoError(t, err)
+	assert.True(t, report.HasDrift())
+	assert.Len(t, report.Mismatches, 1)
+	assert.Equal(t, wallets[0].ID, report.Mismatches[0].WalletID)
+	assert.Equal(t, "51.00", report.Mismatches[0].StoredBalance.String())
+	assert.Equal(t, "50.00", report.Mismatches[0].ReplayedBalance.String())
+	assert.Len(t, report.OrphanReceives, 1)
+	assert.Equal(t, orphan.ID, report.OrphanReceives[0].ID)
+}
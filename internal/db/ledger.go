@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// System ledger accounts that act as the counter-party for money entering
+// or leaving the platform. There is one account per code and currency.
+const (
+	SystemAccountCashIn  = "cash_in"
+	SystemAccountCashOut = "cash_out"
+	SystemAccountFees    = "fees"
+
+	// SystemAccountFX is the platform's position in each currency; currency
+	// conversions pay in to it in one currency and out of it in another.
+	SystemAccountFX = "fx"
+)
+
+// ErrUnbalancedEntry is returned when the postings of a journal entry do not sum to zero
+var ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+
+// LedgerAccount is an account in the double-entry ledger. It belongs either
+// to a wallet or to the platform (SystemCode set).
+type LedgerAccount struct {
+	ID         int64     `json:"id"`
+	WalletID   *int64    `json:"wallet_id,omitempty"`
+	SystemCode string    `json:"system_code,omitempty"`
+	Currency   string    `json:"currency"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Posting is one leg of a journal entry. Amounts are signed: a positive
+// amount credits the account (increasing a wallet balance) and a negative
+// amount debits it.
+type Posting struct {
+	ID             int64       `json:"id"`
+	JournalEntryID int64       `json:"journal_entry_id"`
+	AccountID      int64       `json:"account_id"`
+	Amount         money.Money `json:"amount"`
+	Currency       string      `json:"currency"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// ledgerLeg describes a posting before it is written. walletID is set for
+// wallet accounts so the cached wallet balance can be updated with it.
+type ledgerLeg struct {
+	accountID int64
+	walletID  int64
+	amount    money.Money
+}
+
+// walletLeg returns a posting leg against the ledger account of a wallet,
+// creating the account on first use.
+func walletLeg(ctx context.Context, tx pgx.Tx, wallet *Wallet, amount money.Money) (ledgerLeg, error) {
+	query := `INSERT INTO ledger_accounts (wallet_id, currency) VALUES ($1, $2) ON CONFLICT (wallet_id) DO UPDATE SET currency = EXCLUDED.currency RETURNING id`
+
+	var accountID int64
+	if err := tx.QueryRow(ctx, query, wallet.ID, wallet.Currency).Scan(&accountID); err != nil {
+		return ledgerLeg{}, fmt.Errorf("failed to get wallet ledger account: %w", err)
+	}
+
+	return ledgerLeg{accountID: accountID, walletID: wallet.ID, amount: amount}, nil
+}
+
+// systemLeg returns a posting leg against a system account in the currency
+// of amount, creating the account on first use.
+func systemLeg(ctx context.Context, tx pgx.Tx, code string, amount money.Money) (ledgerLeg, error) {
+	query := `INSERT INTO ledger_accounts (system_code, currency) VALUES ($1, $2) ON CONFLICT (system_code, currency) DO UPDATE SET system_code = EXCLUDED.system_code RETURNING id`
+
+	var accountID int64
+	if err := tx.QueryRow(ctx, query, code, amount.Currency()).Scan(&accountID); err != nil {
+		return ledgerLeg{}, fmt.Errorf("failed to get %s ledger account: %w", code, err)
+	}
+
+	return ledgerLeg{accountID: accountID, amount: amount}, nil
+}
+
+// postJournal writes a balanced journal entry and applies the wallet legs to
+// the cached wallet balances. It is the only place wallet balances change.
+func postJournal(ctx context.Context, tx pgx.Tx, description string, legs ...ledgerLeg) (int64, error) {
+	totals := make(map[string]money.Money)
+	for _, leg := range legs {
+		total, err := leg.amount.Add(totalFor(totals, leg.amount.Currency()))
+		if err != nil {
+			return 0, err
+		}
+		totals[leg.amount.Currency()] = total
+	}
+
+	for _, total := range totals {
+		if !total.IsZero() {
+			return 0, fmt.Errorf("%w: %s %s left over", ErrUnbalancedEntry, total, total.Currency())
+		}
+	}
+
+	var entryID int64
+	err := tx.QueryRow(ctx, `INSERT INTO journal_entries (description) VALUES ($1) RETURNING id`, description).Scan(&entryID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create journal entry: %w", err)
+	}
+
+	for _, leg := range legs {
+		if leg.amount.IsZero() {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO postings (journal_entry_id, account_id, amount, currency) VALUES ($1, $2, $3, $4)`, entryID, leg.accountID, leg.amount, leg.amount.Currency())
+		if err != nil {
+			return 0, fmt.Errorf("failed to create posting: %w", err)
+		}
+
+		if leg.walletID != 0 {
+			_, err = tx.Exec(ctx, `UPDATE wallets SET balance = balance + $1, updated_at = NOW() WHERE id = $2`, leg.amount, leg.walletID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to update wallet balance: %w", err)
+			}
+		}
+	}
+
+	return entryID, nil
+}
+
+func totalFor(totals map[string]money.Money, currency string) money.Money {
+	if total, ok := totals[currency]; ok {
+		return total
+	}
+
+	return money.Zero(currency)
+}
+
+// LedgerBalance sums the postings of a wallet's ledger account
+func (db *DB) LedgerBalance(walletID int64) (money.Money, error) {
+	return ledgerBalance(context.Background(), db.pool, walletID)
+}
+
+// RecomputeWalletBalance rebuilds the cached balance of a wallet from its
+// postings and returns the new balance.
+func (db *DB) RecomputeWalletBalance(walletID int64) (money.Money, error) {
+	var balance money.Money
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockWallet(ctx, tx, walletID); err != nil {
+			return fmt.Errorf("failed to lock wallet: %w", err)
+		}
+
+		var err error
+		balance, err = ledgerBalance(ctx, tx, walletID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE wallets SET balance = $1, updated_at = NOW() WHERE id = $2`, balance, walletID)
+		if err != nil {
+			return fmt.Errorf("failed to update wallet balance: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return balance, nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func ledgerBalance(ctx context.Context, q querier, walletID int64) (money.Money, error) {
+	query := `SELECT w.currency, COALESCE(SUM(p.amount), 0) FROM wallets w
+		LEFT JOIN ledger_accounts a ON a.wallet_id = w.id
+		LEFT JOIN postings p ON p.account_id = a.id
+		WHERE w.id = $1 GROUP BY w.currency`
+
+	var currency string
+	var sum pgtype.Numeric
+	if err := q.QueryRow(ctx, query, walletID).Scan(&currency, &sum); err != nil {
+		return money.Money{}, fmt.Errorf("failed to sum wallet postings: %w", err)
+	}
+
+	return money.FromNumeric(sum, currency)
+}
+
+// GetPostingsByWalletID returns the postings of a wallet's ledger account, newest first
+func (db *DB) GetPostingsByWalletID(walletID int64) ([]*Posting, error) {
+	query := `SELECT p.id, p.journal_entry_id, p.account_id, p.amount, p.currency, p.created_at FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.wallet_id = $1 ORDER BY p.id DESC`
+	rows, err := db.pool.Query(context.Background(), query, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get postings: %w", err)
+	}
+	defer rows.Close()
+
+	var postings []*Posting
+	for rows.Next() {
+		p := &Posting{}
+		var amount pgtype.Numeric
+		if err := rows.Scan(&p.ID, &p.JournalEntryID, &p.AccountID, &amount, &p.Currency, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan posting: %w", err)
+		}
+
+		if p.Amount, err = money.FromNumeric(amount, p.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan posting amount: %w", err)
+		}
+
+		postings = append(postings, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return postings, nil
+}
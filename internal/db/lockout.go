@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Subjects failed logins are counted for. Accounts are keyed by the
+// lower-cased email that was tried, whether or not it is registered, so
+// lockouts do not reveal which emails exist. Wrong TOTP codes outside the
+// login and wrong contact verification codes are counted by user ID.
+// Password reset requests are counted by email and by IP address.
+const (
+	LoginSubjectAccount = "account"
+	LoginSubjectIP      = "ip"
+	LoginSubjectTOTP    = "totp"
+	LoginSubjectVerify  = "verify"
+	LoginSubjectReset   = "reset"
+	LoginSubjectResetIP = "reset_ip"
+)
+
+// Lockout event types
+const (
+	LockoutEventLocked   = "locked"
+	LockoutEventUnlocked = "unlocked"
+)
+
+// LoginFailures counts recent failed logins for an account or IP address
+type LoginFailures struct {
+	Subject       string     `json:"subject"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// LockoutEvent records a lockout, or an admin lifting one
+type LockoutEvent struct {
+	ID          int64      `json:"id"`
+	Subject     string     `json:"subject"`
+	Key         string     `json:"key"`
+	Event       string     `json:"event"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	ActorID     *int64     `json:"actor_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// LockoutPolicy decides when repeated failures lock a subject out
+type LockoutPolicy struct {
+	// MaxFailures within Window lock the subject out for Lockout
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+}
+
+// GetLoginFailures returns the failure counter of a subject, or nil when it
+// has none
+func (db *DB) GetLoginFailures(subject, key string) (*LoginFailures, error) {
+	f := &LoginFailures{Subject: subject, Key: key}
+	query := `SELECT failures, last_failure_at, locked_until FROM login_failures WHERE subject = $1 AND key = $2`
+	err := db.pool.QueryRow(context.Background(), query, subject, key).Scan(&f.Failures, &f.LastFailureAt, &f.LockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	return f, nil
+}
+
+// LoginCounter is a failure counter a login attempt is checked and counted
+// against
+type LoginCounter struct {
+	Subject string
+	Key     string
+	Policy  LockoutPolicy
+	// Delay returns how long the subject waits after its failures-th
+	// failure; nil means it does not wait
+	Delay func(failures int) time.Duration
+}
+
+// ReserveLoginAttempt checks an attempt against counters and, when none of
+// them makes it wait, counts it as failed before the credentials are checked.
+// The rows are locked for both, so parallel attempts see each other's
+// failures. A successful attempt takes its failure back with
+// ClearLoginFailures or ReleaseLoginAttempt.
+//
+// Failures older than the policy window, or from before an expired lockout,
+// start the count over. A subject with MaxFailures failures is locked out on
+// its next attempt, and a lockout event recorded. The returned duration is
+// how long the attempt has to wait; nothing is counted when it is not zero.
+func (db *DB) ReserveLoginAttempt(counters ...LoginCounter) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		failures := make([]int, len(counters))
+		for i, c := range counters {
+			f, err := lockLoginFailures(ctx, tx, c.Subject, c.Key, now)
+			if err != nil {
+				return err
+			}
+
+			if f.LastFailureAt.Before(now.Add(-c.Policy.Window)) || (f.LockedUntil != nil && !f.LockedUntil.After(now)) {
+				f.Failures, f.LockedUntil = 0, nil
+			}
+			failures[i] = f.Failures
+
+			if f.LockedUntil == nil && c.Policy.MaxFailures > 0 && f.Failures >= c.Policy.MaxFailures {
+				lockedUntil := now.Add(c.Policy.Lockout)
+				f.LockedUntil = &lockedUntil
+				if _, err := tx.Exec(ctx, `UPDATE login_failures SET locked_until = $3 WHERE subject = $1 AND key = $2`, c.Subject, c.Key, lockedUntil); err != nil {
+					return fmt.Errorf("failed to lock out %s: %w", c.Subject, err)
+				}
+
+				err := insertLockoutEvent(ctx, tx, &LockoutEvent{Subject: c.Subject, Key: c.Key, Event: LockoutEventLocked, Failures: f.Failures, LockedUntil: f.LockedUntil})
+				if err != nil {
+					return err
+				}
+			}
+
+			ready := f.LastFailureAt
+			if c.Delay != nil {
+				ready = ready.Add(c.Delay(f.Failures))
+			}
+			if f.LockedUntil != nil && f.LockedUntil.After(ready) {
+				ready = *f.LockedUntil
+			}
+			if ready.Sub(now) > wait {
+				wait = ready.Sub(now)
+			}
+		}
+
+		if wait > 0 {
+			return nil
+		}
+
+		for i, c := range counters {
+			query := `UPDATE login_failures SET failures = $3, last_failure_at = $4, locked_until = NULL WHERE subject = $1 AND key = $2`
+			if _, err := tx.Exec(ctx, query, c.Subject, c.Key, failures[i]+1, now); err != nil {
+				return fmt.Errorf("failed to record login failure: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return wait, nil
+}
+
+// lockLoginFailures locks the failure counter of a subject for the rest of
+// tx, creating an empty one when it has none
+func lockLoginFailures(ctx context.Context, tx pgx.Tx, subject, key string, now time.Time) (*LoginFailures, error) {
+	query := `INSERT INTO login_failures (subject, key, failures, last_failure_at) VALUES ($1, $2, 0, $3) ON CONFLICT (subject, key) DO NOTHING`
+	if _, err := tx.Exec(ctx, query, subject, key, now); err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	f := &LoginFailures{Subject: subject, Key: key}
+	query = `SELECT failures, last_failure_at, locked_until FROM login_failures WHERE subject = $1 AND key = $2 FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, subject, key).Scan(&f.Failures, &f.LastFailureAt, &f.LockedUntil); err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	return f, nil
+}
+
+// ReleaseLoginAttempt takes back the failure ReserveLoginAttempt counted for
+// an attempt that succeeded, for subjects that keep their count on success
+func (db *DB) ReleaseLoginAttempt(subject, key string) error {
+	query := `UPDATE login_failures SET failures = failures - 1 WHERE subject = $1 AND key = $2 AND failures > 0 AND locked_until IS NULL`
+	if _, err := db.pool.Exec(context.Background(), query, subject, key); err != nil {
+		return fmt.Errorf("failed to release login attempt: %w", err)
+	}
+
+	return nil
+}
+
+// ClearLoginFailures resets the counter of a subject after a successful login
+func (db *DB) ClearLoginFailures(subject, key string) error {
+	_, err := db.pool.Exec(context.Background(), `DELETE FROM login_failures WHERE subject = $1 AND key = $2 AND locked_until IS NULL`, subject, key)
+	if err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+
+	return nil
+}
+
+// UnlockLogin lifts a lockout and records which admin lifted it
+func (db *DB) UnlockLogin(subject, key string, actorID int64) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		var failures int
+		err := tx.QueryRow(ctx, `DELETE FROM login_failures WHERE subject = $1 AND key = $2 RETURNING failures`, subject, key).Scan(&failures)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to unlock %s: %w", subject, err)
+		}
+
+		return insertLockoutEvent(ctx, tx, &LockoutEvent{Subject: subject, Key: key, Event: LockoutEventUnlocked, Failures: failures, ActorID: &actorID})
+	})
+}
+
+func insertLockoutEvent(ctx context.Context, tx pgx.Tx, e *LockoutEvent) error {
+	query := `INSERT INTO lockout_events (subject, key, event, failures, locked_until, actor_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	if err := tx.QueryRow(ctx, query, e.Subject, e.Key, e.Event, e.Failures, e.LockedUntil, e.ActorID).Scan(&e.ID, &e.CreatedAt); err != nil {
+		return fmt.Errorf("failed to record lockout event: %w", err)
+	}
+
+	return nil
+}
+
+// GetLockoutEvents returns the most recent lockout events, newest first
+func (db *DB) GetLockoutEvents(limit int) ([]*LockoutEvent, error) {
+	query := `SELECT id, subject, key, event, failures, locked_until, actor_id, created_at FROM lockout_events ORDER BY id DESC LIMIT $1`
+	rows, err := db.pool.Query(context.Background(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lockout events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*LockoutEvent
+	for rows.Next() {
+		e := &LockoutEvent{}
+		if err := rows.Scan(&e.ID, &e.Subject, &e.Key, &e.Event, &e.Failures, &e.LockedUntil, &e.ActorID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan lockout event: %w", err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginFailuresLockAndUnlock(t *testing.T) {
+	admin := &User{
+		FirstName:   "Lockout",
+		LastName:    "Admin",
+		PhoneNumber: "4445550001",
+		Email:       "lockoutadmin@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(admin); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	policy := LockoutPolicy{MaxFailures: 3, Window: time.Minute, Lockout: time.Minute}
+	counter := LoginCounter{Subject: LoginSubjectAccount, Key: "nobody@example.com", Policy: policy}
+	key := counter.Key
+
+	for i := 1; i <= 3; i++ {
+		wait, err := testDB.ReserveLoginAttempt(counter)
+		if err != nil {
+			t.Fatalf("ReserveLoginAttempt failed: %v", err)
+		}
+		if wait != 0 {
+			t.Fatalf("expected attempt %d to go ahead, got a wait of %v", i, wait)
+		}
+	}
+
+	// the attempt after the last allowed failure locks the account
+	wait, err := testDB.ReserveLoginAttempt(counter)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt failed: %v", err)
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Fatalf("expected a wait of up to a minute, got %v", wait)
+	}
+
+	// a locked counter survives a successful login
+	if err := testDB.ClearLoginFailures(LoginSubjectAccount, key); err != nil {
+		t.Fatalf("ClearLoginFailures failed: %v", err)
+	}
+	f, err := testDB.GetLoginFailures(LoginSubjectAccount, key)
+	if err != nil || f == nil || f.LockedUntil == nil || f.Failures != 3 {
+		t.Fatalf("expected the account to stay locked after 3 failures, got %+v, %v", f, err)
+	}
+
+	if err := testDB.UnlockLogin(LoginSubjectAccount, key, admin.ID); err != nil {
+		t.Fatalf("UnlockLogin failed: %v", err)
+	}
+	f, err = testDB.GetLoginFailures(LoginSubjectAccount, key)
+	if err != nil || f != nil {
+		t.Fatalf("expected the counter to be gone, got %+v, %v", f, err)
+	}
+
+	events, err := testDB.GetLockoutEvents(10)
+	if err != nil {
+		t.Fatalf("GetLockoutEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Event != LockoutEventUnlocked || events[1].Event != LockoutEventLocked {
+		t.Fatalf("expected a lock and an unlock event, got %+v", events)
+	}
+	if events[0].ActorID == nil || *events[0].ActorID != admin.ID {
+		t.Fatalf("expected the unlock to record the admin, got %v", events[0].ActorID)
+	}
+}
+
+func TestReserveLoginAttemptSerializesParallelAttempts(t *testing.T) {
+	counter := LoginCounter{
+		Subject: LoginSubjectAccount,
+		Key:     "parallel@example.com",
+		Policy:  LockoutPolicy{MaxFailures: 5, Window: time.Hour, Lockout: time.Hour},
+		Delay:   func(failures int) time.Duration { return time.Duration(failures) * time.Minute },
+	}
+
+	waits := make([]time.Duration, 10)
+	errs := make([]error, len(waits))
+	var wg sync.WaitGroup
+	for i := range waits {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			waits[i], errs[i] = testDB.ReserveLoginAttempt(counter)
+		}(i)
+	}
+	wg.Wait()
+
+	reserved := 0
+	for i, wait := range waits {
+		if errs[i] != nil {
+			t.Fatalf("ReserveLoginAttempt failed: %v", errs[i])
+		}
+		if wait == 0 {
+			reserved++
+		}
+	}
+	if reserved != 1 {
+		t.Fatalf("expected exactly one attempt to go ahead, got %d", reserved)
+	}
+
+	f, err := testDB.GetLoginFailures(counter.Subject, counter.Key)
+	if err != nil || f == nil || f.Failures != 1 {
+		t.Fatalf("expected one failure to be counted, got %+v, %v", f, err)
+	}
+
+	if err := testDB.ReleaseLoginAttempt(counter.Subject, counter.Key); err != nil {
+		t.Fatalf("ReleaseLoginAttempt failed: %v", err)
+	}
+	f, err = testDB.GetLoginFailures(counter.Subject, counter.Key)
+	if err != nil || f == nil || f.Failures != 0 {
+		t.Fatalf("expected the failure to be taken back, got %+v, %v", f, err)
+	}
+}
+
+func TestTOTPFailuresAreCountedPerUser(t *testing.T) {
+	counter := LoginCounter{Subject: LoginSubjectTOTP, Key: "42", Policy: LockoutPolicy{MaxFailures: 1, Window: time.Hour, Lockout: time.Hour}}
+
+	if wait, err := testDB.ReserveLoginAttempt(counter); err != nil || wait != 0 {
+		t.Fatalf("expected the first code to be checked, got %v, %v", wait, err)
+	}
+	if wait, err := testDB.ReserveLoginAttempt(counter); err != nil || wait <= 0 {
+		t.Fatalf("expected the user to be locked out, got %v, %v", wait, err)
+	}
+
+	f, err := testDB.GetLoginFailures(LoginSubjectTOTP, "42")
+	if err != nil || f == nil || f.LockedUntil == nil {
+		t.Fatalf("expected a locked TOTP counter, got %+v, %v", f, err)
+	}
+}
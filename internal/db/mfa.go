@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/masudcsesust04/ewallet-api/internal/totp"
+)
+
+// MaxMFAAttempts is how many wrong codes an MFA challenge takes before it is
+// discarded and the user has to log in again
+const MaxMFAAttempts = 5
+
+var (
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrInvalidTOTPCode     = errors.New("invalid two-factor code")
+	ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge")
+)
+
+// MFAChallenge is a login that passed the password check and waits for a
+// second factor. Token holds the SHA-256 hash of the challenge handed to the
+// client; the device fields are copied into the session it opens.
+type MFAChallenge struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Token       string    `json:"-"`
+	DeviceLabel string    `json:"device_label"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	Attempts    int       `json:"attempts"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StartTOTPEnrolment stores a new secret for the user. It stays inactive
+// until ConfirmTOTPEnrolment sees a code generated from it.
+func (db *DB) StartTOTPEnrolment(userID int64, secret string) error {
+	query := `UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE id = $1 AND NOT totp_enabled`
+	tag, err := db.pool.Exec(context.Background(), query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+// ConfirmTOTPEnrolment enables two-factor authentication once code matches
+// the pending secret, and replaces the user's recovery codes with the given
+// hashes.
+func (db *DB) ConfirmTOTPEnrolment(userID int64, code string, recoveryCodeHashes []string) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		if err := checkTOTP(ctx, tx, userID, code, false); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled = TRUE WHERE id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to enable TOTP: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+				return fmt.Errorf("failed to create recovery code: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// VerifyTOTP checks a code from the user's enabled authenticator. Each code
+// is accepted once.
+func (db *DB) VerifyTOTP(userID int64, code string) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		return checkTOTP(ctx, tx, userID, code, true)
+	})
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current
+// code, and drops the secret and recovery codes
+func (db *DB) DisableTOTP(userID int64, code string) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		if err := checkTOTP(ctx, tx, userID, code, true); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to disable TOTP: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// checkTOTP validates code against the user's secret under a row lock and
+// records its time step, so the same code can not be used twice. enabled
+// selects whether the secret must be confirmed or still pending.
+func checkTOTP(ctx context.Context, tx pgx.Tx, userID int64, code string, enabled bool) error {
+	var secret *string
+	var isEnabled bool
+	var lastStep int64
+
+	query := `SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, query, userID).Scan(&secret, &isEnabled, &lastStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+
+	switch {
+	case secret == nil:
+		return ErrTOTPNotEnrolled
+	case enabled && !isEnabled:
+		return ErrTOTPNotEnrolled
+	case !enabled && isEnabled:
+		return ErrTOTPAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(*secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return ErrInvalidTOTPCode
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_last_step = $2 WHERE id = $1`, userID, step); err != nil {
+		return fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+
+	return nil
+}
+
+// useRecoveryCode marks one of the user's unused recovery codes as used
+func useRecoveryCode(ctx context.Context, tx pgx.Tx, userID int64, codeHash string) error {
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := tx.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
+// CreateMFAChallenge stores a pending second-factor login
+func (db *DB) CreateMFAChallenge(c *MFAChallenge) error {
+	query := `INSERT INTO mfa_challenges (user_id, token, device_label, ip_address, user_agent, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := db.pool.QueryRow(context.Background(), query, c.UserID, c.Token, c.DeviceLabel, c.IPAddress, c.UserAgent, c.ExpiresAt).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create MFA challenge: %w", err)
+	}
+
+	return nil
+}
+
+// CompleteMFAChallenge checks the second factor for the challenge whose hash
+// is token: either a TOTP code or, when recoveryCodeHash is set, one of the
+// user's recovery codes. The challenge is deleted on success. A wrong code
+// counts as an attempt and returns ErrInvalidTOTPCode; after MaxMFAAttempts
+// the challenge is deleted too.
+func (db *DB) CompleteMFAChallenge(token, code, recoveryCodeHash string) (*MFAChallenge, error) {
+	var challenge *MFAChallenge
+	failed := false
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		c := &MFAChallenge{Token: token}
+		query := `SELECT id, user_id, device_label, ip_address, user_agent, attempts, expires_at, created_at FROM mfa_challenges WHERE token = $1 FOR UPDATE`
+		err := tx.QueryRow(ctx, query, token).Scan(&c.ID, &c.UserID, &c.DeviceLabel, &c.IPAddress, &c.UserAgent, &c.Attempts, &c.ExpiresAt, &c.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrMFAChallengeInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get MFA challenge: %w", err)
+		}
+
+		if !c.ExpiresAt.After(time.Now()) {
+			return ErrMFAChallengeInvalid
+		}
+
+		if recoveryCodeHash != "" {
+			err = useRecoveryCode(ctx, tx, c.UserID, recoveryCodeHash)
+		} else {
+			err = checkTOTP(ctx, tx, c.UserID, code, true)
+		}
+
+		// the failed attempt is committed, so guessing costs the challenge
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			failed = true
+			if c.Attempts+1 >= MaxMFAAttempts {
+				_, err = tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, c.ID)
+			} else {
+				_, err = tx.Exec(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1`, c.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to record MFA attempt: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, c.ID); err != nil {
+			return fmt.Errorf("failed to delete MFA challenge: %w", err)
+		}
+
+		challenge = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if failed {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	return challenge, nil
+}
+
+// DeleteExpiredMFAChallenges removes challenges that can no longer be completed
+func (db *DB) DeleteExpiredMFAChallenges() (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM mfa_challenges WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired MFA challenges: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
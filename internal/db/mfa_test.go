@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/totp"
+)
+
+func TestTOTPEnrolmentAndChallenge(t *testing.T) {
+	user := &User{
+		FirstName:   "Totp",
+		LastName:    "User",
+		PhoneNumber: "4445558888",
+		Email:       "totpuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	secret, _ := totp.GenerateSecret()
+	if err := testDB.StartTOTPEnrolment(user.ID, secret); err != nil {
+		t.Fatalf("StartTOTPEnrolment failed: %v", err)
+	}
+
+	// codes of the previous step are accepted, then each code only once
+	now := totp.Step(time.Now())
+	previous, _ := totp.Code(secret, now-1)
+	current, _ := totp.Code(secret, now)
+
+	if err := testDB.ConfirmTOTPEnrolment(user.ID, previous, []string{"recovery-hash"}); err != nil {
+		t.Fatalf("ConfirmTOTPEnrolment failed: %v", err)
+	}
+	if err := testDB.VerifyTOTP(user.ID, previous); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("expected a replayed code to fail, got %v", err)
+	}
+	if err := testDB.VerifyTOTP(user.ID, current); err != nil {
+		t.Fatalf("VerifyTOTP failed: %v", err)
+	}
+
+	challenge := &MFAChallenge{UserID: user.ID, Token: "challenge", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := testDB.CreateMFAChallenge(challenge); err != nil {
+		t.Fatalf("CreateMFAChallenge failed: %v", err)
+	}
+	if _, err := testDB.CompleteMFAChallenge("challenge", "", "wrong-hash"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("expected ErrInvalidTOTPCode, got %v", err)
+	}
+
+	completed, err := testDB.CompleteMFAChallenge("challenge", "", "recovery-hash")
+	if err != nil {
+		t.Fatalf("CompleteMFAChallenge failed: %v", err)
+	}
+	if completed.UserID != user.ID || completed.Attempts != 1 {
+		t.Fatalf("unexpected challenge: %+v", completed)
+	}
+
+	if _, err := testDB.CompleteMFAChallenge("challenge", "", "recovery-hash"); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("expected the challenge to be used up, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrWrongPassword      = errors.New("wrong password")
+	ErrResetTokenInvalid  = errors.New("invalid or expired reset token")
+	ErrPasswordNotChanged = errors.New("new password must differ from the current one")
+)
+
+// ChangePassword replaces the user's password after checking the current one
+func (db *DB) ChangePassword(userID int64, current, next string) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		var hash string
+		err := tx.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&hash)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(current)) != nil {
+			return ErrWrongPassword
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(next)) == nil {
+			return ErrPasswordNotChanged
+		}
+
+		return setPassword(ctx, tx, userID, next)
+	})
+}
+
+func setPassword(ctx context.Context, tx pgx.Tx, userID int64, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`, userID, string(hash)); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// CreatePasswordReset stores the hash of a reset token for the user. Earlier
+// unused tokens of the user stop working.
+func (db *DB) CreatePasswordReset(userID int64, tokenHash string, expiresAt time.Time) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+			return fmt.Errorf("failed to delete reset tokens: %w", err)
+		}
+
+		query := `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(ctx, query, userID, tokenHash, expiresAt); err != nil {
+			return fmt.Errorf("failed to create reset token: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ResetPassword sets a new password with the reset token whose hash is
+// tokenHash. The token is used up and every session of the user is revoked,
+// so refresh tokens issued before the reset stop working. It returns the
+// user's ID.
+func (db *DB) ResetPassword(tokenHash, password string) (int64, error) {
+	var userID int64
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var id int64
+		query := `SELECT id, user_id FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() FOR UPDATE`
+		err := tx.QueryRow(ctx, query, tokenHash).Scan(&id, &userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrResetTokenInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get reset token: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE password_resets SET used_at = NOW() WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to use reset token: %w", err)
+		}
+
+		if err := setPassword(ctx, tx, userID, password); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteExpiredPasswordResets removes reset tokens that can no longer be used
+func (db *DB) DeleteExpiredPasswordResets() (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM password_resets WHERE expires_at <= NOW() OR used_at IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired reset tokens: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
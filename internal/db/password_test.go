@@ -0,0 +1,51 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordRevokesSessions(t *testing.T) {
+	user := &User{
+		FirstName:   "Reset",
+		LastName:    "User",
+		PhoneNumber: "4445559999",
+		Email:       "resetuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if err := testDB.ChangePassword(user.ID, "wrong", "password456"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("expected ErrWrongPassword, got %v", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	if err := testDB.CreateSession(&Session{UserID: user.ID}, &RefreshToken{Token: "before-reset", ExpiresAt: expires}); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	if err := testDB.CreatePasswordReset(user.ID, "reset-hash", expires); err != nil {
+		t.Fatalf("CreatePasswordReset failed: %v", err)
+	}
+
+	userID, err := testDB.ResetPassword("reset-hash", "password789")
+	if err != nil || userID != user.ID {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+
+	if _, err := testDB.ResetPassword("reset-hash", "password000"); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("expected the reset token to be used up, got %v", err)
+	}
+
+	if _, err := testDB.RotateRefreshToken("before-reset", &RefreshToken{Token: "after-reset", ExpiresAt: expires}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("expected sessions to be revoked, got %v", err)
+	}
+
+	if err := testDB.ChangePassword(user.ID, "password789", "password456"); err != nil {
+		t.Fatalf("ChangePassword with the new password failed: %v", err)
+	}
+}
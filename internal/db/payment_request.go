@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// Statuses of a payment request
+const (
+	RequestPending   = "pending"
+	RequestAccepted  = "accepted"
+	RequestDeclined  = "declined"
+	RequestCancelled = "cancelled"
+	RequestExpired   = "expired"
+)
+
+// RequestStatuses are the values of payment_requests.status
+var RequestStatuses = []string{RequestPending, RequestAccepted, RequestDeclined, RequestCancelled, RequestExpired}
+
+var (
+	ErrPaymentRequestNotFound = fmt.Errorf("payment request %w", ErrNotFound)
+
+	// ErrPaymentRequestNotPending is returned when a request that was
+	// accepted, declined, cancelled or has expired is answered again
+	ErrPaymentRequestNotPending = errors.New("payment request is no longer pending")
+
+	// ErrPayerUnavailable is returned when the payer is unknown, not active
+	// or has no wallet in the request's currency. The cases are not told
+	// apart, so requests can not be used to find out about users.
+	ErrPayerUnavailable = errors.New("payer can not be asked to pay in this currency")
+)
+
+// PaymentRequest asks PayerID to pay Amount into the requester's wallet
+// ToWalletID before ExpiresAt. Once accepted, FromWalletID is the wallet
+// the payer paid from and the transaction IDs are the transfer's send and
+// receive records.
+type PaymentRequest struct {
+	ID                   int64       `json:"id"`
+	RequesterID          int64       `json:"requester_id"`
+	PayerID              int64       `json:"payer_id"`
+	ToWalletID           int64       `json:"to_wallet_id"`
+	FromWalletID         int64       `json:"from_wallet_id,omitempty"`
+	Amount               money.Money `json:"amount"`
+	Currency             string      `json:"currency"`
+	Note                 string      `json:"note"`
+	Status               string      `json:"status"`
+	SendTransactionID    int64       `json:"send_transaction_id,omitempty"`
+	ReceiveTransactionID int64       `json:"receive_transaction_id,omitempty"`
+	ExpiresAt            time.Time   `json:"expires_at"`
+	RespondedAt          *time.Time  `json:"responded_at,omitempty"`
+	CreatedAt            time.Time   `json:"created_at"`
+	UpdatedAt            time.Time   `json:"updated_at"`
+}
+
+// PaymentRequestQuery selects the payment requests of UserID: the ones it
+// received when Incoming is set, otherwise the ones it sent or received.
+// Status, if set, restricts them to one status.
+type PaymentRequestQuery struct {
+	UserID   int64
+	Incoming bool
+	Status   string
+}
+
+const paymentRequestColumns = `id, requester_id, payer_id, to_wallet_id, COALESCE(from_wallet_id, 0), amount, currency, note, status, COALESCE(send_transaction_id, 0), COALESCE(receive_transaction_id, 0), expires_at, responded_at, created_at, updated_at`
+
+// scanPaymentRequest reads a row selected as paymentRequestColumns. A pending
+// request past its expiry is reported as expired before
+// ExpirePaymentRequests gets to it.
+func scanPaymentRequest(row pgx.Row) (*PaymentRequest, error) {
+	pr := &PaymentRequest{}
+	var amount pgtype.Numeric
+
+	err := row.Scan(&pr.ID, &pr.RequesterID, &pr.PayerID, &pr.ToWalletID, &pr.FromWalletID, &amount, &pr.Currency, &pr.Note, &pr.Status, &pr.SendTransactionID, &pr.ReceiveTransactionID, &pr.ExpiresAt, &pr.RespondedAt, &pr.CreatedAt, &pr.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if pr.Amount, err = money.FromNumeric(amount, pr.Currency); err != nil {
+		return nil, fmt.Errorf("payment request %d: %w", pr.ID, err)
+	}
+
+	if pr.Status == RequestPending && !pr.ExpiresAt.After(time.Now()) {
+		pr.Status = RequestExpired
+	}
+
+	return pr, nil
+}
+
+// CreatePaymentRequest stores a pending request and fills in the rest of pr.
+// The payer has to be an active user with a wallet in the currency.
+func (db *DB) CreatePaymentRequest(pr *PaymentRequest) error {
+	if !pr.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
+	}
+
+	pr.Currency = pr.Amount.Currency()
+	pr.Status = RequestPending
+
+	query := `INSERT INTO payment_requests (requester_id, payer_id, to_wallet_id, amount, currency, note, status, expires_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE EXISTS (SELECT 1 FROM users u JOIN wallets w ON w.user_id = u.id WHERE u.id = $2 AND u.status = 'active' AND w.currency = $5)
+		RETURNING id, created_at, updated_at`
+	err := db.pool.QueryRow(context.Background(), query, pr.RequesterID, pr.PayerID, pr.ToWalletID, pr.Amount, pr.Currency, pr.Note, pr.Status, pr.ExpiresAt).Scan(&pr.ID, &pr.CreatedAt, &pr.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPayerUnavailable
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create payment request: %w", err)
+	}
+
+	return nil
+}
+
+// GetPaymentRequest returns a payment request by ID
+func (db *DB) GetPaymentRequest(id int64) (*PaymentRequest, error) {
+	pr, err := scanPaymentRequest(db.pool.QueryRow(context.Background(), `SELECT `+paymentRequestColumns+` FROM payment_requests WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment request: %w", notFound(err, ErrPaymentRequestNotFound))
+	}
+
+	return pr, nil
+}
+
+// GetPaymentRequests returns the payment requests selected by q, newest
+// first. Requests past their expiry count as expired, not pending.
+func (db *DB) GetPaymentRequests(q PaymentRequestQuery) ([]*PaymentRequest, error) {
+	query := `SELECT ` + paymentRequestColumns + ` FROM payment_requests WHERE (payer_id = $1 OR (NOT $2 AND requester_id = $1))`
+	args := []interface{}{q.UserID, q.Incoming}
+
+	switch q.Status {
+	case "":
+	case RequestPending:
+		query += ` AND status = 'pending' AND expires_at > NOW()`
+	case RequestExpired:
+		query += ` AND (status = 'expired' OR (status = 'pending' AND expires_at <= NOW()))`
+	default:
+		query += ` AND status = $3`
+		args = append(args, q.Status)
+	}
+	query += ` ORDER BY id DESC`
+
+	rows, err := db.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment requests: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []*PaymentRequest
+	for rows.Next() {
+		pr, err := scanPaymentRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment request: %w", err)
+		}
+		requests = append(requests, pr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return requests, nil
+}
+
+// AcceptPaymentRequest pays a pending request from the payer's wallet
+// fromWalletID like TransferFunds, fee included, and records the transfer on
+// the request. The request stays locked until the transfer commits, so it is
+// never paid twice.
+func (db *DB) AcceptPaymentRequest(id, fromWalletID int64) (*PaymentRequest, error) {
+	var pr *PaymentRequest
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		pr, err = scanPaymentRequest(tx.QueryRow(ctx, `SELECT `+paymentRequestColumns+` FROM payment_requests WHERE id = $1 FOR UPDATE`, id))
+		if err != nil {
+			return fmt.Errorf("failed to lock payment request: %w", notFound(err, ErrPaymentRequestNotFound))
+		}
+
+		if pr.Status != RequestPending {
+			return ErrPaymentRequestNotPending
+		}
+
+		transfer := &Transfer{FromWalletID: fromWalletID, ToWalletID: pr.ToWalletID, Amount: pr.Amount}
+		if err := transferFunds(ctx, tx, transfer); err != nil {
+			return err
+		}
+
+		pr.Status, pr.FromWalletID = RequestAccepted, fromWalletID
+		pr.SendTransactionID, pr.ReceiveTransactionID = transfer.Send.ID, transfer.Receive.ID
+		query := `UPDATE payment_requests SET status = $2, from_wallet_id = $3, send_transaction_id = $4, receive_transaction_id = $5, responded_at = NOW(), updated_at = NOW()
+			WHERE id = $1 RETURNING responded_at, updated_at`
+		if err := tx.QueryRow(ctx, query, pr.ID, pr.Status, pr.FromWalletID, pr.SendTransactionID, pr.ReceiveTransactionID).Scan(&pr.RespondedAt, &pr.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to accept payment request: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// DeclinePaymentRequest is the payer turning down a pending request
+func (db *DB) DeclinePaymentRequest(id int64) (*PaymentRequest, error) {
+	return db.closePaymentRequest(id, RequestDeclined)
+}
+
+// CancelPaymentRequest is the requester withdrawing a pending request
+func (db *DB) CancelPaymentRequest(id int64) (*PaymentRequest, error) {
+	return db.closePaymentRequest(id, RequestCancelled)
+}
+
+// closePaymentRequest moves a pending request to status without paying it
+func (db *DB) closePaymentRequest(id int64, status string) (*PaymentRequest, error) {
+	query := `UPDATE payment_requests SET status = $2, responded_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'pending' AND expires_at > NOW() RETURNING ` + paymentRequestColumns
+	pr, err := scanPaymentRequest(db.pool.QueryRow(context.Background(), query, id, status))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := db.GetPaymentRequest(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrPaymentRequestNotPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to close payment request: %w", err)
+	}
+
+	return pr, nil
+}
+
+// ExpirePaymentRequests marks pending requests past their expiry as expired
+// and returns how many expired. Expired requests can not be accepted as soon
+// as they expire; this only catches their status up.
+func (db *DB) ExpirePaymentRequests() (int64, error) {
+	query := `UPDATE payment_requests SET status = 'expired', updated_at = NOW() WHERE status = 'pending' AND expires_at <= NOW()`
+	tag, err := db.pool.Exec(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire payment requests: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
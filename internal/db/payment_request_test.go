@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRequestLifecycle(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var users []*User
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Request",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555600%04d", i),
+			Email:       fmt.Sprintf("request%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+		users = append(users, user)
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+	requester, payer := users[0], users[1]
+
+	hundred, _ := money.Parse("100", "USD")
+	_, err := db.Deposit(wallets[1].ID, hundred)
+	assert.NoError(t, err)
+
+	forty, _ := money.Parse("40", "USD")
+	dinner := &PaymentRequest{RequesterID: requester.ID, PayerID: payer.ID, ToWalletID: wallets[0].ID, Amount: forty, Note: "dinner", ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, db.CreatePaymentRequest(dinner))
+	assert.Equal(t, RequestPending, dinner.Status)
+
+	incoming, err := db.GetPaymentRequests(PaymentRequestQuery{UserID: payer.ID, Incoming: true, Status: RequestPending})
+	assert.NoError(t, err)
+	assert.Len(t, incoming, 1)
+
+	// the requester's pending requests are outgoing, not incoming
+	incoming, err = db.GetPaymentRequests(PaymentRequestQuery{UserID: requester.ID, Incoming: true})
+	assert.NoError(t, err)
+	assert.Empty(t, incoming)
+
+	accepted, err := db.AcceptPaymentRequest(dinner.ID, wallets[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, RequestAccepted, accepted.Status)
+	assert.NotNil(t, accepted.RespondedAt)
+
+	send, err := db.GetTransaction(accepted.SendTransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "send", send.Type)
+	assert.Equal(t, "40.00", send.Amount.String())
+
+	wallet, _ := db.GetWalletByID(wallets[0].ID)
+	assert.Equal(t, "40.00", wallet.Balance.String())
+
+	_, err = db.AcceptPaymentRequest(dinner.ID, wallets[1].ID)
+	assert.ErrorIs(t, err, ErrPaymentRequestNotPending)
+	_, err = db.DeclinePaymentRequest(dinner.ID)
+	assert.ErrorIs(t, err, ErrPaymentRequestNotPending)
+
+	// a declined request moves no money; an expired one can not be accepted
+	taxi := &PaymentRequest{RequesterID: requester.ID, PayerID: payer.ID, ToWalletID: wallets[0].ID, Amount: forty, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, db.CreatePaymentRequest(taxi))
+	declined, err := db.DeclinePaymentRequest(taxi.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, RequestDeclined, declined.Status)
+
+	late := &PaymentRequest{RequesterID: requester.ID, PayerID: payer.ID, ToWalletID: wallets[0].ID, Amount: forty, ExpiresAt: time.Now().Add(-time.Second)}
+	assert.NoError(t, db.CreatePaymentRequest(late))
+	_, err = db.AcceptPaymentRequest(late.ID, wallets[1].ID)
+	assert.ErrorIs(t, err, ErrPaymentRequestNotPending)
+
+	expired, err := db.ExpirePaymentRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), expired)
+
+	history, err := db.GetPaymentRequests(PaymentRequestQuery{UserID: requester.ID})
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, RequestExpired, history[0].Status)
+
+	_, err = db.CancelPaymentRequest(9999)
+	assert.ErrorIs(t, err, ErrPaymentRequestNotFound)
+
+	// unknown payers, payers without a wallet in the currency and payers
+	// that are not active are refused alike
+	euros, _ := money.Parse("40", "EUR")
+	for _, pr := range []*PaymentRequest{
+		{RequesterID: requester.ID, PayerID: 9999, ToWalletID: wallets[0].ID, Amount: forty, ExpiresAt: time.Now().Add(time.Hour)},
+		{RequesterID: requester.ID, PayerID: payer.ID, ToWalletID: wallets[0].ID, Amount: euros, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		assert.ErrorIs(t, db.CreatePaymentRequest(pr), ErrPayerUnavailable)
+	}
+
+	payer.Status = "banned"
+	assert.NoError(t, db.UpdateUser(payer))
+	banned := &PaymentRequest{RequesterID: requester.ID, PayerID: payer.ID, ToWalletID: wallets[0].ID, Amount: forty, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.ErrorIs(t, db.CreatePaymentRequest(banned), ErrPayerUnavailable)
+}
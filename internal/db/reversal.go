@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+var (
+	// ErrNotReversible is returned for transactions that are not completed
+	// same-currency transfers, e.g. deposits, pending holds, conversions or
+	// reversals themselves
+	ErrNotReversible = errors.New("transaction cannot be reversed")
+
+	// ErrAlreadyRefunded is returned when a transfer was already reversed in full
+	ErrAlreadyRefunded = errors.New("transaction was already refunded in full")
+
+	// ErrRefundExceedsOriginal is returned when a refund is larger than what
+	// is left to refund of its transfer
+	ErrRefundExceedsOriginal = errors.New("refund exceeds the amount left to refund")
+)
+
+// Reversal refunds a completed transfer, in full or in part, from the
+// receiver back to the sender. TransactionID is the send record of the
+// transfer. A zero Amount refunds whatever is left to refund. ReverseTransfer
+// fills in the Send and Receive records of the reversal and the updated
+// Original.
+type Reversal struct {
+	TransactionID int64
+	Amount        money.Money
+	Note          string
+
+	Original *Transaction
+	Send     *Transaction
+	Receive  *Transaction
+}
+
+// lockTransaction selects a transaction row FOR UPDATE inside tx
+func lockTransaction(ctx context.Context, tx pgx.Tx, id int64) (*Transaction, error) {
+	t, err := scanTransaction(tx.QueryRow(ctx, `SELECT `+transactionColumns+` FROM transactions t WHERE t.id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock transaction: %w", notFound(err, ErrTransactionNotFound))
+	}
+
+	return t, nil
+}
+
+// ReverseTransfer moves r.Amount from the receiver of a transfer back to its
+// sender as a transfer of its own, linked to the original. Both wallets are
+// locked in ID order like TransferFunds does, then the original, so refunds
+// of one transfer are serialized and never exceed its amount. The original
+// records count the amount refunded and are cancelled once it is refunded in
+// full. No fee is charged, nor is the original fee refunded.
+func (db *DB) ReverseTransfer(r *Reversal) error {
+	if r.Amount.IsNegative() {
+		return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
+	}
+
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		original, err := scanTransaction(tx.QueryRow(ctx, `SELECT `+transactionColumns+` FROM transactions t WHERE t.id = $1`, r.TransactionID))
+		if err != nil {
+			return fmt.Errorf("failed to get transaction: %w", notFound(err, ErrTransactionNotFound))
+		}
+
+		if original.Type != "send" || original.ToWalletID == 0 || original.JournalEntryID == 0 || original.ExchangeRate != "" || original.ReversalOf != 0 {
+			return ErrNotReversible
+		}
+
+		// the refund flows the other way, from the receiver to the sender
+		payer, payee, err := lockWalletPair(ctx, tx, original.ToWalletID, original.FromWalletID)
+		if err != nil {
+			return err
+		}
+
+		if original, err = lockTransaction(ctx, tx, r.TransactionID); err != nil {
+			return err
+		}
+
+		refunded := money.Zero(original.Currency)
+		if original.RefundedAmount != nil {
+			refunded = *original.RefundedAmount
+		}
+
+		remaining, err := original.Amount.Sub(refunded)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case original.Status == "cancelled" && !remaining.IsPositive():
+			return ErrAlreadyRefunded
+		case original.Status != "completed":
+			return ErrNotReversible
+		}
+
+		amount := r.Amount
+		if amount.IsZero() {
+			amount = remaining
+		}
+		if amount.Currency() != original.Currency {
+			return fmt.Errorf("%w: cannot refund %s of a %s transfer", money.ErrCurrencyMismatch, amount.Currency(), original.Currency)
+		}
+		if cmp, _ := amount.Cmp(remaining); cmp > 0 {
+			return ErrRefundExceedsOriginal
+		}
+
+		if err := checkNotFrozen(ctx, tx, payer, payee); err != nil {
+			return err
+		}
+
+		if err := checkFunds(payer, amount); err != nil {
+			return err
+		}
+
+		debit, err := walletLeg(ctx, tx, payer, amount.Neg())
+		if err != nil {
+			return err
+		}
+
+		credit, err := walletLeg(ctx, tx, payee, amount)
+		if err != nil {
+			return err
+		}
+
+		entryID, err := postJournal(ctx, tx, "reversal", debit, credit)
+		if err != nil {
+			return err
+		}
+
+		r.Send = &Transaction{Type: "send", FromWalletID: payer.ID, ToWalletID: payee.ID, Amount: amount, Note: r.Note, Status: "completed", JournalEntryID: entryID, ReversalOf: original.ID}
+		r.Receive = &Transaction{Type: "receive", FromWalletID: payee.ID, ToWalletID: payer.ID, Amount: amount, Note: r.Note, Status: "completed", JournalEntryID: entryID, ReversalOf: original.ID}
+		if err := insertTransferRecords(ctx, tx, &Transfer{Send: r.Send, Receive: r.Receive}); err != nil {
+			return err
+		}
+
+		if refunded, err = refunded.Add(amount); err != nil {
+			return err
+		}
+		if refunded == original.Amount {
+			original.Status = "cancelled"
+		}
+		original.RefundedAmount = &refunded
+
+		// the send and receive records of the original share its journal entry
+		query := `UPDATE transactions SET refunded_amount = $2, status = $3, updated_at = NOW() WHERE journal_entry_id = $1 AND reversal_of IS NULL`
+		if _, err := tx.Exec(ctx, query, original.JournalEntryID, refunded, original.Status); err != nil {
+			return fmt.Errorf("failed to mark transaction as refunded: %w", err)
+		}
+
+		r.Original = original
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseTransferRefundsUpToTheOriginal(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Refund",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555400%04d", i),
+			Email:       fmt.Sprintf("refund%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+	customer, merchant := wallets[0], wallets[1]
+
+	hundred, _ := money.Parse("100", "USD")
+	deposit, err := db.Deposit(customer.ID, hundred)
+	assert.NoError(t, err)
+
+	eighty, _ := money.Parse("80", "USD")
+	transfer := &Transfer{FromWalletID: customer.ID, ToWalletID: merchant.ID, Amount: eighty}
+	assert.NoError(t, db.TransferFunds(transfer))
+
+	thirty, _ := money.Parse("30", "USD")
+	partial := &Reversal{TransactionID: transfer.Send.ID, Amount: thirty, Note: "damaged item"}
+	assert.NoError(t, db.ReverseTransfer(partial))
+	assert.Equal(t, transfer.Send.ID, partial.Send.ReversalOf)
+	assert.Equal(t, merchant.ID, partial.Send.FromWalletID)
+	assert.Equal(t, "completed", partial.Original.Status)
+	assert.Equal(t, "30.00", partial.Original.RefundedAmount.String())
+
+	// a refund can not exceed what is left of the original
+	sixty, _ := money.Parse("60", "USD")
+	assert.ErrorIs(t, db.ReverseTransfer(&Reversal{TransactionID: transfer.Send.ID, Amount: sixty}), ErrRefundExceedsOriginal)
+
+	// without an amount the rest is refunded and the original cancelled
+	rest := &Reversal{TransactionID: transfer.Send.ID}
+	assert.NoError(t, db.ReverseTransfer(rest))
+	assert.Equal(t, "50.00", rest.Send.Amount.String())
+	assert.Equal(t, "cancelled", rest.Original.Status)
+
+	assert.ErrorIs(t, db.ReverseTransfer(&Reversal{TransactionID: transfer.Send.ID}), ErrAlreadyRefunded)
+	assert.ErrorIs(t, db.ReverseTransfer(&Reversal{TransactionID: rest.Send.ID}), ErrNotReversible)
+	assert.ErrorIs(t, db.ReverseTransfer(&Reversal{TransactionID: deposit.ID}), ErrNotReversible)
+	assert.ErrorIs(t, db.ReverseTransfer(&Reversal{TransactionID: 9999}), ErrTransactionNotFound)
+
+	wallet, _ := db.GetWalletByID(customer.ID)
+	assert.Equal(t, "100.00", wallet.Balance.String())
+	wallet, _ = db.GetWalletByID(merchant.ID)
+	assert.Equal(t, "0.00", wallet.Balance.String())
+
+	received, err := db.GetTransaction(transfer.Receive.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", received.Status)
+	assert.Equal(t, "80.00", received.RefundedAmount.String())
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
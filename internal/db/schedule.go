@@ -0,0 +1,357 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// How often a scheduled transfer runs
+const (
+	ScheduleOnce    = "once"
+	ScheduleDaily   = "daily"
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+)
+
+// ScheduleFrequencies are the values of scheduled_transfers.frequency
+var ScheduleFrequencies = []string{ScheduleOnce, ScheduleDaily, ScheduleWeekly, ScheduleMonthly}
+
+// Statuses of a scheduled transfer
+const (
+	ScheduleActive    = "active"
+	ScheduleCompleted = "completed"
+	ScheduleCancelled = "cancelled"
+	ScheduleFailed    = "failed"
+)
+
+// Outcomes of a scheduled transfer run
+const (
+	RunCompleted = "completed"
+	RunRetrying  = "retrying"
+	RunFailed    = "failed"
+)
+
+var (
+	ErrScheduleNotFound = fmt.Errorf("scheduled transfer %w", ErrNotFound)
+
+	// ErrScheduleNotActive is returned when a schedule that is over is cancelled
+	ErrScheduleNotActive = errors.New("scheduled transfer is no longer active")
+
+	// ErrInvalidSchedule is returned for an unknown frequency or an end before the start
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
+
+// ScheduledTransfer moves Amount between two wallets once at StartAt, or
+// every day, week or month from StartAt until EndAt. NextRunAt is when the
+// scheduler runs it next and is nil once it is over.
+type ScheduledTransfer struct {
+	ID           int64       `json:"id"`
+	UserID       int64       `json:"user_id"`
+	FromWalletID int64       `json:"from_wallet_id"`
+	ToWalletID   int64       `json:"to_wallet_id"`
+	Amount       money.Money `json:"amount"`
+	Currency     string      `json:"currency"`
+	Note         string      `json:"note"`
+	Frequency    string      `json:"frequency"`
+	StartAt      time.Time   `json:"start_at"`
+	EndAt        *time.Time  `json:"end_at,omitempty"`
+	NextRunAt    *time.Time  `json:"next_run_at,omitempty"`
+	Occurrences  int         `json:"occurrences"`
+	Attempts     int         `json:"attempts"`
+	Status       string      `json:"status"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+// ScheduledTransferRun records one attempt at an occurrence of a scheduled
+// transfer. TransactionID is the send record of a completed run.
+type ScheduledTransferRun struct {
+	ID            int64     `json:"id"`
+	ScheduleID    int64     `json:"schedule_id"`
+	ScheduledFor  time.Time `json:"scheduled_for"`
+	Attempt       int       `json:"attempt"`
+	Status        string    `json:"status"`
+	TransactionID int64     `json:"transaction_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Cause is the error of a failed transfer, for logging
+	Cause error `json:"-"`
+}
+
+// ScheduleRetryPolicy decides how a run that fails for insufficient funds is
+// retried. Other failures are not retried.
+type ScheduleRetryPolicy struct {
+	// MaxRetries is how many times the run is retried, Delay apart
+	MaxRetries int
+	Delay      time.Duration
+}
+
+// occurrence returns the time of occurrence n, counted from 0, of a schedule
+func occurrence(start time.Time, frequency string, n int) time.Time {
+	switch frequency {
+	case ScheduleDaily:
+		return start.AddDate(0, 0, n)
+	case ScheduleWeekly:
+		return start.AddDate(0, 0, 7*n)
+	case ScheduleMonthly:
+		// a schedule on the 31st runs on the last day of shorter months
+		t := start.AddDate(0, n, 0)
+		if t.Day() != start.Day() {
+			t = t.AddDate(0, 0, -t.Day())
+		}
+		return t
+	}
+
+	return start
+}
+
+// advance moves s past its current occurrence to the first one after now,
+// or ends it. Occurrences missed while the scheduler was not running are
+// skipped rather than run in a burst.
+func (s *ScheduledTransfer) advance(now time.Time) {
+	s.Attempts = 0
+	s.NextRunAt = nil
+	if s.Frequency == ScheduleOnce {
+		s.Occurrences++
+		return
+	}
+
+	for {
+		s.Occurrences++
+		next := occurrence(s.StartAt, s.Frequency, s.Occurrences)
+		if s.EndAt != nil && next.After(*s.EndAt) {
+			return
+		}
+		if next.After(now) {
+			s.NextRunAt = &next
+			return
+		}
+	}
+}
+
+const scheduleColumns = `id, user_id, from_wallet_id, to_wallet_id, amount, currency, note, frequency, start_at, end_at, next_run_at, occurrences, attempts, status, created_at, updated_at`
+
+func scanScheduledTransfer(row pgx.Row) (*ScheduledTransfer, error) {
+	s := &ScheduledTransfer{}
+	var amount pgtype.Numeric
+
+	err := row.Scan(&s.ID, &s.UserID, &s.FromWalletID, &s.ToWalletID, &amount, &s.Currency, &s.Note, &s.Frequency, &s.StartAt, &s.EndAt, &s.NextRunAt, &s.Occurrences, &s.Attempts, &s.Status, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Amount, err = money.FromNumeric(amount, s.Currency); err != nil {
+		return nil, fmt.Errorf("scheduled transfer %d: %w", s.ID, err)
+	}
+
+	return s, nil
+}
+
+// CreateScheduledTransfer stores a schedule, first due at s.StartAt, and
+// fills in the rest of s
+func (db *DB) CreateScheduledTransfer(s *ScheduledTransfer) error {
+	if !s.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
+	}
+
+	valid := false
+	for _, f := range ScheduleFrequencies {
+		valid = valid || s.Frequency == f
+	}
+	if !valid || (s.EndAt != nil && (s.Frequency == ScheduleOnce || s.EndAt.Before(s.StartAt))) {
+		return ErrInvalidSchedule
+	}
+
+	s.Currency = s.Amount.Currency()
+	s.Status = ScheduleActive
+	s.NextRunAt = &s.StartAt
+
+	query := `INSERT INTO scheduled_transfers (user_id, from_wallet_id, to_wallet_id, amount, currency, note, frequency, start_at, end_at, next_run_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at, updated_at`
+	err := db.pool.QueryRow(context.Background(), query, s.UserID, s.FromWalletID, s.ToWalletID, s.Amount, s.Currency, s.Note, s.Frequency, s.StartAt, s.EndAt, s.NextRunAt, s.Status).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create scheduled transfer: %w", err)
+	}
+
+	return nil
+}
+
+// GetScheduledTransfer returns a schedule by ID
+func (db *DB) GetScheduledTransfer(id int64) (*ScheduledTransfer, error) {
+	s, err := scanScheduledTransfer(db.pool.QueryRow(context.Background(), `SELECT `+scheduleColumns+` FROM scheduled_transfers WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfer: %w", notFound(err, ErrScheduleNotFound))
+	}
+
+	return s, nil
+}
+
+// GetScheduledTransfersByUserID returns a user's schedules, newest first
+func (db *DB) GetScheduledTransfersByUserID(userID int64) ([]*ScheduledTransfer, error) {
+	rows, err := db.pool.Query(context.Background(), `SELECT `+scheduleColumns+` FROM scheduled_transfers WHERE user_id = $1 ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfers: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []*ScheduledTransfer
+	for rows.Next() {
+		s, err := scanScheduledTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled transfer: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return schedules, nil
+}
+
+// CancelScheduledTransfer stops an active schedule. A run in progress
+// finishes first.
+func (db *DB) CancelScheduledTransfer(id int64) (*ScheduledTransfer, error) {
+	query := `UPDATE scheduled_transfers SET status = $2, next_run_at = NULL, updated_at = NOW() WHERE id = $1 AND status = $3 RETURNING ` + scheduleColumns
+	s, err := scanScheduledTransfer(db.pool.QueryRow(context.Background(), query, id, ScheduleCancelled, ScheduleActive))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := db.GetScheduledTransfer(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrScheduleNotActive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel scheduled transfer: %w", err)
+	}
+
+	return s, nil
+}
+
+// GetScheduledTransferRuns returns the runs of a schedule, newest first
+func (db *DB) GetScheduledTransferRuns(scheduleID int64) ([]*ScheduledTransferRun, error) {
+	query := `SELECT id, schedule_id, scheduled_for, attempt, status, COALESCE(transaction_id, 0), error, created_at FROM scheduled_transfer_runs WHERE schedule_id = $1 ORDER BY id DESC`
+	rows, err := db.pool.Query(context.Background(), query, scheduleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfer runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*ScheduledTransferRun
+	for rows.Next() {
+		run := &ScheduledTransferRun{}
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Attempt, &run.Status, &run.TransactionID, &run.Error, &run.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled transfer run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return runs, nil
+}
+
+// runFailures are the transfer errors a run records by their message; any
+// other failure is recorded as a generic one
+var runFailures = []error{ErrInsufficientFunds, ErrWalletFrozen, ErrWalletNotFound, ErrContactUnverified, money.ErrCurrencyMismatch}
+
+func runFailure(err error) string {
+	for _, known := range runFailures {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+
+	return "transfer failed"
+}
+
+// RunDueScheduledTransfer claims the schedule that has been due the longest,
+// runs its transfer and records the outcome, all in one database
+// transaction. The schedule is claimed FOR UPDATE SKIP LOCKED, so several
+// schedulers can work side by side without running a schedule twice. A
+// failed transfer is rolled back on its own; a run that failed for
+// insufficient funds is retried as policy allows, other failures skip the
+// occurrence. The owner's email and phone have to be verified, as for any
+// transfer. It returns nil when no schedule is due.
+func (db *DB) RunDueScheduledTransfer(policy ScheduleRetryPolicy) (*ScheduledTransferRun, error) {
+	var run *ScheduledTransferRun
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		query := `SELECT ` + scheduleColumns + ` FROM scheduled_transfers
+			WHERE status = 'active' AND next_run_at <= NOW()
+			ORDER BY next_run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED`
+		s, err := scanScheduledTransfer(tx.QueryRow(ctx, query))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim scheduled transfer: %w", err)
+		}
+
+		run = &ScheduledTransferRun{ScheduleID: s.ID, ScheduledFor: occurrence(s.StartAt, s.Frequency, s.Occurrences), Attempt: s.Attempts + 1}
+
+		transfer := &Transfer{FromWalletID: s.FromWalletID, ToWalletID: s.ToWalletID, Amount: s.Amount}
+		err = inSavepoint(ctx, tx, func(sp pgx.Tx) error {
+			verified, err := userVerified(ctx, sp, s.UserID)
+			if err != nil {
+				return err
+			}
+			if !verified {
+				return ErrContactUnverified
+			}
+
+			return transferFunds(ctx, sp, transfer)
+		})
+
+		now := time.Now()
+		switch {
+		case err == nil:
+			run.Status, run.TransactionID = RunCompleted, transfer.Send.ID
+			s.advance(now)
+		case errors.Is(err, ErrInsufficientFunds) && s.Attempts < policy.MaxRetries:
+			run.Status = RunRetrying
+			retryAt := now.Add(policy.Delay)
+			s.Attempts, s.NextRunAt = s.Attempts+1, &retryAt
+		default:
+			run.Status = RunFailed
+			s.advance(now)
+		}
+
+		if err != nil {
+			run.Error, run.Cause = runFailure(err), err
+		}
+
+		if s.NextRunAt == nil {
+			s.Status = ScheduleCompleted
+			if s.Frequency == ScheduleOnce && run.Status == RunFailed {
+				s.Status = ScheduleFailed
+			}
+		}
+
+		query = `UPDATE scheduled_transfers SET next_run_at = $2, occurrences = $3, attempts = $4, status = $5, updated_at = NOW() WHERE id = $1`
+		if _, err := tx.Exec(ctx, query, s.ID, s.NextRunAt, s.Occurrences, s.Attempts, s.Status); err != nil {
+			return fmt.Errorf("failed to update scheduled transfer: %w", err)
+		}
+
+		query = `INSERT INTO scheduled_transfer_runs (schedule_id, scheduled_for, attempt, status, transaction_id, error) VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6) RETURNING id, created_at`
+		if err := tx.QueryRow(ctx, query, run.ScheduleID, run.ScheduledFor, run.Attempt, run.Status, run.TransactionID, run.Error).Scan(&run.ID, &run.CreatedAt); err != nil {
+			return fmt.Errorf("failed to record scheduled transfer run: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleOccurrences(t *testing.T) {
+	start := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, start, occurrence(start, ScheduleOnce, 3))
+	assert.Equal(t, time.Date(2026, time.February, 2, 9, 0, 0, 0, time.UTC), occurrence(start, ScheduleDaily, 2))
+	assert.Equal(t, time.Date(2026, time.February, 14, 9, 0, 0, 0, time.UTC), occurrence(start, ScheduleWeekly, 2))
+	assert.Equal(t, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC), occurrence(start, ScheduleMonthly, 1))
+	assert.Equal(t, time.Date(2026, time.March, 31, 9, 0, 0, 0, time.UTC), occurrence(start, ScheduleMonthly, 2))
+
+	// missed occurrences are skipped and the schedule ends after end_at
+	end := time.Date(2026, time.February, 5, 0, 0, 0, 0, time.UTC)
+	s := &ScheduledTransfer{Frequency: ScheduleDaily, StartAt: start, EndAt: &end, Attempts: 2}
+	s.advance(time.Date(2026, time.February, 2, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, 3, s.Occurrences)
+	assert.Equal(t, 0, s.Attempts)
+	assert.Equal(t, time.Date(2026, time.February, 3, 9, 0, 0, 0, time.UTC), *s.NextRunAt)
+
+	s.advance(time.Date(2026, time.February, 4, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, s.NextRunAt)
+}
+
+func TestRunDueScheduledTransfer(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var users []*User
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Schedule",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555500%04d", i),
+			Email:       fmt.Sprintf("schedule%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+		users = append(users, user)
+		verifyUser(t, db, user.ID)
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	rent, _ := money.Parse("50", "USD")
+	s := &ScheduledTransfer{UserID: users[0].ID, FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: rent, Frequency: ScheduleMonthly, StartAt: time.Now().Add(-time.Minute)}
+	assert.NoError(t, db.CreateScheduledTransfer(s))
+
+	policy := ScheduleRetryPolicy{MaxRetries: 1, Delay: -time.Second}
+
+	// the wallet is empty: one retry, then the occurrence is skipped
+	run, err := db.RunDueScheduledTransfer(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, RunRetrying, run.Status)
+	assert.Equal(t, ErrInsufficientFunds.Error(), run.Error)
+
+	run, err = db.RunDueScheduledTransfer(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, RunFailed, run.Status)
+	assert.Equal(t, 2, run.Attempt)
+
+	run, err = db.RunDueScheduledTransfer(policy)
+	assert.NoError(t, err)
+	assert.Nil(t, run)
+
+	s, err = db.GetScheduledTransfer(s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, ScheduleActive, s.Status)
+	assert.Equal(t, 1, s.Occurrences)
+	assert.True(t, s.NextRunAt.After(time.Now()))
+
+	// a funded one-off runs once and completes
+	hundred, _ := money.Parse("100", "USD")
+	_, err = db.Deposit(wallets[0].ID, hundred)
+	assert.NoError(t, err)
+
+	once := &ScheduledTransfer{UserID: users[0].ID, FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: rent, Note: "allowance", Frequency: ScheduleOnce, StartAt: time.Now().Add(-time.Minute)}
+	assert.NoError(t, db.CreateScheduledTransfer(once))
+
+	run, err = db.RunDueScheduledTransfer(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, RunCompleted, run.Status)
+	assert.NotZero(t, run.TransactionID)
+
+	once, _ = db.GetScheduledTransfer(once.ID)
+	assert.Equal(t, ScheduleCompleted, once.Status)
+	assert.Nil(t, once.NextRunAt)
+
+	wallet, _ := db.GetWalletByID(wallets[1].ID)
+	assert.Equal(t, "50.00", wallet.Balance.String())
+
+	runs, err := db.GetScheduledTransferRuns(s.ID)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+
+	_, err = db.CancelScheduledTransfer(s.ID)
+	assert.NoError(t, err)
+	_, err = db.CancelScheduledTransfer(s.ID)
+	assert.ErrorIs(t, err, ErrScheduleNotActive)
+	_, err = db.CancelScheduledTransfer(9999)
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
+
+// verifyUser marks the user's email and phone verified
+func verifyUser(t *testing.T, db *DB, userID int64) {
+	t.Helper()
+	_, err := db.pool.Exec(context.Background(), `UPDATE users SET email_verified = TRUE, phone_verified = TRUE WHERE id = $1`, userID)
+	assert.NoError(t, err)
+}
+
+func TestScheduledTransferNeedsVerifiedOwner(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "Unverified",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555510%04d", i),
+			Email:       fmt.Sprintf("unverified%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	hundred, _ := money.Parse("100", "USD")
+	_, err := db.Deposit(wallets[0].ID, hundred)
+	assert.NoError(t, err)
+
+	rent, _ := money.Parse("50", "USD")
+	s := &ScheduledTransfer{UserID: wallets[0].UserID, FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: rent, Frequency: ScheduleOnce, StartAt: time.Now().Add(-time.Minute)}
+	assert.NoError(t, db.CreateScheduledTransfer(s))
+
+	// the run fails without a retry and nothing moves
+	run, err := db.RunDueScheduledTransfer(ScheduleRetryPolicy{MaxRetries: 3, Delay: -time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, RunFailed, run.Status)
+	assert.Equal(t, "contact not verified", run.Error)
+	assert.Zero(t, run.TransactionID)
+
+	s, _ = db.GetScheduledTransfer(s.ID)
+	assert.Equal(t, ScheduleFailed, s.Status)
+
+	wallet, _ := db.GetWalletByID(wallets[0].ID)
+	assert.Equal(t, "100.00", wallet.Balance.String())
+}
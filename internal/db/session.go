@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSessionNotFound = fmt.Errorf("session %w", ErrNotFound)
+
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. Its session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Session is a login on one device. Each refresh rotates the session's
+// refresh token; all tokens of a session form one family that is revoked
+// together.
+type Session struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	DeviceLabel string     `json:"device_label"`
+	IPAddress   string     `json:"ip_address"`
+	UserAgent   string     `json:"user_agent"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  time.Time  `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateSession opens a session together with its first refresh token and
+// fills in the IDs and timestamps of both.
+func (db *DB) CreateSession(session *Session, rt *RefreshToken) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		query := `INSERT INTO sessions (user_id, device_label, ip_address, user_agent) VALUES ($1, $2, $3, $4) RETURNING id, created_at, last_used_at`
+		err := tx.QueryRow(ctx, query, session.UserID, session.DeviceLabel, session.IPAddress, session.UserAgent).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		rt.UserID, rt.SessionID = session.UserID, session.ID
+		return insertRefreshToken(ctx, tx, rt)
+	})
+}
+
+func insertRefreshToken(ctx context.Context, tx pgx.Tx, rt *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (user_id, session_id, token, expires_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	if err := tx.QueryRow(ctx, query, rt.UserID, rt.SessionID, rt.Token, rt.ExpiresAt).Scan(&rt.ID, &rt.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges the refresh token whose hash is oldToken for
+// next, which joins the same session. The old token can not be used again;
+// presenting it a second time revokes the session and returns
+// ErrRefreshTokenReused.
+func (db *DB) RotateRefreshToken(oldToken string, next *RefreshToken) (*Session, error) {
+	var session *Session
+	reused := false
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		query := `SELECT rt.id, rt.expires_at, rt.used_at, s.id, s.user_id, s.device_label, s.ip_address, s.user_agent, s.created_at, s.last_used_at, s.revoked_at
+			FROM refresh_tokens rt JOIN sessions s ON s.id = rt.session_id
+			WHERE rt.token = $1 FOR UPDATE`
+
+		var tokenID int64
+		var expiresAt time.Time
+		var usedAt *time.Time
+		s := &Session{}
+		err := tx.QueryRow(ctx, query, oldToken).Scan(&tokenID, &expiresAt, &usedAt, &s.ID, &s.UserID, &s.DeviceLabel, &s.IPAddress, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.RevokedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get refresh token: %w", err)
+		}
+
+		if s.RevokedAt != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		// A rotated token coming back means it was copied; end the session
+		// so neither the thief nor the owner can keep refreshing.
+		if usedAt != nil {
+			reused = true
+			return revokeSession(ctx, tx, s.ID)
+		}
+
+		if !expiresAt.After(time.Now()) {
+			return ErrRefreshTokenInvalid
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+			return fmt.Errorf("failed to mark refresh token as used: %w", err)
+		}
+
+		if err := tx.QueryRow(ctx, `UPDATE sessions SET last_used_at = NOW() WHERE id = $1 RETURNING last_used_at`, s.ID).Scan(&s.LastUsedAt); err != nil {
+			return fmt.Errorf("failed to update session: %w", err)
+		}
+
+		next.UserID, next.SessionID = s.UserID, s.ID
+		if err := insertRefreshToken(ctx, tx, next); err != nil {
+			return err
+		}
+
+		session = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+
+	return session, nil
+}
+
+func revokeSession(ctx context.Context, tx pgx.Tx, sessionID int64) error {
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, sessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionsByUserID returns the user's active sessions, most recently used first
+func (db *DB) GetSessionsByUserID(userID int64) ([]*Session, error) {
+	query := `SELECT id, user_id, device_label, ip_address, user_agent, created_at, last_used_at, revoked_at FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY last_used_at DESC`
+	rows, err := db.pool.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.ID, &s.UserID, &s.DeviceLabel, &s.IPAddress, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the user's sessions. It returns
+// ErrSessionNotFound when the session does not exist, belongs to another
+// user or is already revoked.
+func (db *DB) RevokeSession(userID, sessionID int64) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	tag, err := db.pool.Exec(context.Background(), query, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeSessionByRefreshToken revokes the user's session that the refresh
+// token whose hash is token belongs to
+func (db *DB) RevokeSessionByRefreshToken(userID int64, token string) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+		AND id = (SELECT session_id FROM refresh_tokens WHERE token = $2)`
+	tag, err := db.pool.Exec(context.Background(), query, userID, token)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of the user
+func (db *DB) RevokeUserSessions(userID int64) error {
+	_, err := db.pool.Exec(context.Background(), `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// SessionActive reports whether access tokens issued for the session may
+// still be used: the user must be active and the session not revoked. A
+// sessionID of 0 checks only the user.
+func (db *DB) SessionActive(userID, sessionID int64) (bool, error) {
+	query := `SELECT u.status = 'active' AND ($2 = 0 OR EXISTS (
+			SELECT 1 FROM sessions s WHERE s.id = $2 AND s.user_id = u.id AND s.revoked_at IS NULL))
+		FROM users u WHERE u.id = $1`
+
+	var active bool
+	err := db.pool.QueryRow(context.Background(), query, userID, sessionID).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+
+	return active, nil
+}
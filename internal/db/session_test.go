@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotationAndReuse(t *testing.T) {
+	user := &User{
+		FirstName:   "Token",
+		LastName:    "User",
+		PhoneNumber: "4445556666",
+		Email:       "tokenuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	expires := time.Now().Add(24 * time.Hour)
+	session := &Session{UserID: user.ID, DeviceLabel: "laptop", IPAddress: "10.0.0.1", UserAgent: "test"}
+	if err := testDB.CreateSession(session, &RefreshToken{Token: "first", ExpiresAt: expires}); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	rotated, err := testDB.RotateRefreshToken("first", &RefreshToken{Token: "second", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if rotated.ID != session.ID {
+		t.Fatalf("expected session %d, got %d", session.ID, rotated.ID)
+	}
+
+	// replaying the rotated token revokes the whole session
+	if _, err := testDB.RotateRefreshToken("first", &RefreshToken{Token: "third", ExpiresAt: expires}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if _, err := testDB.RotateRefreshToken("second", &RefreshToken{Token: "fourth", ExpiresAt: expires}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("expected ErrRefreshTokenInvalid after reuse, got %v", err)
+	}
+
+	sessions, err := testDB.GetSessionsByUserID(user.ID)
+	if err != nil {
+		t.Fatalf("GetSessionsByUserID failed: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("expected no active sessions, got %d", len(sessions))
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	user := &User{
+		FirstName:   "Device",
+		LastName:    "User",
+		PhoneNumber: "4445557777",
+		Email:       "deviceuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	expires := time.Now().Add(24 * time.Hour)
+	phone := &Session{UserID: user.ID, DeviceLabel: "phone"}
+	laptop := &Session{UserID: user.ID, DeviceLabel: "laptop"}
+	if err := testDB.CreateSession(phone, &RefreshToken{Token: "phone-token", ExpiresAt: expires}); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if err := testDB.CreateSession(laptop, &RefreshToken{Token: "laptop-token", ExpiresAt: expires}); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	if err := testDB.RevokeSession(user.ID+1, phone.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound for another user, got %v", err)
+	}
+	if err := testDB.RevokeSession(user.ID, phone.ID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+
+	if _, err := testDB.RotateRefreshToken("phone-token", &RefreshToken{Token: "phone-next", ExpiresAt: expires}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("expected ErrRefreshTokenInvalid for revoked session, got %v", err)
+	}
+
+	sessions, err := testDB.GetSessionsByUserID(user.ID)
+	if err != nil {
+		t.Fatalf("GetSessionsByUserID failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != laptop.ID {
+		t.Fatalf("expected only the laptop session to remain, got %+v", sessions)
+	}
+}
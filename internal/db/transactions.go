@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// Orders a transaction history can be sorted by
+const (
+	TransactionSortCreatedAt = "created_at"
+	TransactionSortAmount    = "amount"
+)
+
+// DefaultTransactionLimit is the page size of a query without a limit
+const DefaultTransactionLimit = 50
+
+// TransactionTypes and TransactionStatuses are the values of transactions.type
+// and transactions.status
+var (
+	TransactionTypes    = []string{"deposit", "withdrawal", "send", "receive"}
+	TransactionStatuses = []string{"pending", "processing", "completed", "failed", "cancelled"}
+)
+
+// TransactionQuery selects a page of a wallet's transaction history. Zero
+// fields do not filter. Since is inclusive and Until exclusive; MinAmount and
+// MaxAmount are inclusive.
+type TransactionQuery struct {
+	WalletID  int64
+	Types     []string
+	Statuses  []string
+	Since     *time.Time
+	Until     *time.Time
+	MinAmount *money.Money
+	MaxAmount *money.Money
+
+	// SortBy is TransactionSortCreatedAt, the default, or
+	// TransactionSortAmount. Ties are broken by id.
+	SortBy    string
+	Ascending bool
+
+	// After continues the history after the page it was returned with
+	After *TransactionCursor
+	Limit int
+}
+
+// TransactionCursor is the position of the last transaction of a page in the
+// order of the query that returned it
+type TransactionCursor struct {
+	SortBy    string
+	Ascending bool
+	CreatedAt time.Time
+	Amount    money.Money
+	ID        int64
+}
+
+// transactionColumns selects a transactions row aliased t for scanTransaction
+const transactionColumns = `t.id, t.type, t.from_wallet_id, COALESCE(t.to_wallet_id, 0), t.amount, t.fee, t.currency, COALESCE(t.note, ''), COALESCE(t.exchange_rate::text, ''), t.status, t.created_at, COALESCE(t.journal_entry_id, 0), COALESCE(t.reversal_of, 0), t.refunded_amount`
+
+// scanTransaction reads a transaction row selected with transactionColumns
+func scanTransaction(row pgx.Row) (*Transaction, error) {
+	tx := &Transaction{}
+	var amount, fee, refunded pgtype.Numeric
+	err := row.Scan(&tx.ID, &tx.Type, &tx.FromWalletID, &tx.ToWalletID, &amount, &fee, &tx.Currency, &tx.Note, &tx.ExchangeRate, &tx.Status, &tx.CreatedAt, &tx.JournalEntryID, &tx.ReversalOf, &refunded)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Amount, err = money.FromNumeric(amount, tx.Currency); err != nil {
+		return nil, fmt.Errorf("transaction %d amount: %w", tx.ID, err)
+	}
+	if tx.Fee, err = money.FromNumeric(fee, tx.Currency); err != nil {
+		return nil, fmt.Errorf("transaction %d fee: %w", tx.ID, err)
+	}
+
+	refundedAmount, err := money.FromNumeric(refunded, tx.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %d refunded amount: %w", tx.ID, err)
+	}
+	if refundedAmount.IsPositive() {
+		tx.RefundedAmount = &refundedAmount
+	}
+
+	return tx, nil
+}
+
+// GetTransaction returns a transaction by ID
+func (db *DB) GetTransaction(id int64) (*Transaction, error) {
+	tx, err := scanTransaction(db.pool.QueryRow(context.Background(), `SELECT `+transactionColumns+` FROM transactions t WHERE t.id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", notFound(err, ErrTransactionNotFound))
+	}
+
+	return tx, nil
+}
+
+// GetTransactions returns a page of the history of q.WalletID, and the
+// cursor of the next page or nil on the last one.
+//
+// The history holds the transactions the wallet owns (its from_wallet_id)
+// and those naming it as the counter-party (its to_wallet_id). A transfer is
+// recorded once for each side, so the counter-party's record is only listed
+// when the wallet has no record of its own for the transfer; the records are
+// matched the way CheckLedger matches them.
+func (db *DB) GetTransactions(q TransactionQuery) ([]*Transaction, *TransactionCursor, error) {
+	if q.SortBy == "" {
+		q.SortBy = TransactionSortCreatedAt
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultTransactionLimit
+	}
+	if q.SortBy != TransactionSortCreatedAt && q.SortBy != TransactionSortAmount {
+		return nil, nil, fmt.Errorf("unknown transaction sort %q", q.SortBy)
+	}
+	if q.After != nil && (q.After.SortBy != q.SortBy || q.After.Ascending != q.Ascending) {
+		return nil, nil, fmt.Errorf("cursor does not match the order of the query")
+	}
+
+	args := []interface{}{q.WalletID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := []string{`(t.from_wallet_id = $1 OR (t.to_wallet_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM transactions m
+		WHERE m.from_wallet_id = $1 AND m.to_wallet_id = t.from_wallet_id
+		AND (m.journal_entry_id = t.journal_entry_id OR (t.journal_entry_id IS NULL AND m.created_at = t.created_at))
+	)))`}
+	if len(q.Types) > 0 {
+		conditions = append(conditions, "t.type = ANY("+arg(q.Types)+")")
+	}
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, "t.status = ANY("+arg(q.Statuses)+")")
+	}
+	if q.Since != nil {
+		conditions = append(conditions, "t.created_at >= "+arg(*q.Since))
+	}
+	if q.Until != nil {
+		conditions = append(conditions, "t.created_at < "+arg(*q.Until))
+	}
+	if q.MinAmount != nil {
+		conditions = append(conditions, "t.amount >= "+arg(*q.MinAmount))
+	}
+	if q.MaxAmount != nil {
+		conditions = append(conditions, "t.amount <= "+arg(*q.MaxAmount))
+	}
+
+	column, direction, comparison := "t.created_at", "DESC", "<"
+	if q.SortBy == TransactionSortAmount {
+		column = "t.amount"
+	}
+	if q.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if q.After != nil {
+		var key interface{} = q.After.CreatedAt
+		if q.SortBy == TransactionSortAmount {
+			key = q.After.Amount
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, t.id) %s (%s, %s)", column, comparison, arg(key), arg(q.After.ID)))
+	}
+
+	// one row more than the page tells whether there is a next page
+	query := `SELECT ` + transactionColumns + `
+		FROM transactions t
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + column + ` ` + direction + `, t.id ` + direction + `
+		LIMIT ` + arg(q.Limit+1)
+
+	rows, err := db.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []*Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		transactions = append(transactions, tx)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	if len(transactions) <= q.Limit {
+		return transactions, nil, nil
+	}
+
+	transactions = transactions[:q.Limit]
+	last := transactions[q.Limit-1]
+	next := &TransactionCursor{SortBy: q.SortBy, Ascending: q.Ascending, CreatedAt: last.CreatedAt, Amount: last.Amount, ID: last.ID}
+
+	return transactions, next, nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTransactionsPages(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i := 0; i < 2; i++ {
+		user := &User{
+			FirstName:   "History",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555200%04d", i),
+			Email:       fmt.Sprintf("history%d@example.com", i),
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	for _, amount := range []string{"10", "20", "30"} {
+		m, _ := money.Parse(amount, "USD")
+		_, err := db.Deposit(wallets[0].ID, m)
+		assert.NoError(t, err)
+	}
+
+	five, _ := money.Parse("5", "USD")
+	assert.NoError(t, db.TransferFunds(&Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: five}))
+
+	// three deposits and the send, newest first, two at a time
+	page, next, err := db.GetTransactions(TransactionQuery{WalletID: wallets[0].ID, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "send", page[0].Type)
+	assert.Equal(t, wallets[1].ID, page[0].ToWalletID)
+	assert.NotNil(t, next)
+
+	page, next, err = db.GetTransactions(TransactionQuery{WalletID: wallets[0].ID, Limit: 2, After: next})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "10.00", page[1].Amount.String())
+	assert.Nil(t, next)
+
+	// the receiver sees the transfer once, through its own record
+	page, _, err = db.GetTransactions(TransactionQuery{WalletID: wallets[1].ID})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "receive", page[0].Type)
+
+	// a record without a counterpart is listed from the other side too
+	orphan := &Transaction{Type: "send", FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: five, Status: "failed"}
+	assert.NoError(t, db.CreateTransaction(orphan))
+	page, _, err = db.GetTransactions(TransactionQuery{WalletID: wallets[1].ID, Statuses: []string{"failed"}})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, orphan.ID, page[0].ID)
+
+	min, _ := money.Parse("15", "USD")
+	page, _, err = db.GetTransactions(TransactionQuery{WalletID: wallets[0].ID, Types: []string{"deposit"}, MinAmount: &min, SortBy: TransactionSortAmount, Ascending: true})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "20.00", page[0].Amount.String())
+	assert.Equal(t, "30.00", page[1].Amount.String())
+}
@@ -10,35 +10,55 @@ import (
 
 // User represent a user in the system
 type User struct {
-	ID           int64     `json:"id"`
-	FirstName    string    `json:"first_name"`
-	LastName     string    `json:"last_name"`
-	PhoneNumber  string    `json:"phone_number"`
-	Email        string    `json:"email"`
-	Status       string    `json:"status"`
-	Password     string    `json:"password,omitempty"` // plain password, not stored in DB
-	PasswordHash string    `json:"passwrod_hash"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            int64     `json:"id"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	PhoneNumber   string    `json:"phone_number"`
+	Email         string    `json:"email"`
+	Status        string    `json:"status"`
+	Role          string    `json:"role"`
+	TOTPEnabled   bool      `json:"totp_enabled"`
+	EmailVerified bool      `json:"email_verified"`
+	PhoneVerified bool      `json:"phone_verified"`
+	Password      string    `json:"-"` // plain password, not stored in DB
+	PasswordHash  string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
-// RefreshToken represents a refresh token in the system
+// RefreshToken represents a refresh token in the system. Token holds the
+// SHA-256 hash of the token handed to the client.
 type RefreshToken struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"created_at"`
-	CreatedAt time.Time `json:"updated_at"`
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	SessionID int64      `json:"session_id"`
+	Token     string     `json:"token"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
-// GetUserByEmail retr4ieves a user by email
+// GetUserByEmail retrieves a user by email, ignoring case
 func (db *DB) GetUserByEmail(email string) (*User, error) {
-	query := `SELECT id, first_name, last_name, phone_number, email, password_hash, status, created_at, updated_at FROM  users WHERE email = $1`
+	query := `SELECT id, first_name, last_name, phone_number, email, password_hash, status, role, totp_enabled, email_verified, phone_verified, created_at, updated_at FROM  users WHERE lower(email) = lower($1)`
 	user := &User{}
 
-	err := db.pool.QueryRow(context.Background(), query, email).Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.PasswordHash, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+	err := db.pool.QueryRow(context.Background(), query, email).Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.PasswordHash, &user.Status, &user.Role, &user.TOTPEnabled, &user.EmailVerified, &user.PhoneVerified, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by email: %w", err)
+		return nil, fmt.Errorf("failed to get user by email: %w", notFound(err, ErrUserNotFound))
+	}
+
+	return user, nil
+}
+
+// GetUserByPhoneNumber retrieves a user by phone number
+func (db *DB) GetUserByPhoneNumber(phoneNumber string) (*User, error) {
+	query := `SELECT id, first_name, last_name, phone_number, email, status, role, totp_enabled, email_verified, phone_verified, created_at, updated_at FROM users WHERE phone_number = $1`
+	user := &User{}
+
+	err := db.pool.QueryRow(context.Background(), query, phoneNumber).Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.Status, &user.Role, &user.TOTPEnabled, &user.EmailVerified, &user.PhoneVerified, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by phone number: %w", notFound(err, ErrUserNotFound))
 	}
 
 	return user, nil
@@ -53,10 +73,11 @@ func (db *DB) CreateUser(user *User) error {
 
 	user.PasswordHash = string(hashedPassword)
 
-	query := `INSERT INTO users (first_name, last_name, phone_number, email, status, password_hash) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`
-	err = db.pool.QueryRow(context.Background(), query, user.FirstName, user.LastName, user.PhoneNumber, user.Email, user.Status, user.PasswordHash).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	// users are customers unless created with another role
+	query := `INSERT INTO users (first_name, last_name, phone_number, email, status, password_hash, role) VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'customer')) RETURNING id, role, created_at, updated_at`
+	err = db.pool.QueryRow(context.Background(), query, user.FirstName, user.LastName, user.PhoneNumber, user.Email, user.Status, user.PasswordHash, user.Role).Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+		return fmt.Errorf("failed to create user: %w", userConflict(err))
 	}
 
 	return nil
@@ -64,12 +85,12 @@ func (db *DB) CreateUser(user *User) error {
 
 // GetUserByID retrives a user by ID
 func (db *DB) GetUserByID(id int64) (*User, error) {
-	query := `SELECT id, first_name, last_name, phone_number, email, status, created_at, updated_at FROM  users WHERE id = $1`
+	query := `SELECT id, first_name, last_name, phone_number, email, status, role, totp_enabled, email_verified, phone_verified, created_at, updated_at FROM  users WHERE id = $1`
 	user := &User{}
 
-	err := db.pool.QueryRow(context.Background(), query, id).Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+	err := db.pool.QueryRow(context.Background(), query, id).Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.Status, &user.Role, &user.TOTPEnabled, &user.EmailVerified, &user.PhoneVerified, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by id: %w", err)
+		return nil, fmt.Errorf("failed to get user by id: %w", notFound(err, ErrUserNotFound))
 	}
 
 	return user, nil
@@ -77,7 +98,7 @@ func (db *DB) GetUserByID(id int64) (*User, error) {
 
 // GetAllUsers retrives all users from the database
 func (db *DB) GetAllUsers() ([]*User, error) {
-	query := `SELECT id, first_name, last_name, phone_number, email, status, created_at, updated_at FROM  users`
+	query := `SELECT id, first_name, last_name, phone_number, email, status, role, totp_enabled, email_verified, phone_verified, created_at, updated_at FROM  users`
 
 	rows, err := db.pool.Query(context.Background(), query)
 	if err != nil {
@@ -89,7 +110,7 @@ func (db *DB) GetAllUsers() ([]*User, error) {
 
 	for rows.Next() {
 		user := &User{}
-		err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.PhoneNumber, &user.Email, &user.Status, &user.Role, &user.TOTPEnabled, &user.EmailVerified, &user.PhoneVerified, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
@@ -104,12 +125,17 @@ func (db *DB) GetAllUsers() ([]*User, error) {
 	return users, nil
 }
 
-// UpdateUser updates an existing users' information
+// UpdateUser updates an existing users' information. A changed email or
+// phone number has to be verified again; user gets the verification flags
+// as stored.
 func (db *DB) UpdateUser(user *User) error {
-	query := `UPDATE users SET first_name = $1, last_name = $2, phone_number = $3, email = $4, status= $5 WHERE id = $6`
-	_, err := db.pool.Exec(context.Background(), query, user.FirstName, user.LastName, user.PhoneNumber, user.Email, user.Status, user.ID)
+	query := `UPDATE users SET first_name = $1, last_name = $2, phone_number = $3, email = $4, status= $5, role = COALESCE(NULLIF($6, ''), role),
+		email_verified = email_verified AND email = $4, phone_verified = phone_verified AND phone_number = $3 WHERE id = $7
+		RETURNING email_verified, phone_verified`
+	err := db.pool.QueryRow(context.Background(), query, user.FirstName, user.LastName, user.PhoneNumber, user.Email, user.Status, user.Role, user.ID).
+		Scan(&user.EmailVerified, &user.PhoneVerified)
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return fmt.Errorf("failed to update user: %w", userConflict(notFound(err, ErrUserNotFound)))
 	}
 
 	return nil
@@ -118,43 +144,13 @@ func (db *DB) UpdateUser(user *User) error {
 // DeleteUser deletes a user by ID
 func (db *DB) DeleteUser(id int64) error {
 	query := `DELETE FROM users WHERE ID = $1`
-	_, err := db.pool.Exec(context.Background(), query, id)
+	tag, err := db.pool.Exec(context.Background(), query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
-	return nil
-}
-
-// CreateRefreshToken inserts a new refresh token into the database
-func (db *DB) CreateRefreshToken(rt *RefreshToken) error {
-	query := `INSERT INTO refresh_tokens (user_id, token, expires_at, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
-	err := db.pool.QueryRow(context.Background(), query, rt.UserID, rt.Token, rt.ExpiresAt, rt.CreatedAt).Scan(&rt.ID)
-	if err != nil {
-		return fmt.Errorf("failed to create refresh token: %w", err)
-	}
-
-	return nil
-}
-
-// GetRefreshToken inserts a new refresh token into the database
-func (db *DB) GetRefreshToken(userID int64) (*RefreshToken, error) {
-	query := `SELECT * FROM refresh_tokens WHERE user_id = $1 AND expires_at > NOW() ORDER BY id DESC LIMIT 1`
-	rt := &RefreshToken{}
-	err := db.pool.QueryRow(context.Background(), query, userID).Scan(&rt.ID, &rt.UserID, &rt.Token, &rt.ExpiresAt, &rt.CreatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get refresh token: %w", err)
-	}
-
-	return rt, nil
-}
-
-// DeleteRefreshToken delete refresh token by user_id
-func (db *DB) DeleteRefreshToken(userId int64) error {
-	query := `DELETE FROM refresh_tokens WHERE user_id = $1`
-	_, err := db.pool.Exec(context.Background(), query, userId)
-	if err != nil {
-		return fmt.Errorf("failed to refresh token: %w", err)
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
 	}
 
 	return nil
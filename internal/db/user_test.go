@@ -1,8 +1,9 @@
 package db
 
 import (
+	"errors"
+	"strings"
 	"testing"
-	"time"
 )
 
 func TestCreateAndGetUser(t *testing.T) {
@@ -28,6 +29,23 @@ func TestCreateAndGetUser(t *testing.T) {
 	if gotUser == nil || gotUser.Email != user.Email {
 		t.Fatalf("GetUserByEmail returned wrong user")
 	}
+
+	gotUser, err = testDB.GetUserByEmail(strings.ToUpper(user.Email))
+	if err != nil || gotUser.ID != user.ID {
+		t.Fatalf("GetUserByEmail should ignore case, got %v, %v", gotUser, err)
+	}
+
+	gotUser, err = testDB.GetUserByPhoneNumber(user.PhoneNumber)
+	if err != nil {
+		t.Fatalf("GetUserByPhoneNumber failed: %v", err)
+	}
+	if gotUser.ID != user.ID {
+		t.Fatalf("GetUserByPhoneNumber returned wrong user")
+	}
+
+	if _, err := testDB.GetUserByPhoneNumber("0000000000"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUserByPhoneNumber of an unknown number: got %v, want ErrUserNotFound", err)
+	}
 }
 
 func TestUpdateUser(t *testing.T) {
@@ -86,52 +104,6 @@ func TestDeleteUser(t *testing.T) {
 	}
 }
 
-func TestCreateAndDeleteRefreshToken(t *testing.T) {
-	user := &User{
-		FirstName:   "Token",
-		LastName:    "User",
-		PhoneNumber: "4445556666",
-		Email:       "tokenuser@example.com",
-		Status:      "active",
-		Password:    "password123",
-	}
-
-	err := testDB.CreateUser(user)
-	if err != nil {
-		t.Fatalf("CreateUser failed: %v", err)
-	}
-
-	rt := &RefreshToken{
-		UserID:    user.ID,
-		Token:     "testtoken",
-		ExpiresAt: time.Now().Add(24 * time.Hour),
-		CreatedAt: time.Now(),
-	}
-
-	err = testDB.CreateRefreshToken(rt)
-	if err != nil {
-		t.Fatalf("CreateRefreshToken failed: %v", err)
-	}
-
-	gotRT, err := testDB.GetRefreshToken(rt.UserID)
-	if err != nil {
-		t.Fatalf("GetRefreshToken failed: %v", err)
-	}
-	if gotRT == nil || gotRT.Token != rt.Token {
-		t.Fatalf("GetRefreshToken returned wrong token")
-	}
-
-	err = testDB.DeleteRefreshToken(rt.UserID)
-	if err != nil {
-		t.Fatalf("DeleteRefreshToken failed: %v", err)
-	}
-
-	deletedRT, err := testDB.GetRefreshToken(rt.UserID)
-	if err == nil && deletedRT != nil {
-		t.Fatalf("DeleteRefreshToken did not delete token")
-	}
-}
-
 func TestGetAllUsers(t *testing.T) {
 	users, err := testDB.GetAllUsers()
 	if err != nil {
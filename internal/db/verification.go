@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Contact channels that can be verified
+const (
+	ContactEmail = "email"
+	ContactPhone = "phone"
+)
+
+// MaxVerificationAttempts is how many wrong codes a verification code takes
+// before a new one has to be requested. Wrong codes are also counted per
+// user across codes, see LoginSubjectVerify.
+const MaxVerificationAttempts = 5
+
+var (
+	ErrUnknownContact          = errors.New("unknown contact channel")
+	ErrVerificationCodeInvalid = errors.New("invalid or expired verification code")
+
+	// ErrContactUnverified is returned when money is moved for a user whose
+	// email or phone is not verified
+	ErrContactUnverified = errors.New("contact not verified")
+)
+
+// CreateVerificationCode stores the hash of a code sent to the user's email
+// or phone, replacing any earlier code for the channel. It returns the
+// address the code has to be sent to. An earlier code created less than
+// cooldown ago is kept, and the returned duration is how long the user has
+// to wait before a new one can be sent.
+func (db *DB) CreateVerificationCode(userID int64, channel, codeHash string, expiresAt time.Time, cooldown time.Duration) (string, time.Duration, error) {
+	column, _, err := contactColumns(channel)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the destination is recorded so a code stops working when the address
+	// changes before it is used
+	now := time.Now()
+	query := `INSERT INTO verification_codes (user_id, channel, destination, code_hash, expires_at, created_at)
+		SELECT id, $2, ` + column + `, $3, $4, $5 FROM users WHERE id = $1
+		ON CONFLICT (user_id, channel) DO UPDATE SET destination = EXCLUDED.destination, code_hash = EXCLUDED.code_hash,
+			attempts = 0, expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+		WHERE verification_codes.created_at <= $6
+		RETURNING destination`
+
+	ctx := context.Background()
+	var destination string
+	err = db.pool.QueryRow(ctx, query, userID, channel, codeHash, expiresAt, now, now.Add(-cooldown)).Scan(&destination)
+	if err == nil {
+		return destination, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, fmt.Errorf("failed to create verification code: %w", err)
+	}
+
+	// nothing was written: the user is unknown or the last code is too recent
+	var createdAt time.Time
+	err = db.pool.QueryRow(ctx, `SELECT created_at FROM verification_codes WHERE user_id = $1 AND channel = $2`, userID, channel).Scan(&createdAt)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create verification code: %w", notFound(err, ErrUserNotFound))
+	}
+
+	return "", createdAt.Add(cooldown).Sub(now), nil
+}
+
+// VerifyContact marks the user's email or phone as verified when codeHash
+// matches the pending code. Wrong codes count as attempts.
+func (db *DB) VerifyContact(userID int64, channel, codeHash string) error {
+	column, flag, err := contactColumns(channel)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	ctx := context.Background()
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		var id int64
+		var storedHash string
+		var attempts int
+		var current bool
+
+		query := `SELECT vc.id, vc.code_hash, vc.attempts, vc.destination = u.` + column + `
+			FROM verification_codes vc JOIN users u ON u.id = vc.user_id
+			WHERE vc.user_id = $1 AND vc.channel = $2 AND vc.expires_at > NOW() FOR UPDATE OF vc`
+		err := tx.QueryRow(ctx, query, userID, channel).Scan(&id, &storedHash, &attempts, &current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrVerificationCodeInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get verification code: %w", err)
+		}
+
+		if !current || attempts >= MaxVerificationAttempts {
+			return ErrVerificationCodeInvalid
+		}
+
+		if storedHash != codeHash {
+			failed = true
+			_, err := tx.Exec(ctx, `UPDATE verification_codes SET attempts = attempts + 1 WHERE id = $1`, id)
+			if err != nil {
+				return fmt.Errorf("failed to record verification attempt: %w", err)
+			}
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET `+flag+` = TRUE WHERE id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to verify %s: %w", channel, err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM verification_codes WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete verification code: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if failed {
+		return ErrVerificationCodeInvalid
+	}
+
+	return nil
+}
+
+// UserVerified reports whether both the user's email and phone are verified
+func (db *DB) UserVerified(userID int64) (bool, error) {
+	return userVerified(context.Background(), db.pool, userID)
+}
+
+func userVerified(ctx context.Context, q querier, userID int64) (bool, error) {
+	var verified bool
+	err := q.QueryRow(ctx, `SELECT email_verified AND phone_verified FROM users WHERE id = $1`, userID).Scan(&verified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get verification state: %w", err)
+	}
+
+	return verified, nil
+}
+
+// contactColumns maps a channel to its address and verification flag
+// columns in users. The names are spliced into queries, so only these are
+// allowed.
+func contactColumns(channel string) (string, string, error) {
+	switch channel {
+	case ContactEmail:
+		return "email", "email_verified", nil
+	case ContactPhone:
+		return "phone_number", "phone_verified", nil
+	default:
+		return "", "", fmt.Errorf("%w: %q", ErrUnknownContact, channel)
+	}
+}
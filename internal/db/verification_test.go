@@ -0,0 +1,67 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyContactAndResetOnChange(t *testing.T) {
+	user := &User{
+		FirstName:   "Verify",
+		LastName:    "User",
+		PhoneNumber: "4445550000",
+		Email:       "verifyuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	if err := testDB.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	expires := time.Now().Add(time.Minute)
+	destination, wait, err := testDB.CreateVerificationCode(user.ID, ContactEmail, "code-hash", expires, time.Minute)
+	if err != nil || wait != 0 || destination != user.Email {
+		t.Fatalf("CreateVerificationCode failed: %v, %v, %q", err, wait, destination)
+	}
+
+	// a resend within the cooldown keeps the first code
+	destination, wait, err = testDB.CreateVerificationCode(user.ID, ContactEmail, "other-hash", expires, time.Minute)
+	if err != nil || wait <= 0 || wait > time.Minute || destination != "" {
+		t.Fatalf("expected the resend to wait, got %v, %v, %q", err, wait, destination)
+	}
+
+	if err := testDB.VerifyContact(user.ID, ContactEmail, "wrong-hash"); !errors.Is(err, ErrVerificationCodeInvalid) {
+		t.Fatalf("expected ErrVerificationCodeInvalid, got %v", err)
+	}
+	if err := testDB.VerifyContact(user.ID, ContactEmail, "code-hash"); err != nil {
+		t.Fatalf("VerifyContact failed: %v", err)
+	}
+
+	if _, _, err := testDB.CreateVerificationCode(user.ID, ContactPhone, "phone-hash", expires, time.Minute); err != nil {
+		t.Fatalf("CreateVerificationCode failed: %v", err)
+	}
+	if err := testDB.VerifyContact(user.ID, ContactPhone, "phone-hash"); err != nil {
+		t.Fatalf("VerifyContact failed: %v", err)
+	}
+
+	verified, err := testDB.UserVerified(user.ID)
+	if err != nil || !verified {
+		t.Fatalf("expected the user to be verified, got %v, %v", verified, err)
+	}
+
+	// a new email has to be verified again; the phone stays verified
+	user.Email = "verifyuser2@example.com"
+	if err := testDB.UpdateUser(user); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+
+	if user.EmailVerified || !user.PhoneVerified {
+		t.Fatalf("expected UpdateUser to return the stored flags, got %+v", user)
+	}
+
+	updated, _ := testDB.GetUserByID(user.ID)
+	if updated.EmailVerified || !updated.PhoneVerified {
+		t.Fatalf("expected only the email flag to be reset, got %+v", updated)
+	}
+}
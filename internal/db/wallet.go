@@ -5,170 +5,560 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+var (
+	// ErrInsufficientFunds is returned when a wallet balance does not cover a debit
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrWalletExists is returned when a user already has a wallet in a currency
+	ErrWalletExists = fmt.Errorf("wallet %w for this currency", ErrDuplicate)
 )
 
-// Wallet represents a user's wallet
+// Wallet represents a user's wallet. Balance is the ledger balance;
+// AvailableBalance is what is left of it after active holds, and is what
+// withdrawals, transfers and new holds are checked against.
 type Wallet struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Balance   float64   `json:"balance"`
-	Currency  string    `json:"currency"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID               int64       `json:"id"`
+	UserID           int64       `json:"user_id"`
+	Balance          money.Money `json:"balance"`
+	AvailableBalance money.Money `json:"available_balance"`
+	Currency         string      `json:"currency"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
 }
 
 type Transaction struct {
-	ID           int64     `json:"id"`
-	Type         string    `json:"type"`
-	FromWalletID int64     `json:"from_wallet_id"`
-	ToWalletID   int64     `json:"to_wallet_id"`
-	Amount       float64   `json:"amount"`
-	Fee          float64   `json:"fee"`
-	Note         string    `json:"note"`
-	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64       `json:"id"`
+	Type         string      `json:"type"`
+	FromWalletID int64       `json:"from_wallet_id"`
+	ToWalletID   int64       `json:"to_wallet_id"`
+	Amount       money.Money `json:"amount"`
+	Fee          money.Money `json:"fee"`
+	Currency     string      `json:"currency"`
+	Note         string      `json:"note"`
+	Status       string      `json:"status"`
+	CreatedAt    time.Time   `json:"created_at"`
+
+	// ExchangeRate is the rate applied to a currency conversion, as a decimal string
+	ExchangeRate string `json:"exchange_rate,omitempty"`
+
+	// JournalEntryID links the transaction to its ledger entry; the send and
+	// receive records of a transfer share one entry.
+	JournalEntryID int64 `json:"journal_entry_id,omitempty"`
+
+	// ReversalOf is set on the records of a reversal to the send record of
+	// the transfer it reverses. RefundedAmount is set on the records of a
+	// transfer that was reversed, in part or in full.
+	ReversalOf     int64        `json:"reversal_of,omitempty"`
+	RefundedAmount *money.Money `json:"refunded_amount,omitempty"`
 }
 
-func (db *DB) GetWalletByID(id int64) (*Wallet, error) {
-	query := `SELECT * FROM  wallets WHERE id = $1`
+// walletColumns selects a wallets row aliased w for scanWallet, followed by
+// heldColumn, the sum of the wallet's active holds
+const (
+	walletColumns = `w.id, w.user_id, w.balance, w.currency, w.created_at, w.updated_at`
+	heldColumn    = `COALESCE((SELECT SUM(h.amount) FROM holds h WHERE h.wallet_id = w.id AND h.status = 'active' AND h.expires_at > NOW()), 0)`
+)
+
+// scanWallet reads a wallet row selected with walletColumns and heldColumn
+func scanWallet(row pgx.Row) (*Wallet, error) {
 	wallet := &Wallet{}
+	var balance, held pgtype.Numeric
+
+	err := row.Scan(&wallet.ID, &wallet.UserID, &balance, &wallet.Currency, &wallet.CreatedAt, &wallet.UpdatedAt, &held)
+	if err != nil {
+		return nil, err
+	}
 
-	err := db.pool.QueryRow(context.Background(), query, id).Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.Currency, &wallet.CreatedAt, &wallet.UpdatedAt)
+	wallet.Balance, err = money.FromNumeric(balance, wallet.Currency)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get wallet by id: %w", err)
+		return nil, err
+	}
+
+	if err := setAvailableBalance(wallet, held); err != nil {
+		return nil, err
 	}
 
 	return wallet, nil
 }
 
-func (db *DB) GetWalletByUserID(userID int64) (*Wallet, error) {
-	query := `SELECT * FROM  wallets WHERE user_id = $1`
-	wallet := &Wallet{}
+// setAvailableBalance sets the available balance of wallet from the sum of its active holds
+func setAvailableBalance(wallet *Wallet, held pgtype.Numeric) error {
+	heldAmount, err := money.FromNumeric(held, wallet.Currency)
+	if err != nil {
+		return err
+	}
 
-	err := db.pool.QueryRow(context.Background(), query, userID).Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.Currency, &wallet.CreatedAt, &wallet.UpdatedAt)
+	wallet.AvailableBalance, err = wallet.Balance.Sub(heldAmount)
+	return err
+}
+
+// checkFunds returns ErrInsufficientFunds unless the available balance of a
+// locked wallet covers debit
+func checkFunds(wallet *Wallet, debit money.Money) error {
+	if cmp, err := wallet.AvailableBalance.Cmp(debit); err != nil || cmp < 0 {
+		return ErrInsufficientFunds
+	}
+
+	return nil
+}
+
+func (db *DB) GetWalletByID(id int64) (*Wallet, error) {
+	query := `SELECT ` + walletColumns + `, ` + heldColumn + ` FROM wallets w WHERE w.id = $1`
+
+	wallet, err := scanWallet(db.pool.QueryRow(context.Background(), query, id))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get wallet by user id: %w", err)
+		return nil, fmt.Errorf("failed to get wallet by id: %w", notFound(err, ErrWalletNotFound))
 	}
 
 	return wallet, nil
 }
 
-func (db *DB) CreateWallet(userID int64) (*Wallet, error) {
-	query := `INSERT INTO wallets (user_id, balance, currency) VALUES ($1, $2, $3) RETURNING id, user_id, balance, currency, created_at, updated_at`
-	wallet := &Wallet{}
+// GetWalletsByUserID returns all wallets of a user, one per currency
+func (db *DB) GetWalletsByUserID(userID int64) ([]*Wallet, error) {
+	query := `SELECT ` + walletColumns + `, ` + heldColumn + ` FROM wallets w WHERE w.user_id = $1 ORDER BY w.id`
+	rows, err := db.pool.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallets by user id: %w", err)
+	}
+	defer rows.Close()
+
+	var wallets []*Wallet
+	for rows.Next() {
+		wallet, err := scanWallet(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan wallet: %w", err)
+		}
+
+		wallets = append(wallets, wallet)
+	}
 
-	err := db.pool.QueryRow(context.Background(), query, userID, 0.0, "USD").Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.Currency, &wallet.CreatedAt, &wallet.UpdatedAt)
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return wallets, nil
+}
+
+// GetWalletByUserIDAndCurrency returns the user's wallet in the given currency
+func (db *DB) GetWalletByUserIDAndCurrency(userID int64, currency string) (*Wallet, error) {
+	query := `SELECT ` + walletColumns + `, ` + heldColumn + ` FROM wallets w WHERE w.user_id = $1 AND w.currency = $2`
+
+	wallet, err := scanWallet(db.pool.QueryRow(context.Background(), query, userID, currency))
 	if err != nil {
-		return nil, fmt.Errorf("failed to creaqte wallet by user id: %w", err)
+		return nil, fmt.Errorf("failed to get wallet by user id and currency: %w", notFound(err, ErrWalletNotFound))
 	}
 
 	return wallet, nil
 }
 
-func (db *DB) UpdateWalletBalance(walletID int64, newBalance float64) error {
-	query := `UPDATE wallets SET balance = $1, updated_at = NOW() WHERE id = $2`
+// CreateWallet opens an empty wallet for the user in an ISO 4217 currency. A
+// user holds at most one wallet per currency; a second one returns ErrWalletExists.
+func (db *DB) CreateWallet(userID int64, currency string) (*Wallet, error) {
+	if _, err := money.LookupCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO wallets AS w (user_id, balance, currency) VALUES ($1, $2, $3) RETURNING ` + walletColumns + `, ` + heldColumn
 
-	_, err := db.pool.Exec(context.Background(), query, newBalance, walletID)
+	wallet, err := scanWallet(db.pool.QueryRow(context.Background(), query, userID, money.Zero(currency), currency))
 	if err != nil {
-		return fmt.Errorf("failed to update wallet balance: %w", err)
+		if _, ok := uniqueViolation(err); ok {
+			return nil, ErrWalletExists
+		}
+		return nil, fmt.Errorf("failed to create wallet: %w", err)
+	}
+
+	return wallet, nil
+}
+
+// lockWallet selects a wallet row FOR UPDATE inside tx. Holds on a wallet
+// only change under its lock, so its held amount is read by a statement of
+// its own once the lock is granted, to see holds committed by the previous
+// holder of the lock.
+func lockWallet(ctx context.Context, tx pgx.Tx, walletID int64) (*Wallet, error) {
+	query := `SELECT ` + walletColumns + `, 0::numeric FROM wallets w WHERE w.id = $1 FOR UPDATE`
+	wallet, err := scanWallet(tx.QueryRow(ctx, query, walletID))
+	if err != nil {
+		return nil, notFound(err, ErrWalletNotFound)
+	}
+
+	var held pgtype.Numeric
+	if err := tx.QueryRow(ctx, `SELECT `+heldColumn+` FROM wallets w WHERE w.id = $1`, walletID).Scan(&held); err != nil {
+		return nil, fmt.Errorf("failed to get held amount: %w", err)
+	}
+
+	if err := setAvailableBalance(wallet, held); err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// checkNotFrozen returns ErrWalletFrozen when the owner of one of wallets is
+// not active. Callers hold the wallet locks, so a ban that commits first is
+// seen here.
+func checkNotFrozen(ctx context.Context, tx pgx.Tx, wallets ...*Wallet) error {
+	ids := make([]int64, len(wallets))
+	for i, w := range wallets {
+		ids[i] = w.UserID
+	}
+
+	var frozen bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = ANY($1) AND status <> 'active')`
+	if err := tx.QueryRow(ctx, query, ids).Scan(&frozen); err != nil {
+		return fmt.Errorf("failed to check wallet owners: %w", err)
+	}
+
+	if frozen {
+		return ErrWalletFrozen
 	}
 
 	return nil
 }
 
-func (db *DB) TransferFunds(fromWalletID, toWalletID int64, amount float64) error {
-	if amount <= 0 {
-		return errors.New("amount must be positive number.")
+// insertTransaction records a transaction inside tx and fills in its ID and creation time
+func insertTransaction(ctx context.Context, tx pgx.Tx, t *Transaction) error {
+	t.Currency = t.Amount.Currency()
+	if t.Fee.Currency() == "" {
+		t.Fee = money.Zero(t.Currency)
 	}
 
-	ctx := context.Background()
-	tx, err := db.pool.Begin(ctx)
+	rate, err := rateNumeric(t.ExchangeRate)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
+	}
+
+	query := `INSERT INTO transactions (type, from_wallet_id, to_wallet_id, amount, fee, currency, note, status, journal_entry_id, exchange_rate, reversal_of) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, NULLIF($11, 0)) RETURNING id, created_at`
+	return tx.QueryRow(ctx, query, t.Type, t.FromWalletID, t.ToWalletID, t.Amount, t.Fee, t.Currency, t.Note, t.Status, t.JournalEntryID, rate, t.ReversalOf).Scan(&t.ID, &t.CreatedAt)
+}
+
+// Deposit credits amount to a wallet and records the deposit in one database transaction
+func (db *DB) Deposit(walletID int64, amount money.Money) (*Transaction, error) {
+	return db.applyWalletChange(walletID, "deposit", amount)
+}
+
+// Withdraw debits amount from a wallet and records the withdrawal in one
+// database transaction. It returns ErrInsufficientFunds when the balance does
+// not cover the amount.
+func (db *DB) Withdraw(walletID int64, amount money.Money) (*Transaction, error) {
+	return db.applyWalletChange(walletID, "withdrawal", amount)
+}
+
+// applyWalletChange locks the wallet row, posts the deposit or withdrawal
+// against the matching system account and writes the transaction record.
+// Withdrawals are charged the fee of the matching withdrawal fee rule on top
+// of the amount.
+func (db *DB) applyWalletChange(walletID int64, txType string, amount money.Money) (*Transaction, error) {
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
 	}
-	defer func() {
+
+	t := &Transaction{
+		Type:         txType,
+		FromWalletID: walletID,
+		Amount:       amount,
+		Status:       "completed",
+	}
+
+	ctx := context.Background()
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		wallet, err := lockWallet(ctx, tx, walletID)
 		if err != nil {
-			tx.Rollback(ctx)
-		} else {
-			tx.Commit(ctx)
+			return fmt.Errorf("failed to lock wallet: %w", err)
+		}
+
+		if err := checkNotFrozen(ctx, tx, wallet); err != nil {
+			return err
+		}
+
+		// A deposit moves money from cash-in into the wallet, a withdrawal
+		// moves it from the wallet out to cash-out and its fee to fees.
+		delta, counterAccount := amount, SystemAccountCashIn
+		t.Fee = money.Zero(amount.Currency())
+		if txType == "withdrawal" {
+			if t.Fee, err = feeFor(ctx, tx, FeeTypeWithdrawal, amount); err != nil {
+				return err
+			}
+
+			total, err := amount.Add(t.Fee)
+			if err != nil {
+				return err
+			}
+			delta, counterAccount = total.Neg(), SystemAccountCashOut
 		}
-	}()
 
-	var fromBalance float64
-	err = tx.QueryRow(ctx, `SELECT balance FROM wallets WHERE id = $1 FOR UPDATE`, fromWalletID).Scan(&fromBalance)
+		if delta.IsNegative() {
+			if err := checkFunds(wallet, delta.Neg()); err != nil {
+				return err
+			}
+		}
+
+		walletPosting, err := walletLeg(ctx, tx, wallet, delta)
+		if err != nil {
+			return err
+		}
+
+		counterAmount := amount
+		if txType == "deposit" {
+			counterAmount = amount.Neg()
+		}
+
+		counterPosting, err := systemLeg(ctx, tx, counterAccount, counterAmount)
+		if err != nil {
+			return err
+		}
+
+		legs := []ledgerLeg{walletPosting, counterPosting}
+		if t.Fee.IsPositive() {
+			feePosting, err := systemLeg(ctx, tx, SystemAccountFees, t.Fee)
+			if err != nil {
+				return err
+			}
+			legs = append(legs, feePosting)
+		}
+
+		t.JournalEntryID, err = postJournal(ctx, tx, txType, legs...)
+		if err != nil {
+			return err
+		}
+
+		if err := insertTransaction(ctx, tx, t); err != nil {
+			return fmt.Errorf("failed to create transaction: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get sender wallet balance: %w", err)
+		return nil, err
 	}
 
-	if fromBalance < amount {
-		return errors.New("insufficient account balance")
+	return t, nil
+}
+
+// Transfer describes a movement of money between two wallets. Without a
+// QuoteID both wallets must hold the currency of Amount. With a QuoteID the
+// wallets may differ in currency: the sender pays the quoted amount plus its
+// fee and the receiver gets the converted amount; Amount may then be left
+// zero. TransferFunds fills in the Send and Receive records.
+type Transfer struct {
+	FromWalletID int64
+	ToWalletID   int64
+	Amount       money.Money
+	QuoteID      string
+
+	Send    *Transaction
+	Receive *Transaction
+}
+
+// TransferFunds executes a transfer. Both wallet rows are locked, in ID order
+// to avoid deadlocks, and the movement is posted to the ledger together with
+// the send and receive records. A same-currency transfer is charged the fee of
+// the matching transfer fee rule on top of the amount; a conversion is charged
+// its quote's spread instead.
+func (db *DB) TransferFunds(t *Transfer) error {
+	ctx := context.Background()
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		return transferFunds(ctx, tx, t)
+	})
+}
+
+// transferFunds executes a transfer inside tx, see TransferFunds
+func transferFunds(ctx context.Context, tx pgx.Tx, t *Transfer) error {
+	if t.QuoteID == "" && !t.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", money.ErrInvalidAmount)
 	}
 
-	var toBalance float64
-	err = tx.QueryRow(ctx, `SELECT balance FROM wallets WHERE id = $1 FOR UPDATE`, toWalletID).Scan(&toBalance)
+	fromWallet, toWallet, err := lockWalletPair(ctx, tx, t.FromWalletID, t.ToWalletID)
 	if err != nil {
-		return fmt.Errorf("failed to get receiver wallet balance: %w", err)
+		return err
+	}
+
+	if err := checkNotFrozen(ctx, tx, fromWallet, toWallet); err != nil {
+		return err
+	}
+
+	if t.QuoteID != "" {
+		return convertFunds(ctx, tx, t, fromWallet, toWallet)
+	}
+
+	amount := t.Amount
+	if fromWallet.Currency != amount.Currency() || toWallet.Currency != amount.Currency() {
+		return fmt.Errorf("%w: cannot transfer %s between %s and %s wallets", money.ErrCurrencyMismatch, amount.Currency(), fromWallet.Currency, toWallet.Currency)
 	}
 
-	// update balance
-	_, err = tx.Exec(ctx, `UPDATE wallets SET balance = $1, updated_at = NOW() WHERE id = $2`, fromBalance-amount, fromWalletID)
+	fee, err := feeFor(ctx, tx, FeeTypeTransfer, amount)
 	if err != nil {
-		return fmt.Errorf("failed to debit sender wallet: %w", err)
+		return err
 	}
 
-	_, err = tx.Exec(ctx, `UPDATE wallets SET balance = $1, updated_at = NOW() WHERE id = $2`, toBalance+amount, toWalletID)
+	total, err := amount.Add(fee)
 	if err != nil {
-		return fmt.Errorf("failed to credit receiver wallet: %w", err)
+		return err
 	}
 
-	// Save transactions
-	_, err = tx.Exec(ctx, `INSERT INTO transactions (type, from_wallet_id, to_wallet_id, amount, status) VALUES ('send', $1, $2, $3, $4)`, fromWalletID, toWalletID, amount, "completed")
+	if err := checkFunds(fromWallet, total); err != nil {
+		return err
+	}
+
+	debit, err := walletLeg(ctx, tx, fromWallet, total.Neg())
 	if err != nil {
-		return fmt.Errorf("failed to log sender transaction: %w", err)
+		return err
 	}
 
-	_, err = tx.Exec(ctx, `INSERT INTO transactions (type, from_wallet_id, to_wallet_id, amount, status) VALUES ('receive', $1, $2, $3, $4)`, toWalletID, fromWalletID, amount, "completed")
+	credit, err := walletLeg(ctx, tx, toWallet, amount)
 	if err != nil {
-		return fmt.Errorf("failed to log receiver transaction: %w", err)
+		return err
 	}
 
-	return nil
-}
+	legs := []ledgerLeg{debit, credit}
+	if fee.IsPositive() {
+		feeCredit, err := systemLeg(ctx, tx, SystemAccountFees, fee)
+		if err != nil {
+			return err
+		}
+		legs = append(legs, feeCredit)
+	}
 
-// CreateTransaction inserts a new tranaction into the database
-func (db *DB) CreateTransaction(tx *Transaction) error {
-	query := `INSERT INTO transactions (type, from_wallet_id, to_wallet_id, amount, fee, note, status) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ID`
-	err := db.pool.QueryRow(context.Background(), query, tx.Type, tx.FromWalletID, tx.ToWalletID, tx.Amount, tx.Fee, tx.Note, tx.Status).Scan(&tx.ID)
+	entryID, err := postJournal(ctx, tx, "transfer", legs...)
 	if err != nil {
-		fmt.Println(err)
-		return fmt.Errorf("failed to create transaction: %w", err)
+		return err
 	}
 
-	return nil
+	t.Send = &Transaction{Type: "send", FromWalletID: t.FromWalletID, ToWalletID: t.ToWalletID, Amount: amount, Fee: fee, Status: "completed", JournalEntryID: entryID}
+	t.Receive = &Transaction{Type: "receive", FromWalletID: t.ToWalletID, ToWalletID: t.FromWalletID, Amount: amount, Status: "completed", JournalEntryID: entryID}
+	return insertTransferRecords(ctx, tx, t)
 }
 
-func (db *DB) GetTransactionsByWalletID(fromWalletID int64) ([]*Transaction, error) {
-	query := `SELECT id, type, from_wallet_id, to_wallet_id, amount, fee, status, created_at FROM transactions WHERE from_wallet_id = $1 ORDER BY created_at DESC`
-	rows, err := db.pool.Query(context.Background(), query, fromWalletID)
+// convertFunds executes a transfer between wallets of different currencies
+// at the rate locked by its quote. The sender is debited the quoted amount
+// plus the spread fee; the amount is exchanged through the fx system account
+// and the fee is credited to the fees account.
+func convertFunds(ctx context.Context, tx pgx.Tx, t *Transfer, fromWallet, toWallet *Wallet) error {
+	quote, err := lockFXQuote(ctx, tx, t.QuoteID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transactions: %w", err)
+		return err
 	}
-	defer rows.Close()
 
-	var transactions []*Transaction
-	for rows.Next() {
-		tx := &Transaction{}
-		err := rows.Scan(&tx.ID, &tx.Type, &tx.FromWalletID, &tx.ToWalletID, &tx.Amount, &tx.Fee, &tx.Status, &tx.CreatedAt)
+	// a quote is only usable by the user it was issued to
+	if quote.UserID != fromWallet.UserID {
+		return ErrQuoteNotFound
+	}
+
+	if quote.UsedAt != nil {
+		return ErrQuoteUsed
+	}
+
+	if !quote.ExpiresAt.After(time.Now()) {
+		return ErrQuoteExpired
+	}
+
+	if fromWallet.Currency != quote.FromCurrency || toWallet.Currency != quote.ToCurrency || (!t.Amount.IsZero() && t.Amount != quote.Amount) {
+		return ErrQuoteMismatch
+	}
+
+	debit, err := quote.Amount.Add(quote.Fee)
+	if err != nil {
+		return err
+	}
+
+	if err := checkFunds(fromWallet, debit); err != nil {
+		return err
+	}
+
+	legs := make([]ledgerLeg, 0, 5)
+	for _, leg := range []struct {
+		wallet *Wallet
+		system string
+		amount money.Money
+	}{
+		{wallet: fromWallet, amount: debit.Neg()},
+		{system: SystemAccountFees, amount: quote.Fee},
+		{system: SystemAccountFX, amount: quote.Amount},
+		{system: SystemAccountFX, amount: quote.ConvertedAmount.Neg()},
+		{wallet: toWallet, amount: quote.ConvertedAmount},
+	} {
+		var l ledgerLeg
+		if leg.wallet != nil {
+			l, err = walletLeg(ctx, tx, leg.wallet, leg.amount)
+		} else {
+			l, err = systemLeg(ctx, tx, leg.system, leg.amount)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+			return err
 		}
+		legs = append(legs, l)
+	}
 
-		transactions = append(transactions, tx)
+	entryID, err := postJournal(ctx, tx, "currency conversion", legs...)
+	if err != nil {
+		return err
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %w", err)
+	if _, err := tx.Exec(ctx, `UPDATE fx_quotes SET used_at = NOW() WHERE id = $1`, quote.ID); err != nil {
+		return fmt.Errorf("failed to mark quote as used: %w", err)
+	}
+
+	t.Amount = quote.Amount
+	t.Send = &Transaction{Type: "send", FromWalletID: t.FromWalletID, ToWalletID: t.ToWalletID, Amount: quote.Amount, Fee: quote.Fee, ExchangeRate: quote.Rate, Status: "completed", JournalEntryID: entryID}
+	t.Receive = &Transaction{Type: "receive", FromWalletID: t.ToWalletID, ToWalletID: t.FromWalletID, Amount: quote.ConvertedAmount, ExchangeRate: quote.Rate, Status: "completed", JournalEntryID: entryID}
+	return insertTransferRecords(ctx, tx, t)
+}
+
+// insertTransferRecords saves the send and receive records of a transfer
+func insertTransferRecords(ctx context.Context, tx pgx.Tx, t *Transfer) error {
+	if err := insertTransaction(ctx, tx, t.Send); err != nil {
+		return fmt.Errorf("failed to log sender transaction: %w", err)
+	}
+
+	if err := insertTransaction(ctx, tx, t.Receive); err != nil {
+		return fmt.Errorf("failed to log receiver transaction: %w", err)
+	}
+
+	return nil
+}
+
+// lockWalletPair locks two wallets in ID order and returns them in argument order
+func lockWalletPair(ctx context.Context, tx pgx.Tx, fromWalletID, toWalletID int64) (*Wallet, *Wallet, error) {
+	firstID, secondID := fromWalletID, toWalletID
+	if secondID < firstID {
+		firstID, secondID = secondID, firstID
+	}
+
+	first, err := lockWallet(ctx, tx, firstID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock wallet %d: %w", firstID, err)
+	}
+
+	second, err := lockWallet(ctx, tx, secondID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock wallet %d: %w", secondID, err)
+	}
+
+	if first.ID == fromWalletID {
+		return first, second, nil
 	}
 
-	return transactions, nil
+	return second, first, nil
+}
+
+// CreateTransaction inserts a new tranaction into the database
+func (db *DB) CreateTransaction(tx *Transaction) error {
+	tx.Currency = tx.Amount.Currency()
+	if tx.Fee.Currency() == "" {
+		tx.Fee = money.Zero(tx.Currency)
+	}
+
+	query := `INSERT INTO transactions (type, from_wallet_id, to_wallet_id, amount, fee, currency, note, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID`
+	err := db.pool.QueryRow(context.Background(), query, tx.Type, tx.FromWalletID, tx.ToWalletID, tx.Amount, tx.Fee, tx.Currency, tx.Note, tx.Status).Scan(&tx.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	return nil
 }
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/masudcsesust04/ewallet-api/internal/fees"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,19 +30,19 @@ func TestCreateAndGetWallet(t *testing.T) {
 	userID := int64(user.ID)
 
 	// Create wallet
-	wallet, err := db.CreateWallet(userID)
+	wallet, err := db.CreateWallet(userID, "USD")
 	assert.NoError(t, err)
 	assert.Equal(t, userID, wallet.UserID)
-	assert.Equal(t, float64(0), wallet.Balance)
+	assert.True(t, wallet.Balance.IsZero())
 
 	// Get wallet
-	gotWallet, err := db.GetWalletByUserID(userID)
+	gotWallet, err := db.GetWalletByUserIDAndCurrency(userID, "USD")
 	assert.NoError(t, err)
 	assert.Equal(t, wallet.ID, gotWallet.ID)
 	assert.Equal(t, wallet.UserID, gotWallet.UserID)
 }
 
-func TestUpdateWalletBalance(t *testing.T) {
+func TestRecomputeWalletBalance(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -56,16 +60,26 @@ func TestUpdateWalletBalance(t *testing.T) {
 
 	userID := int64(user.ID)
 
-	wallet, err := db.CreateWallet(userID)
+	wallet, err := db.CreateWallet(userID, "USD")
 	assert.NoError(t, err)
 
-	newBalance := 123.45
-	err = db.UpdateWalletBalance(wallet.ID, newBalance)
+	amount, err := money.Parse("123.45", wallet.Currency)
 	assert.NoError(t, err)
 
-	updatedWallet, err := db.GetWalletByUserID(userID)
+	_, err = db.Deposit(wallet.ID, amount)
 	assert.NoError(t, err)
-	assert.Equal(t, newBalance, updatedWallet.Balance)
+
+	// Corrupt the cached balance and rebuild it from the postings
+	_, err = db.pool.Exec(context.Background(), `UPDATE wallets SET balance = 0 WHERE id = $1`, wallet.ID)
+	assert.NoError(t, err)
+
+	balance, err := db.RecomputeWalletBalance(wallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "123.45", balance.String())
+
+	updatedWallet, err := db.GetWalletByUserIDAndCurrency(userID, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "123.45", updatedWallet.Balance.String())
 }
 
 func TestCreateTransaction(t *testing.T) {
@@ -86,13 +100,16 @@ func TestCreateTransaction(t *testing.T) {
 
 	userID := int64(user.ID)
 
-	wallet, err := db.CreateWallet(userID)
+	wallet, err := db.CreateWallet(userID, "USD")
+	assert.NoError(t, err)
+
+	amount, err := money.Parse("100", wallet.Currency)
 	assert.NoError(t, err)
 
 	txn := &Transaction{
 		FromWalletID: wallet.ID,
 		Type:         "deposit",
-		Amount:       100.0,
+		Amount:       amount,
 		Status:       "completed",
 		CreatedAt:    time.Now(),
 	}
@@ -101,3 +118,247 @@ func TestCreateTransaction(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotZero(t, txn.ID)
 }
+
+func TestDepositAndWithdraw(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := &User{
+		FirstName:   "Cash",
+		LastName:    "User",
+		PhoneNumber: "2223334444",
+		Email:       "cashuser@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	err := db.CreateUser(user)
+	assert.NoError(t, err)
+
+	wallet, err := db.CreateWallet(user.ID, "USD")
+	assert.NoError(t, err)
+
+	deposit, _ := money.Parse("100", wallet.Currency)
+	txn, err := db.Deposit(wallet.ID, deposit)
+	assert.NoError(t, err)
+	assert.NotZero(t, txn.ID)
+	assert.Equal(t, "deposit", txn.Type)
+
+	withdrawal, _ := money.Parse("40.50", wallet.Currency)
+	_, err = db.Withdraw(wallet.ID, withdrawal)
+	assert.NoError(t, err)
+
+	tooMuch, _ := money.Parse("59.51", wallet.Currency)
+	_, err = db.Withdraw(wallet.ID, tooMuch)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	updatedWallet, err := db.GetWalletByID(wallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "59.50", updatedWallet.Balance.String())
+
+	transactions, next, err := db.GetTransactions(TransactionQuery{WalletID: wallet.ID})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Nil(t, next)
+}
+
+func TestTransferFundsPostsBalancedEntry(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i, email := range []string{"sender@example.com", "receiver@example.com"} {
+		user := &User{
+			FirstName:   "Ledger",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("555000%04d", i),
+			Email:       email,
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	deposit, _ := money.Parse("50", "USD")
+	_, err := db.Deposit(wallets[0].ID, deposit)
+	assert.NoError(t, err)
+
+	amount, _ := money.Parse("20", "USD")
+	transfer := &Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: amount}
+	err = db.TransferFunds(transfer)
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.Send.JournalEntryID, transfer.Receive.JournalEntryID)
+
+	senderBalance, err := db.LedgerBalance(wallets[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "30.00", senderBalance.String())
+
+	receiverBalance, err := db.LedgerBalance(wallets[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "20.00", receiverBalance.String())
+
+	postings, err := db.GetPostingsByWalletID(wallets[1].ID)
+	assert.NoError(t, err)
+	assert.Len(t, postings, 1)
+
+	err = db.TransferFunds(&Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: deposit})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+}
+
+func TestWalletPerCurrency(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := &User{
+		FirstName:   "Multi",
+		LastName:    "Currency",
+		PhoneNumber: "7770001111",
+		Email:       "multicurrency@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	assert.NoError(t, db.CreateUser(user))
+
+	_, err := db.CreateWallet(user.ID, "USD")
+	assert.NoError(t, err)
+
+	eur, err := db.CreateWallet(user.ID, "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", eur.Currency)
+
+	_, err = db.CreateWallet(user.ID, "EUR")
+	assert.ErrorIs(t, err, ErrWalletExists)
+
+	_, err = db.CreateWallet(user.ID, "XYZ")
+	assert.ErrorIs(t, err, money.ErrUnknownCurrency)
+
+	wallets, err := db.GetWalletsByUserID(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, wallets, 2)
+}
+
+func TestTransferFundsWithQuote(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := &User{
+		FirstName:   "Fx",
+		LastName:    "User",
+		PhoneNumber: "8880001111",
+		Email:       "fx@example.com",
+		Status:      "active",
+		Password:    "password123",
+	}
+	assert.NoError(t, db.CreateUser(user))
+
+	usdWallet, err := db.CreateWallet(user.ID, "USD")
+	assert.NoError(t, err)
+	eurWallet, err := db.CreateWallet(user.ID, "EUR")
+	assert.NoError(t, err)
+
+	deposit, _ := money.Parse("200", "USD")
+	_, err = db.Deposit(usdWallet.ID, deposit)
+	assert.NoError(t, err)
+
+	amount, _ := money.Parse("100", "USD")
+	fee, _ := money.Parse("0.50", "USD")
+	converted, _ := money.Parse("92", "EUR")
+	quote := &FXQuote{
+		ID:              "quote-1",
+		UserID:          user.ID,
+		FromCurrency:    "USD",
+		ToCurrency:      "EUR",
+		Rate:            "0.92",
+		Amount:          amount,
+		Fee:             fee,
+		ConvertedAmount: converted,
+		ExpiresAt:       time.Now().Add(time.Minute),
+	}
+	assert.NoError(t, db.CreateFXQuote(quote))
+
+	// without the quote the currencies do not match
+	err = db.TransferFunds(&Transfer{FromWalletID: usdWallet.ID, ToWalletID: eurWallet.ID, Amount: amount})
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+
+	transfer := &Transfer{FromWalletID: usdWallet.ID, ToWalletID: eurWallet.ID, QuoteID: quote.ID}
+	assert.NoError(t, db.TransferFunds(transfer))
+	assert.Equal(t, "0.50", transfer.Send.Fee.String())
+	assert.Equal(t, "EUR", transfer.Receive.Currency)
+
+	usdBalance, err := db.LedgerBalance(usdWallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "99.50", usdBalance.String())
+
+	eurBalance, err := db.LedgerBalance(eurWallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "92.00", eurBalance.String())
+
+	transactions, _, err := db.GetTransactions(TransactionQuery{WalletID: eurWallet.ID})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "0.9200000000", transactions[0].ExchangeRate)
+
+	err = db.TransferFunds(&Transfer{FromWalletID: usdWallet.ID, ToWalletID: eurWallet.ID, QuoteID: quote.ID})
+	assert.ErrorIs(t, err, ErrQuoteUsed)
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
+
+func TestFeeRulesChargeTransfersAndWithdrawals(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var wallets []*Wallet
+	for i, email := range []string{"feepayer@example.com", "feepayee@example.com"} {
+		user := &User{
+			FirstName:   "Fee",
+			LastName:    "User",
+			PhoneNumber: fmt.Sprintf("666000%04d", i),
+			Email:       email,
+			Status:      "active",
+			Password:    "password123",
+		}
+		assert.NoError(t, db.CreateUser(user))
+
+		wallet, err := db.CreateWallet(user.ID, "USD")
+		assert.NoError(t, err)
+		wallets = append(wallets, wallet)
+	}
+
+	zero, _ := money.Parse("0", "USD")
+	flat, _ := money.Parse("0.25", "USD")
+	maxFee, _ := money.Parse("2", "USD")
+	assert.NoError(t, db.CreateFeeRule(&FeeRule{TxType: FeeTypeTransfer, Currency: "USD", Rule: fees.Rule{MinAmount: zero, FlatFee: flat, Percent: "1", MinFee: zero, MaxFee: &maxFee}, Active: true}))
+	assert.NoError(t, db.CreateFeeRule(&FeeRule{TxType: FeeTypeWithdrawal, Currency: "USD", Rule: fees.Rule{MinAmount: zero, FlatFee: flat, MinFee: zero}, Active: true}))
+
+	deposit, _ := money.Parse("100", "USD")
+	_, err := db.Deposit(wallets[0].ID, deposit)
+	assert.NoError(t, err)
+
+	amount, _ := money.Parse("50", "USD")
+	transfer := &Transfer{FromWalletID: wallets[0].ID, ToWalletID: wallets[1].ID, Amount: amount}
+	assert.NoError(t, db.TransferFunds(transfer))
+	assert.Equal(t, "0.75", transfer.Send.Fee.String())
+
+	withdrawal, err := db.Withdraw(wallets[0].ID, amount)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.Nil(t, withdrawal)
+
+	amount, _ = money.Parse("40", "USD")
+	withdrawal, err = db.Withdraw(wallets[0].ID, amount)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.25", withdrawal.Fee.String())
+
+	balance, err := db.LedgerBalance(wallets[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "9.00", balance.String())
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
@@ -0,0 +1,149 @@
+package fees
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// ErrInvalidRule is returned when a rule's amounts or percentage are malformed
+var ErrInvalidRule = errors.New("invalid fee rule")
+
+// Rule prices the fee for amounts within a band. The fee is FlatFee plus
+// Percent of the amount, clamped to MinFee and MaxFee. MaxAmount and MaxFee
+// are optional; nil means no upper bound.
+type Rule struct {
+	MinAmount money.Money  `json:"min_amount"`
+	MaxAmount *money.Money `json:"max_amount,omitempty"`
+	FlatFee   money.Money  `json:"flat_fee"`
+	Percent   string       `json:"percent"`
+	MinFee    money.Money  `json:"min_fee"`
+	MaxFee    *money.Money `json:"max_fee,omitempty"`
+}
+
+// Validate checks that the rule's amounts share one currency and describe a
+// non-empty band and fee range.
+func (r *Rule) Validate(currency string) error {
+	amounts := []money.Money{r.MinAmount, r.FlatFee, r.MinFee}
+	if r.MaxAmount != nil {
+		amounts = append(amounts, *r.MaxAmount)
+	}
+	if r.MaxFee != nil {
+		amounts = append(amounts, *r.MaxFee)
+	}
+
+	for _, m := range amounts {
+		if m.Currency() != currency {
+			return fmt.Errorf("%w: %w", ErrInvalidRule, money.ErrCurrencyMismatch)
+		}
+		if m.IsNegative() {
+			return fmt.Errorf("%w: amounts must not be negative", ErrInvalidRule)
+		}
+	}
+
+	if r.MaxAmount != nil {
+		if cmp, _ := r.MaxAmount.Cmp(r.MinAmount); cmp < 0 {
+			return fmt.Errorf("%w: max_amount is below min_amount", ErrInvalidRule)
+		}
+	}
+
+	if r.MaxFee != nil {
+		if cmp, _ := r.MaxFee.Cmp(r.MinFee); cmp < 0 {
+			return fmt.Errorf("%w: max_fee is below min_fee", ErrInvalidRule)
+		}
+	}
+
+	if _, err := r.percent(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Matches reports whether amount falls within the rule's band. Both bounds
+// are inclusive.
+func (r *Rule) Matches(amount money.Money) bool {
+	if cmp, err := amount.Cmp(r.MinAmount); err != nil || cmp < 0 {
+		return false
+	}
+
+	if r.MaxAmount != nil {
+		if cmp, err := amount.Cmp(*r.MaxAmount); err != nil || cmp > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fee returns the fee the rule charges on amount
+func (r *Rule) Fee(amount money.Money) (money.Money, error) {
+	percent, err := r.percent()
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	fee, err := amount.Mul(percent.Quo(percent, big.NewRat(100, 1)))
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	if fee, err = fee.Add(r.FlatFee); err != nil {
+		return money.Money{}, err
+	}
+
+	if cmp, err := fee.Cmp(r.MinFee); err != nil {
+		return money.Money{}, err
+	} else if cmp < 0 {
+		fee = r.MinFee
+	}
+
+	if r.MaxFee != nil {
+		if cmp, err := fee.Cmp(*r.MaxFee); err != nil {
+			return money.Money{}, err
+		} else if cmp > 0 {
+			fee = *r.MaxFee
+		}
+	}
+
+	return fee, nil
+}
+
+func (r *Rule) percent() (*big.Rat, error) {
+	if r.Percent == "" {
+		return new(big.Rat), nil
+	}
+
+	p, ok := new(big.Rat).SetString(r.Percent)
+	if !ok || p.Sign() < 0 || p.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, fmt.Errorf("%w: percent %q must be between 0 and 100", ErrInvalidRule, r.Percent)
+	}
+
+	return p, nil
+}
+
+// Compute returns the fee charged on amount by the matching rule with the
+// highest MinAmount, or zero when no rule matches. Rules must be in the
+// currency of amount.
+func Compute(rules []Rule, amount money.Money) (money.Money, error) {
+	var match *Rule
+	for i := range rules {
+		if !rules[i].Matches(amount) {
+			continue
+		}
+
+		if match == nil {
+			match = &rules[i]
+		} else if cmp, _ := rules[i].MinAmount.Cmp(match.MinAmount); cmp > 0 {
+			match = &rules[i]
+		}
+	}
+
+	if match == nil {
+		return money.Zero(amount.Currency()), nil
+	}
+
+	return match.Fee(amount)
+}
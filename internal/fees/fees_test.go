@@ -0,0 +1,83 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func usd(s string) money.Money {
+	m, err := money.Parse(s, "USD")
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func usdPtr(s string) *money.Money {
+	m := usd(s)
+	return &m
+}
+
+func TestRuleFee(t *testing.T) {
+	rule := Rule{
+		MinAmount: usd("0"),
+		FlatFee:   usd("0.30"),
+		Percent:   "2.5",
+		MinFee:    usd("0.50"),
+		MaxFee:    usdPtr("10"),
+	}
+
+	tests := []struct {
+		amount string
+		fee    string
+	}{
+		{"100", "2.80"},
+		{"5", "0.50"},
+		{"1000", "10.00"},
+		{"10.10", "0.55"},
+	}
+
+	for _, tc := range tests {
+		fee, err := rule.Fee(usd(tc.amount))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.fee, fee.String(), "fee on %s", tc.amount)
+	}
+}
+
+func TestComputePicksHighestMatchingBand(t *testing.T) {
+	rules := []Rule{
+		{MinAmount: usd("0"), MaxAmount: usdPtr("100"), FlatFee: usd("1"), MinFee: usd("0")},
+		{MinAmount: usd("100.01"), FlatFee: usd("0"), Percent: "1", MinFee: usd("0")},
+		{MinAmount: usd("50"), MaxAmount: usdPtr("60"), FlatFee: usd("0.25"), MinFee: usd("0")},
+	}
+
+	fee, err := Compute(rules, usd("20"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.00", fee.String())
+
+	fee, _ = Compute(rules, usd("55"))
+	assert.Equal(t, "0.25", fee.String())
+
+	fee, _ = Compute(rules, usd("500"))
+	assert.Equal(t, "5.00", fee.String())
+
+	fee, err = Compute(nil, usd("500"))
+	assert.NoError(t, err)
+	assert.True(t, fee.IsZero())
+	assert.Equal(t, "USD", fee.Currency())
+}
+
+func TestValidate(t *testing.T) {
+	rule := Rule{MinAmount: usd("10"), MaxAmount: usdPtr("5"), FlatFee: usd("0"), MinFee: usd("0")}
+	assert.True(t, errors.Is(rule.Validate("USD"), ErrInvalidRule))
+
+	rule = Rule{MinAmount: usd("0"), FlatFee: usd("0"), MinFee: usd("0"), Percent: "120"}
+	assert.True(t, errors.Is(rule.Validate("USD"), ErrInvalidRule))
+
+	rule = Rule{MinAmount: usd("0"), FlatFee: usd("0"), MinFee: usd("0"), Percent: "1.5"}
+	assert.NoError(t, rule.Validate("USD"))
+	assert.Error(t, rule.Validate("EUR"))
+}
@@ -0,0 +1,147 @@
+package fx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// RateScale is the number of decimal places exchange rates are kept to. It
+// matches the NUMERIC(20, 10) rate columns.
+const RateScale = 10
+
+// ErrRateNotFound is returned when no rate is known for a currency pair
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// RateProvider returns mid-market exchange rates. A rate is the price of one
+// unit of from expressed in to.
+type RateProvider interface {
+	Rate(from, to string) (*big.Rat, error)
+}
+
+// FileProvider serves rates loaded from a JSON file of the form
+//
+//	{"base": "USD", "rates": {"EUR": "0.92", "BDT": "117.50"}}
+//
+// Each rate is the price of one unit of base. Rates between two non-base
+// currencies are derived through the base.
+type FileProvider struct {
+	base  string
+	rates map[string]*big.Rat
+}
+
+type rateFile struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// LoadFile reads a rate file
+func LoadFile(path string) (*FileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate file: %w", err)
+	}
+
+	var f rateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse rate file: %w", err)
+	}
+
+	if _, err := money.LookupCurrency(f.Base); err != nil {
+		return nil, fmt.Errorf("rate file base: %w", err)
+	}
+
+	p := &FileProvider{base: f.Base, rates: map[string]*big.Rat{f.Base: big.NewRat(1, 1)}}
+	for code, s := range f.Rates {
+		if _, err := money.LookupCurrency(code); err != nil {
+			return nil, fmt.Errorf("rate file: %w", err)
+		}
+
+		r, ok := new(big.Rat).SetString(s)
+		if !ok || r.Sign() <= 0 {
+			return nil, fmt.Errorf("rate file: invalid rate %q for %s", s, code)
+		}
+
+		p.rates[code] = r
+	}
+
+	return p, nil
+}
+
+// Rate implements RateProvider
+func (p *FileProvider) Rate(from, to string) (*big.Rat, error) {
+	fromRate, ok := p.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+	}
+
+	toRate, ok := p.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+	}
+
+	return Round(new(big.Rat).Quo(toRate, fromRate)), nil
+}
+
+// Round rounds a rate to RateScale decimal places, half to even
+func Round(r *big.Rat) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(RateScale), nil)
+	scaled := money.RoundHalfEven(new(big.Rat).Mul(r, new(big.Rat).SetInt(scale)))
+
+	return new(big.Rat).SetFrac(scaled, scale)
+}
+
+// Format formats a rate as a decimal string with RateScale places
+func Format(r *big.Rat) string {
+	return r.FloatString(RateScale)
+}
+
+// Price is the result of pricing a conversion
+type Price struct {
+	// Amount is converted at Rate into Converted. Fee is the spread charged
+	// on top of Amount, in the same currency.
+	Amount    money.Money
+	Fee       money.Money
+	Converted money.Money
+	Rate      *big.Rat
+}
+
+// Quote prices converting amount into currency to. The provider's mid-market
+// rate is applied to the whole amount and spread, a fraction such as 0.005,
+// is charged on top as a fee.
+func Quote(p RateProvider, amount money.Money, to string, spread *big.Rat) (*Price, error) {
+	if !amount.IsPositive() {
+		return nil, money.ErrInvalidAmount
+	}
+
+	if amount.Currency() == to {
+		return nil, fmt.Errorf("%w: cannot convert %s into itself", ErrRateNotFound, to)
+	}
+
+	rate, err := p.Rate(amount.Currency(), to)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := amount.Convert(rate, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if !converted.IsPositive() {
+		return nil, fmt.Errorf("%w: %s %s is too small to convert", money.ErrInvalidAmount, amount, amount.Currency())
+	}
+
+	fee := money.Zero(amount.Currency())
+	if spread != nil {
+		if fee, err = amount.Mul(spread); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Price{Amount: amount, Fee: fee, Converted: converted, Rate: rate}, nil
+}
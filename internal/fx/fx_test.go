@@ -0,0 +1,65 @@
+package fx
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRateFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write rate file: %v", err)
+	}
+
+	return path
+}
+
+func TestFileProviderRates(t *testing.T) {
+	p, err := LoadFile(writeRateFile(t, `{"base": "USD", "rates": {"EUR": "0.92", "GBP": "0.80"}}`))
+	assert.NoError(t, err)
+
+	rate, err := p.Rate("USD", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.9200000000", Format(rate))
+
+	rate, err = p.Rate("EUR", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0869565217", Format(rate))
+
+	rate, err = p.Rate("EUR", "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.8695652174", Format(rate))
+
+	_, err = p.Rate("USD", "JPY")
+	assert.True(t, errors.Is(err, ErrRateNotFound))
+}
+
+func TestLoadFileRejectsBadRates(t *testing.T) {
+	_, err := LoadFile(writeRateFile(t, `{"base": "USD", "rates": {"EUR": "-1"}}`))
+	assert.Error(t, err)
+
+	_, err = LoadFile(writeRateFile(t, `{"base": "XYZ", "rates": {}}`))
+	assert.True(t, errors.Is(err, money.ErrUnknownCurrency))
+}
+
+func TestQuoteChargesSpreadAsFee(t *testing.T) {
+	p, _ := LoadFile(writeRateFile(t, `{"base": "USD", "rates": {"EUR": "0.92"}}`))
+	amount, _ := money.Parse("100", "USD")
+
+	price, err := Quote(p, amount, "EUR", big.NewRat(5, 1000))
+	assert.NoError(t, err)
+	assert.Equal(t, "100.00", price.Amount.String())
+	assert.Equal(t, "0.50", price.Fee.String())
+	assert.Equal(t, "USD", price.Fee.Currency())
+	assert.Equal(t, "92.00", price.Converted.String())
+	assert.Equal(t, "EUR", price.Converted.Currency())
+
+	_, err = Quote(p, amount, "USD", nil)
+	assert.Error(t, err)
+}
@@ -2,21 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
 	"github.com/masudcsesust04/ewallet-api/internal/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// LoginRequest represents the login request payload
+// LoginRequest represents the login request payload. Device is an optional
+// label for the session the login opens.
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
+}
+
+func (req *LoginRequest) validate(v *validator) {
+	v.required("email", req.Email)
+	v.required("password", req.Password)
+	v.maxLength("device", req.Device, 100)
 }
 
 // LoginResponse represents the login response payload
@@ -25,138 +36,278 @@ type LoginResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET")) // Replace with your secret key
+var (
+	errUnauthenticated = errors.New("unauthenticated")
+	errForbidden       = errors.New("forbidden")
+)
+
+// callerID returns the user ID JWTMiddleware placed in the request context. A
+// non-zero requestedID, such as a user_id sent by the client, must be the
+// caller's own; otherwise errForbidden is returned.
+func callerID(r *http.Request, requestedID int64) (int64, error) {
+	userID, ok := utils.UserIDFromContext(r.Context())
+	if !ok {
+		return 0, errUnauthenticated
+	}
+
+	if requestedID != 0 && requestedID != userID {
+		return 0, errForbidden
+	}
 
-// Claims represents the JWT claims
-type Claims struct {
-	UserID int `json:"user_id"`
-	jwt.RegisteredClaims
+	return userID, nil
 }
 
-// Login handles POST /login
+// authorizeUser allows a request on the resources of userID when the caller
+// is userID or the caller's role grants perm
+func authorizeUser(r *http.Request, userID int64, perm utils.Permission) error {
+	caller, err := callerID(r, 0)
+	if err != nil {
+		return err
+	}
+
+	if caller != userID && !utils.HasPermission(utils.RoleFromContext(r.Context()), perm) {
+		return errForbidden
+	}
+
+	return nil
+}
+
+// accessStatus maps a callerID or authorizeUser error to its HTTP status
+func accessStatus(err error) int {
+	if errors.Is(err, errForbidden) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusUnauthorized
+}
+
+// RefreshTokenTTL is how long a refresh token stays valid. Every refresh
+// replaces it, so an active session never reaches it.
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+// newRefreshToken returns a random refresh token for the client and the
+// record holding its hash
+func newRefreshToken() (string, *db.RefreshToken, error) {
+	raw, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return raw, &db.RefreshToken{Token: utils.HashToken(raw), ExpiresAt: time.Now().Add(RefreshTokenTTL)}, nil
+}
+
+// clientIP returns the address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Login handles POST /login. Each login opens a new session for the device;
+// the optional device field labels it in GET /sessions.
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+	var req LoginRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	email, ip := loginKey(req.Email), truncate(clientIP(r), 45)
+
+	wait, err := h.reserveLogin(email, ip)
+	if err != nil {
+		respondFailure(w, err, "Failed to check login attempts")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondProblem(w, http.StatusTooManyRequests, problem.CodeLoginThrottled, "Too many failed login attempts, try again later")
 		return
 	}
 
+	// unknown emails and wrong passwords look the same to the client
 	user, err := h.DB.GetUserByEmail(req.Email)
-	if err != nil || user == nil {
-		fmt.Println(err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	if errors.Is(err, db.ErrUserNotFound) || (err == nil && user == nil) {
+		compareDummyPassword(req.Password)
+		h.failLogin(w)
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to log in")
 		return
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		h.failLogin(w)
 		return
 	}
 
-	accessToken, err := utils.GenerateAccessToken(user.ID)
-	if err != nil {
-		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+	// JWTMiddleware rejects tokens of users that are not active, so none
+	// are issued to them; the answer does not tell their password was right
+	if user.Status != "active" {
+		h.failLogin(w)
+		return
+	}
+
+	// the address keeps the count of earlier failures, so an attacker can
+	// not reset it by logging in to an account of their own
+	if err := h.DB.ClearLoginFailures(db.LoginSubjectAccount, email); err != nil {
+		log.Printf("failed to clear login failures: %v", err)
+	}
+	if err := h.DB.ReleaseLoginAttempt(db.LoginSubjectIP, ip); err != nil {
+		log.Printf("failed to release login attempt: %v", err)
+	}
+
+	session := &db.Session{
+		UserID:      user.ID,
+		DeviceLabel: req.Device,
+		IPAddress:   ip,
+		UserAgent:   truncate(r.UserAgent(), 255),
+	}
+
+	// with two-factor authentication on, the session is opened by
+	// POST /login/mfa once the code checks out
+	if user.TOTPEnabled {
+		h.startMFAChallenge(w, session)
 		return
 	}
 
-	rawSecureToken, err := utils.GenerateSecureToken(32)
+	h.startSession(w, user, session)
+}
+
+// startSession opens session for user and responds with its tokens
+func (h *UserHandler) startSession(w http.ResponseWriter, user *db.User, session *db.Session) {
+	rawRefreshToken, refreshToken, err := newRefreshToken()
 	if err != nil {
-		http.Error(w, "Secure token generation error", http.StatusInternalServerError)
+		respondError(w, http.StatusInternalServerError, "Secure token generation error")
 		return
 	}
 
-	hashSecureToken, _ := utils.HashToken(rawSecureToken)
-	refreshToken := &db.RefreshToken{
-		UserID:    user.ID,
-		Token:     hashSecureToken,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
-		CreatedAt: time.Now(),
+	err = h.DB.CreateSession(session, refreshToken)
+	if err != nil {
+		respondFailure(w, err, "Failed to create session")
+		return
 	}
 
-	err = h.DB.CreateRefreshToken(refreshToken)
+	accessToken, err := utils.GenerateAccessToken(user.ID, session.ID, user.Role)
 	if err != nil {
-		fmt.Printf("Error creating refresh token: %v\n", err)
-		http.Error(w, "Failed to create refresh token", http.StatusInternalServerError)
+		respondError(w, http.StatusInternalServerError, "Failed to generate access token")
 		return
 	}
 
-	resp := struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-	}{
+	json.NewEncoder(w).Encode(LoginResponse{
 		AccessToken:  accessToken,
-		RefreshToken: rawSecureToken,
-	}
+		RefreshToken: rawRefreshToken,
+	})
+}
 
-	json.NewEncoder(w).Encode(resp)
+// LogoutRequest names the session to end by its refresh token. Both fields
+// are optional.
+type LogoutRequest struct {
+	UserID       int64  `json:"user_id"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-// Logout handles POST /logout
-func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	// For logout, typically the client deletes the tokens.
-	// Here, we expect the user_id in the request body to delete refresh token from DB.
+func (req *LogoutRequest) validate(v *validator) {}
 
-	type LogoutRequest struct {
-		UserID int64 `json:"user_id"`
+// Logout handles POST /logout. It revokes the session the given refresh
+// token belongs to, or every session of the caller when none is given. A
+// user_id in the request body is optional and must be the caller's own.
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req LogoutRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
 	}
 
-	var req LogoutRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	userID, err := callerID(r, req.UserID)
+	if err != nil {
+		respondError(w, accessStatus(err), "Not allowed to log out this user")
 		return
 	}
 
-	err := h.DB.DeleteRefreshToken(req.UserID)
+	if req.RefreshToken == "" {
+		err = h.DB.RevokeUserSessions(userID)
+	} else {
+		err = h.DB.RevokeSessionByRefreshToken(userID, utils.HashToken(req.RefreshToken))
+		if errors.Is(err, db.ErrSessionNotFound) {
+			// the session is already gone, which is what the client wants
+			err = nil
+		}
+	}
+
 	if err != nil {
-		http.Error(w, "Failed to logout: "+err.Error(), http.StatusInternalServerError)
+		respondFailure(w, err, "Failed to logout")
 		return
 	}
 
+	utils.ForgetTokenState(userID)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 type RefreshRequest struct {
-	UserID       int64  `json:"user_id"`
 	RefreshToken string `json:"refresh_token"`
 }
 
+func (req *RefreshRequest) validate(v *validator) {
+	v.required("refresh_token", req.RefreshToken)
+}
+
+// RefreshToken handles POST /token/refresh. The refresh token is single-use:
+// the response carries its replacement, and presenting a token twice revokes
+// the session it belongs to.
 func (h *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req RefreshRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
-	refreshToken, err := h.DB.GetRefreshToken(req.UserID)
+	rawRefreshToken, next, err := newRefreshToken()
 	if err != nil {
-		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		respondError(w, http.StatusInternalServerError, "Secure token generation error")
 		return
 	}
 
-	err = utils.ValidateRefreshToken(refreshToken)
-	if err != nil {
-		http.Error(w, "Expired refresh token", http.StatusUnauthorized)
+	session, err := h.DB.RotateRefreshToken(utils.HashToken(req.RefreshToken), next)
+	switch {
+	case errors.Is(err, db.ErrRefreshTokenReused):
+		respondProblem(w, http.StatusUnauthorized, problem.CodeRefreshTokenReused, "Refresh token reused, session revoked")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to refresh token")
 		return
 	}
 
-	if utils.CompareToken(refreshToken.Token, req.RefreshToken) != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// the user is read again so role and status changes apply from the
+	// next refresh
+	user, err := h.DB.GetUserByID(session.UserID)
+	if err != nil || user == nil || user.Status != "active" {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	accessToken, err := utils.GenerateAccessToken(req.UserID)
+	accessToken, err := utils.GenerateAccessToken(user.ID, session.ID, user.Role)
 	if err != nil {
-		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		respondError(w, http.StatusInternalServerError, "Failed to generate access token")
 		return
 	}
 
-	// Optionally rotate refresh token here
-	json.NewEncoder(w).Encode(map[string]string{
-		"access_token": accessToken,
+	json.NewEncoder(w).Encode(LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: rawRefreshToken,
 	})
 }
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+
+	return s
+}
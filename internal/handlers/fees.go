@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/fees"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+type FeeDBInterface interface {
+	GetFeeRules() ([]*db.FeeRule, error)
+	GetFeeRuleByID(id int64) (*db.FeeRule, error)
+	CreateFeeRule(rule *db.FeeRule) error
+	UpdateFeeRule(rule *db.FeeRule) error
+	DeleteFeeRule(id int64) error
+}
+
+type FeeHandler struct {
+	DB FeeDBInterface
+}
+
+func NewFeeHandler(db *db.DB) *FeeHandler {
+	return &FeeHandler{DB: db}
+}
+
+// FeeRuleRequest creates or replaces a fee rule. Amounts are in Currency;
+// max_amount and max_fee may be omitted for no upper bound. Percent is a
+// percentage of the amount, e.g. "1.5". Rules are active unless active is false.
+type FeeRuleRequest struct {
+	TxType    string      `json:"tx_type"`
+	Currency  string      `json:"currency"`
+	MinAmount json.Number `json:"min_amount"`
+	MaxAmount json.Number `json:"max_amount"`
+	FlatFee   json.Number `json:"flat_fee"`
+	Percent   json.Number `json:"percent"`
+	MinFee    json.Number `json:"min_fee"`
+	MaxFee    json.Number `json:"max_fee"`
+	Active    *bool       `json:"active"`
+}
+
+func (req *FeeRuleRequest) validate(v *validator) {
+	v.check(req.TxType == db.FeeTypeTransfer || req.TxType == db.FeeTypeWithdrawal, "tx_type", "must be transfer or withdrawal")
+	if req.Percent != "" {
+		v.check(decimalPattern.MatchString(req.Percent.String()), "percent", "must be a decimal percentage")
+	}
+
+	if !v.required("currency", req.Currency) {
+		return
+	}
+	if !validCurrency(req.Currency) {
+		v.add("currency", "is not a supported currency")
+		return
+	}
+
+	for _, field := range []struct {
+		name  string
+		value json.Number
+	}{
+		{"min_amount", req.MinAmount},
+		{"max_amount", req.MaxAmount},
+		{"flat_fee", req.FlatFee},
+		{"min_fee", req.MinFee},
+		{"max_fee", req.MaxFee},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if m, err := money.Parse(field.value.String(), req.Currency); err != nil || m.IsNegative() {
+			v.add(field.name, "must be a non-negative amount in "+req.Currency)
+		}
+	}
+}
+
+// rule converts the request into a fee rule
+func (req *FeeRuleRequest) rule() (*db.FeeRule, error) {
+	rule := &db.FeeRule{TxType: req.TxType, Currency: req.Currency, Active: req.Active == nil || *req.Active}
+	rule.Percent = req.Percent.String()
+
+	var err error
+	for _, field := range []struct {
+		value json.Number
+		dest  *money.Money
+	}{
+		{req.MinAmount, &rule.MinAmount},
+		{req.FlatFee, &rule.FlatFee},
+		{req.MinFee, &rule.MinFee},
+	} {
+		if *field.dest, err = optionalAmount(field.value, req.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.MaxAmount != "" {
+		maxAmount, err := money.Parse(req.MaxAmount.String(), req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		rule.MaxAmount = &maxAmount
+	}
+
+	if req.MaxFee != "" {
+		maxFee, err := money.Parse(req.MaxFee.String(), req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		rule.MaxFee = &maxFee
+	}
+
+	return rule, nil
+}
+
+// optionalAmount parses value in currency, treating an empty value as zero
+func optionalAmount(value json.Number, currency string) (money.Money, error) {
+	if value == "" {
+		return money.New(0, currency)
+	}
+
+	return money.Parse(value.String(), currency)
+}
+
+// RegisterFeeRoutes registers the fee rule admin routes, which require the
+// fees:manage permission
+func RegisterFeeRoutes(r *mux.Router, db *db.DB) {
+	handler := NewFeeHandler(db)
+	r.HandleFunc("/admin/fee-rules", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageFees, handler.GetFeeRules))).Methods("GET")
+	r.HandleFunc("/admin/fee-rules", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageFees, handler.CreateFeeRule))).Methods("POST")
+	r.HandleFunc("/admin/fee-rules/{id}", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageFees, handler.GetFeeRule))).Methods("GET")
+	r.HandleFunc("/admin/fee-rules/{id}", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageFees, handler.UpdateFeeRule))).Methods("PUT")
+	r.HandleFunc("/admin/fee-rules/{id}", utils.JWTMiddleware(utils.RequirePermission(utils.PermManageFees, handler.DeleteFeeRule))).Methods("DELETE")
+}
+
+// respondFeeRuleError maps fee rule errors to responses. A rule the client
+// got wrong is answered with what is wrong with it.
+func respondFeeRuleError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, fees.ErrInvalidRule):
+		respondProblem(w, http.StatusBadRequest, problem.CodeInvalidFeeRule, err.Error())
+	case errors.Is(err, money.ErrUnknownCurrency), errors.Is(err, money.ErrInvalidAmount), errors.Is(err, money.ErrOverflow):
+		respondProblem(w, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+	default:
+		respondFailure(w, err, message)
+	}
+}
+
+func feeRuleID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+}
+
+// GetFeeRules handles GET /admin/fee-rules
+func (h *FeeHandler) GetFeeRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.DB.GetFeeRules()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get fee rules")
+		return
+	}
+
+	if rules == nil {
+		rules = []*db.FeeRule{}
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// GetFeeRule handles GET /admin/fee-rules/{id}
+func (h *FeeHandler) GetFeeRule(w http.ResponseWriter, r *http.Request) {
+	id, err := feeRuleID(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid fee rule id")
+		return
+	}
+
+	rule, err := h.DB.GetFeeRuleByID(id)
+	if err != nil {
+		respondFeeRuleError(w, err, "Failed to get fee rule")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// CreateFeeRule handles POST /admin/fee-rules
+func (h *FeeHandler) CreateFeeRule(w http.ResponseWriter, r *http.Request) {
+	var req FeeRuleRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	rule, err := req.rule()
+	if err != nil {
+		respondFeeRuleError(w, err, "Invalid fee rule")
+		return
+	}
+
+	if err := h.DB.CreateFeeRule(rule); err != nil {
+		respondFeeRuleError(w, err, "Failed to create fee rule")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateFeeRule handles PUT /admin/fee-rules/{id}
+func (h *FeeHandler) UpdateFeeRule(w http.ResponseWriter, r *http.Request) {
+	id, err := feeRuleID(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid fee rule id")
+		return
+	}
+
+	var req FeeRuleRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	rule, err := req.rule()
+	if err != nil {
+		respondFeeRuleError(w, err, "Invalid fee rule")
+		return
+	}
+
+	rule.ID = id
+	if err := h.DB.UpdateFeeRule(rule); err != nil {
+		respondFeeRuleError(w, err, "Failed to update fee rule")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// DeleteFeeRule handles DELETE /admin/fee-rules/{id}
+func (h *FeeHandler) DeleteFeeRule(w http.ResponseWriter, r *http.Request) {
+	id, err := feeRuleID(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid fee rule id")
+		return
+	}
+
+	if err := h.DB.DeleteFeeRule(id); err != nil {
+		respondFeeRuleError(w, err, "Failed to delete fee rule")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFeeDB struct {
+	rules map[int64]*db.FeeRule
+}
+
+func (m *mockFeeDB) GetFeeRules() ([]*db.FeeRule, error) {
+	var rules []*db.FeeRule
+	for _, rule := range m.rules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (m *mockFeeDB) GetFeeRuleByID(id int64) (*db.FeeRule, error) {
+	rule, ok := m.rules[id]
+	if !ok {
+		return nil, db.ErrFeeRuleNotFound
+	}
+	return rule, nil
+}
+
+func (m *mockFeeDB) CreateFeeRule(rule *db.FeeRule) error {
+	rule.ID = int64(len(m.rules) + 1)
+	m.rules[rule.ID] = rule
+	return nil
+}
+
+func (m *mockFeeDB) UpdateFeeRule(rule *db.FeeRule) error {
+	if _, ok := m.rules[rule.ID]; !ok {
+		return db.ErrFeeRuleNotFound
+	}
+	m.rules[rule.ID] = rule
+	return nil
+}
+
+func (m *mockFeeDB) DeleteFeeRule(id int64) error {
+	if _, ok := m.rules[id]; !ok {
+		return db.ErrFeeRuleNotFound
+	}
+	delete(m.rules, id)
+	return nil
+}
+
+func setupFeeRouter(mockDB FeeDBInterface) *mux.Router {
+	r := mux.NewRouter()
+	handler := &FeeHandler{DB: mockDB}
+	r.HandleFunc("/admin/fee-rules", handler.GetFeeRules).Methods("GET")
+	r.HandleFunc("/admin/fee-rules", handler.CreateFeeRule).Methods("POST")
+	r.HandleFunc("/admin/fee-rules/{id}", handler.UpdateFeeRule).Methods("PUT")
+	r.HandleFunc("/admin/fee-rules/{id}", handler.DeleteFeeRule).Methods("DELETE")
+	return r
+}
+
+func TestCreateFeeRule(t *testing.T) {
+	mockDB := &mockFeeDB{rules: make(map[int64]*db.FeeRule)}
+	r := setupFeeRouter(mockDB)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"tx_type":    "transfer",
+		"currency":   "USD",
+		"min_amount": "0",
+		"max_amount": "1000",
+		"flat_fee":   "0.30",
+		"percent":    "1.5",
+		"max_fee":    "5",
+	})
+	req := httptest.NewRequest("POST", "/admin/fee-rules", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var rule map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+	assert.Equal(t, "0.30", rule["flat_fee"])
+	assert.Equal(t, "1000.00", rule["max_amount"])
+	assert.Equal(t, "0.00", rule["min_fee"])
+	assert.Equal(t, true, rule["active"])
+}
+
+func TestCreateFeeRuleRejectsBadAmount(t *testing.T) {
+	r := setupFeeRouter(&mockFeeDB{rules: make(map[int64]*db.FeeRule)})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"tx_type":  "withdrawal",
+		"currency": "USD",
+		"flat_fee": "0.305",
+	})
+	req := httptest.NewRequest("POST", "/admin/fee-rules", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestDeleteFeeRuleNotFound(t *testing.T) {
+	r := setupFeeRouter(&mockFeeDB{rules: make(map[int64]*db.FeeRule)})
+
+	req := httptest.NewRequest("DELETE", "/admin/fee-rules/42", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// DefaultQuoteTTL is how long a currency conversion quote can be used
+const DefaultQuoteTTL = 30 * time.Second
+
+// QuoteRequest asks the price of converting Amount of FromCurrency into ToCurrency
+type QuoteRequest struct {
+	FromCurrency string      `json:"from_currency"`
+	ToCurrency   string      `json:"to_currency"`
+	Amount       json.Number `json:"amount"`
+}
+
+func (req *QuoteRequest) validate(v *validator) {
+	for _, field := range []struct{ name, value string }{
+		{"from_currency", req.FromCurrency},
+		{"to_currency", req.ToCurrency},
+	} {
+		if v.required(field.name, field.value) {
+			validateCurrency(v, field.name, field.value)
+		}
+	}
+	v.check(req.FromCurrency != req.ToCurrency, "to_currency", "must differ from from_currency")
+	validateAmount(v, "amount", req.Amount)
+}
+
+// CreateQuote locks an exchange rate for the caller. The returned quote ID is
+// passed as quote_id to /wallets/transfer before the quote expires.
+func (h *WalletHandler) CreateQuote(w http.ResponseWriter, r *http.Request) {
+	if h.Rates == nil {
+		respondError(w, http.StatusServiceUnavailable, "Currency conversion is not available")
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	var req QuoteRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	amount, err := money.Parse(req.Amount.String(), req.FromCurrency)
+	if err != nil {
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+req.FromCurrency))
+		return
+	}
+
+	price, err := fx.Quote(h.Rates, amount, req.ToCurrency, h.FXSpread)
+	if err != nil {
+		if errors.Is(err, fx.ErrRateNotFound) {
+			respondProblem(w, http.StatusUnprocessableEntity, problem.CodeRateNotFound, "No exchange rate for this currency pair")
+		} else {
+			respondError(w, http.StatusBadRequest, "Amount cannot be converted")
+		}
+		return
+	}
+
+	id, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create quote")
+		return
+	}
+
+	ttl := h.QuoteTTL
+	if ttl <= 0 {
+		ttl = DefaultQuoteTTL
+	}
+
+	quote := &db.FXQuote{
+		ID:              id,
+		UserID:          userID,
+		FromCurrency:    req.FromCurrency,
+		ToCurrency:      req.ToCurrency,
+		Rate:            fx.Format(price.Rate),
+		Amount:          price.Amount,
+		Fee:             price.Fee,
+		ConvertedAmount: price.Converted,
+		ExpiresAt:       time.Now().Add(ttl),
+	}
+
+	if err := h.DB.CreateFXQuote(quote); err != nil {
+		respondFailure(w, err, "Failed to create quote")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, quote)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRates map[string]*big.Rat
+
+func (m mockRates) Rate(from, to string) (*big.Rat, error) {
+	rate, ok := m[from+to]
+	if !ok {
+		return nil, fx.ErrRateNotFound
+	}
+	return rate, nil
+}
+
+func postAsUser(handler http.HandlerFunc, path string, userID int64, payload interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+	req = req.WithContext(utils.ContextWithUserID(req.Context(), userID))
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// postToID posts to handler, which serves a route with an {id} path
+// variable, as userID
+func postToID(handler http.HandlerFunc, id string, userID int64, payload interface{}) *httptest.ResponseRecorder {
+	return postAsUser(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	}, "/"+id, userID, payload)
+}
+
+func TestTransferWithQuote(t *testing.T) {
+	mockDB := NewMockDB()
+	usdWallet, _ := mockDB.CreateWallet(1, "USD")
+	eurWallet, _ := mockDB.CreateWallet(1, "EUR")
+	mockDB.UpdateWalletBalance(usdWallet.ID, usd(t, "200"))
+	handler := &WalletHandler{
+		DB:       mockDB,
+		Rates:    mockRates{"USDEUR": big.NewRat(92, 100)},
+		QuoteTTL: time.Minute,
+		FXSpread: big.NewRat(5, 1000),
+	}
+
+	w := postAsUser(handler.CreateQuote, "/fx/quotes", 1, map[string]interface{}{
+		"from_currency": "USD",
+		"to_currency":   "EUR",
+		"amount":        "100",
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var quote map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &quote))
+	assert.Equal(t, "0.9200000000", quote["rate"])
+	assert.Equal(t, "0.50", quote["fee"])
+	assert.Equal(t, "92.00", quote["converted_amount"])
+
+	transfer := map[string]interface{}{
+		"from_wallet_id": usdWallet.ID,
+		"to_wallet_id":   eurWallet.ID,
+		"quote_id":       quote["id"],
+	}
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, transfer)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "99.50", usdWallet.Balance.String())
+	assert.Equal(t, "92.00", eurWallet.Balance.String())
+
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, transfer)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "99.50", usdWallet.Balance.String())
+}
+
+func TestCreateQuoteUnknownPair(t *testing.T) {
+	handler := &WalletHandler{DB: NewMockDB(), Rates: mockRates{}}
+
+	w := postAsUser(handler.CreateQuote, "/fx/quotes", 1, map[string]interface{}{
+		"from_currency": "USD",
+		"to_currency":   "JPY",
+		"amount":        "100",
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// DefaultHoldTTL is used when WalletHandler.HoldTTL is not set
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+// HoldRequest reserves an amount of the caller's wallet for a payee wallet,
+// like a card authorization. The payee captures or voids it.
+type HoldRequest struct {
+	FromWalletID int64       `json:"from_wallet_id"`
+	ToWalletID   int64       `json:"to_wallet_id"`
+	Amount       json.Number `json:"amount"`
+	Note         string      `json:"note"`
+	TOTPCode     string      `json:"totp_code"`
+}
+
+func (req *HoldRequest) validate(v *validator) {
+	v.check(req.FromWalletID > 0, "from_wallet_id", "is required")
+	v.check(req.ToWalletID > 0, "to_wallet_id", "is required")
+	v.check(req.FromWalletID != req.ToWalletID, "to_wallet_id", "must differ from from_wallet_id")
+	validateAmount(v, "amount", req.Amount)
+	v.maxLength("note", req.Note, 256)
+}
+
+// CaptureRequest captures a hold. Without an amount the whole hold is captured.
+type CaptureRequest struct {
+	Amount json.Number `json:"amount"`
+}
+
+func (req *CaptureRequest) validate(v *validator) {
+	if req.Amount != "" {
+		validateAmount(v, "amount", req.Amount)
+	}
+}
+
+// PlaceHold handles POST /wallets/holds. The held amount is taken off the
+// available balance of the caller's wallet until the hold is captured,
+// voided or expires.
+func (h *WalletHandler) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	var req HoldRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	if !h.requireVerified(w, userID) {
+		return
+	}
+
+	fromWallet, err := h.DB.GetWalletByID(req.FromWalletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if fromWallet.UserID != userID {
+		respondError(w, http.StatusForbidden, "Wallet does not belong to you")
+		return
+	}
+
+	toWallet, err := h.DB.GetWalletByID(req.ToWalletID)
+	if errors.Is(err, db.ErrWalletNotFound) {
+		respondProblem(w, http.StatusNotFound, problem.CodeWalletNotFound, "Payee wallet not found")
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if fromWallet.Currency != toWallet.Currency {
+		respondProblem(w, http.StatusBadRequest, problem.CodeCurrencyMismatch, "Currency mismatch between wallets")
+		return
+	}
+
+	amount, err := money.Parse(req.Amount.String(), fromWallet.Currency)
+	if err != nil {
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+fromWallet.Currency))
+		return
+	}
+
+	if !h.confirmTOTP(w, userID, amount, fromWallet.Currency, req.TOTPCode) {
+		return
+	}
+
+	ttl := h.HoldTTL
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	hold := &db.Hold{WalletID: req.FromWalletID, PayeeWalletID: req.ToWalletID, Amount: amount, Note: req.Note, ExpiresAt: time.Now().Add(ttl)}
+	if err := h.DB.PlaceHold(hold); err != nil {
+		respondFailure(w, err, "Failed to place hold")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, hold)
+}
+
+// Holds handles GET /wallets/holds. It returns the holds placed by or for
+// wallet_id, newest first.
+func (h *WalletHandler) Holds(w http.ResponseWriter, r *http.Request) {
+	walletID, err := strconv.ParseInt(r.URL.Query().Get("wallet_id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid wallet_id query parameter")
+		return
+	}
+
+	wallet, err := h.DB.GetWalletByID(walletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if err := authorizeUser(r, wallet.UserID, utils.PermReadWallets); err != nil {
+		respondError(w, accessStatus(err), "Wallet does not belong to you")
+		return
+	}
+
+	holds, err := h.DB.GetHoldsByWalletID(walletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get holds")
+		return
+	}
+
+	if holds == nil {
+		holds = []*db.Hold{}
+	}
+
+	respondJSON(w, http.StatusOK, holds)
+}
+
+// payeeHold returns the hold named in the path when the caller owns its
+// payee wallet. Only the payee settles a hold; the payer waits for it to be
+// captured, voided or to expire.
+func (h *WalletHandler) payeeHold(w http.ResponseWriter, r *http.Request) (*db.Hold, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid hold ID")
+		return nil, false
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return nil, false
+	}
+
+	hold, err := h.DB.GetHold(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get hold")
+		return nil, false
+	}
+
+	payee, err := h.DB.GetWalletByID(hold.PayeeWalletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return nil, false
+	}
+
+	if payee.UserID != userID {
+		respondError(w, http.StatusForbidden, "Hold is not payable to you")
+		return nil, false
+	}
+
+	return hold, true
+}
+
+// CaptureHold handles POST /wallets/holds/{id}/capture. The payee captures
+// the whole hold or, with an amount, part of it; the rest is released.
+func (h *WalletHandler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	var req CaptureRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	hold, ok := h.payeeHold(w, r)
+	if !ok {
+		return
+	}
+
+	var amount money.Money
+	if req.Amount != "" {
+		var err error
+		amount, err = money.Parse(req.Amount.String(), hold.Currency)
+		if err != nil {
+			respondInvalid(w, invalidField("amount", "is not a valid amount in "+hold.Currency))
+			return
+		}
+	}
+
+	hold, err := h.DB.CaptureHold(hold.ID, amount)
+	if err != nil {
+		respondFailure(w, err, "Failed to capture hold")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hold)
+}
+
+// VoidHold handles POST /wallets/holds/{id}/void. The payee releases the
+// hold without taking any money.
+func (h *WalletHandler) VoidHold(w http.ResponseWriter, r *http.Request) {
+	hold, ok := h.payeeHold(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := h.DB.VoidHold(hold.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to void hold")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hold)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldPlaceCaptureAndVoid(t *testing.T) {
+	mockDB := NewMockDB()
+	payer, _ := mockDB.CreateWallet(1, "USD")
+	payee, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(payer.ID, usd(t, "100"))
+	handler := &WalletHandler{DB: mockDB, HoldTTL: time.Hour}
+
+	place := map[string]interface{}{"from_wallet_id": payer.ID, "to_wallet_id": payee.ID, "amount": "60", "note": "hotel"}
+	w := postAsUser(handler.PlaceHold, "/wallets/holds", 1, place)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var hold map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &hold))
+	assert.Equal(t, db.HoldActive, hold["status"])
+	assert.Equal(t, "60.00", hold["amount"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), mockDB.Holds[1].ExpiresAt, time.Minute)
+	assert.Equal(t, "100.00", payer.Balance.String())
+
+	// the rest of the available balance does not cover a second hold
+	w = postAsUser(handler.PlaceHold, "/wallets/holds", 1, place)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeInsufficientFunds, problemCode(w))
+
+	// only the payee settles a hold
+	w = postToID(handler.CaptureHold, "1", 1, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postToID(handler.CaptureHold, "1", 2, map[string]string{"amount": "70"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeCaptureExceedsHold, problemCode(w))
+
+	w = postToID(handler.CaptureHold, "1", 2, map[string]string{"amount": "45"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "55.00", payer.Balance.String())
+	assert.Equal(t, "45.00", payee.Balance.String())
+
+	w = postToID(handler.VoidHold, "1", 2, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problem.CodeHoldNotActive, problemCode(w))
+
+	place["amount"] = "10"
+	w = postAsUser(handler.PlaceHold, "/wallets/holds", 1, place)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = postToID(handler.VoidHold, "2", 2, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "55.00", payer.Balance.String())
+
+	w = postToID(handler.VoidHold, "3", 2, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeHoldNotFound, problemCode(w))
+
+	req := withUser(httptest.NewRequest("GET", "/wallets/holds?wallet_id=1", nil), 1)
+	w = httptest.NewRecorder()
+	handler.Holds(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var holds []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &holds))
+	if assert.Len(t, holds, 2) {
+		assert.Equal(t, db.HoldVoided, holds[0]["status"])
+		assert.Equal(t, "45.00", holds[1]["captured_amount"])
+	}
+
+	req = withUser(httptest.NewRequest("GET", "/wallets/holds?wallet_id=1", nil), 3)
+	w = httptest.NewRecorder()
+	handler.Holds(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
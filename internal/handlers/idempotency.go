@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make retries safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is used when no replay window is configured
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(userID int64, key, fingerprint string, ttl time.Duration) (*db.IdempotencyKey, bool, error)
+	CompleteIdempotencyKey(userID int64, key string, status int, body []byte) error
+	ReleaseIdempotencyKey(userID int64, key string) error
+}
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of the status and body for the idempotency store.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a money-moving handler safe to retry. The first response
+// for an Idempotency-Key is stored together with a fingerprint of the request
+// and replayed for later requests with the same key; reusing the key with a
+// different request is rejected with 422. Requests without the header, or
+// without an authenticated user, are passed through unchanged.
+func (h *WalletHandler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		userID, ok := utils.UserIDFromContext(r.Context())
+		if key == "" || !ok || h.Idempotency == nil {
+			next(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			respondError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ttl := h.IdempotencyTTL
+		if ttl <= 0 {
+			ttl = DefaultIdempotencyTTL
+		}
+
+		fingerprint := requestFingerprint(r, body)
+		record, reserved, err := h.Idempotency.ReserveIdempotencyKey(userID, key, fingerprint, ttl)
+		if err != nil {
+			respondFailure(w, err, "Failed to check idempotency key")
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.Fingerprint != fingerprint:
+				respondProblem(w, http.StatusUnprocessableEntity, problem.CodeIdempotencyKey, "Idempotency-Key was already used with a different request")
+			case record.ResponseStatus == 0:
+				respondProblem(w, http.StatusConflict, problem.CodeIdempotencyKey, "A request with this Idempotency-Key is still being processed")
+			default:
+				// every error response is a problem
+				contentType := "application/json"
+				if record.ResponseStatus >= http.StatusBadRequest {
+					contentType = problem.ContentType
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.ResponseStatus)
+				w.Write(record.ResponseBody)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Server errors are not stored so that the client can retry with the same key.
+		if rec.status >= http.StatusInternalServerError {
+			err = h.Idempotency.ReleaseIdempotencyKey(userID, key)
+		} else {
+			err = h.Idempotency.CompleteIdempotencyKey(userID, key, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("failed to store idempotency key %q for user %d: %v", key, userID, err)
+		}
+	}
+}
+
+// requestFingerprint identifies a request by method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	io.WriteString(sum, r.Method+" "+r.URL.Path+"\n")
+	sum.Write(body)
+
+	return hex.EncodeToString(sum.Sum(nil))
+}
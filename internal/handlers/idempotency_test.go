@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockIdempotencyStore struct {
+	keys map[string]*db.IdempotencyKey
+}
+
+func newMockIdempotencyStore() *mockIdempotencyStore {
+	return &mockIdempotencyStore{keys: make(map[string]*db.IdempotencyKey)}
+}
+
+func (m *mockIdempotencyStore) ReserveIdempotencyKey(userID int64, key, fingerprint string, ttl time.Duration) (*db.IdempotencyKey, bool, error) {
+	if record, ok := m.keys[key]; ok && record.ExpiresAt.After(time.Now()) {
+		return record, false, nil
+	}
+
+	record := &db.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint, ExpiresAt: time.Now().Add(ttl)}
+	m.keys[key] = record
+	return record, true, nil
+}
+
+func (m *mockIdempotencyStore) CompleteIdempotencyKey(userID int64, key string, status int, body []byte) error {
+	m.keys[key].ResponseStatus = status
+	m.keys[key].ResponseBody = append([]byte(nil), body...)
+	return nil
+}
+
+func (m *mockIdempotencyStore) ReleaseIdempotencyKey(userID int64, key string) error {
+	delete(m.keys, key)
+	return nil
+}
+
+func sendIdempotentDeposit(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/wallets/deposit", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, key)
+	req = req.WithContext(utils.ContextWithUserID(req.Context(), 1))
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestIdempotentDepositReplaysResponse(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(1, "USD")
+	handler := &WalletHandler{DB: mockDB, Idempotency: newMockIdempotencyStore()}
+	deposit := handler.idempotent(handler.Deposit)
+
+	first := sendIdempotentDeposit(deposit, "key-1", `{"user_id": 1, "amount": "25.00"}`)
+	second := sendIdempotentDeposit(deposit, "key-1", `{"user_id": 1, "amount": "25.00"}`)
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	assert.Equal(t, "25.00", wallet.Balance.String())
+	assert.Len(t, mockDB.Transactions, 1)
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(1, "USD")
+	handler := &WalletHandler{DB: mockDB, Idempotency: newMockIdempotencyStore()}
+	deposit := handler.idempotent(handler.Deposit)
+
+	sendIdempotentDeposit(deposit, "key-1", `{"user_id": 1, "amount": "25.00"}`)
+	w := sendIdempotentDeposit(deposit, "key-1", `{"user_id": 1, "amount": "30.00"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	assert.Equal(t, "25.00", wallet.Balance.String())
+}
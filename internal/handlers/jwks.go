@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// JWKS handles GET /.well-known/jwks.json. It publishes the public keys
+// access tokens are verified with, so other services can check tokens
+// without sharing a secret.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := utils.CurrentKeyRing()
+	if keys == nil {
+		respondError(w, http.StatusServiceUnavailable, "No signing keys configured")
+		return
+	}
+
+	// verifiers refetch when they meet an unknown kid, so a short cache
+	// lifetime is enough to pick up rotations
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keys.JWKS())
+}
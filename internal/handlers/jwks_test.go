@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestKeyRing makes GenerateAccessToken sign with a fresh Ed25519 key
+func useTestKeyRing(t *testing.T) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	key, _ := utils.NewSigningKey("test", priv)
+	ring, err := utils.NewKeyRing("test", key)
+	if err != nil {
+		t.Fatalf("failed to create key ring: %v", err)
+	}
+
+	utils.SetKeyRing(ring)
+	t.Cleanup(func() { utils.SetKeyRing(nil) })
+}
+
+func TestJWKS(t *testing.T) {
+	useTestKeyRing(t)
+
+	w := httptest.NewRecorder()
+	JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var set utils.JWKSet
+	json.NewDecoder(w.Body).Decode(&set)
+	if assert.Len(t, set.Keys, 1) {
+		assert.Equal(t, "test", set.Keys[0].KeyID)
+		assert.Equal(t, "OKP", set.Keys[0].KeyType)
+		assert.Equal(t, "EdDSA", set.Keys[0].Alg)
+	}
+
+	// tokens issued by Login carry the kid published here
+	handler := newSessionTestHandler(t)
+	resp := login(t, handler, "user1@example.com", "")
+	_, err := utils.CurrentKeyRing().Parse(resp.AccessToken)
+	assert.NoError(t, err)
+}
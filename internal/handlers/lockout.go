@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginPolicy throttles password guessing. Failures are counted per account
+// and per IP address; each failure of an account doubles the time before it
+// may try again, from BaseDelay up to MaxDelay, and too many failures lock it
+// out. The IP counter only locks out, with a higher limit, so users behind a
+// shared address do not slow each other down.
+type LoginPolicy struct {
+	Account   db.LockoutPolicy
+	IP        db.LockoutPolicy
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultLoginPolicy is the policy NewUserHandler starts with
+var DefaultLoginPolicy = LoginPolicy{
+	Account:   db.LockoutPolicy{MaxFailures: 5, Window: 15 * time.Minute, Lockout: 15 * time.Minute},
+	IP:        db.LockoutPolicy{MaxFailures: 50, Window: 15 * time.Minute, Lockout: 15 * time.Minute},
+	BaseDelay: time.Second,
+	MaxDelay:  30 * time.Second,
+}
+
+// ResetPolicy limits password reset requests per email and per IP address.
+// Every request counts, and the zero value never limits.
+type ResetPolicy struct {
+	Email db.LockoutPolicy
+	IP    db.LockoutPolicy
+}
+
+// DefaultResetPolicy is the policy NewUserHandler starts with
+var DefaultResetPolicy = ResetPolicy{
+	Email: db.LockoutPolicy{MaxFailures: 3, Window: time.Hour, Lockout: time.Hour},
+	IP:    db.LockoutPolicy{MaxFailures: 20, Window: time.Hour, Lockout: time.Hour},
+}
+
+// delay returns how long an account waits after its failures-th failure
+func (p LoginPolicy) delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	d := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(failures-1)))
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		return p.MaxDelay
+	}
+
+	return d
+}
+
+// loginKey is the account key failed logins are counted under
+func loginKey(email string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(email)), 100)
+}
+
+// reserveLogin counts a login for email from ip as failed before its
+// password is checked, unless earlier failures make it wait. It returns how
+// long the login has to wait.
+func (h *UserHandler) reserveLogin(email, ip string) (time.Duration, error) {
+	return h.DB.ReserveLoginAttempt(
+		db.LoginCounter{Subject: db.LoginSubjectAccount, Key: email, Policy: h.LoginPolicy.Account, Delay: h.LoginPolicy.delay},
+		db.LoginCounter{Subject: db.LoginSubjectIP, Key: ip, Policy: h.LoginPolicy.IP},
+	)
+}
+
+// failLogin gives the same answer whether the email is unknown, the password
+// wrong or the account not active. The failure was counted by reserveLogin.
+func (h *UserHandler) failLogin(w http.ResponseWriter) {
+	respondProblem(w, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
+}
+
+// failureCounter is the part of the database that throttles guessing
+type failureCounter interface {
+	ReserveLoginAttempt(counters ...db.LoginCounter) (time.Duration, error)
+	ClearLoginFailures(subject, key string) error
+}
+
+// throttleGuess runs check, which verifies a secret the caller gave, against
+// the failure counter c: the guess counts as wrong before check runs, and the
+// count is cleared unless check returns wrong. When earlier wrong guesses
+// make the caller wait it answers 429 with code and reports false; otherwise
+// it returns the error of check.
+func throttleGuess(w http.ResponseWriter, counter failureCounter, c db.LoginCounter, wrong error, code, detail string, check func() error) (bool, error) {
+	wait, err := counter.ReserveLoginAttempt(c)
+	if err != nil {
+		respondFailure(w, err, "Failed to check attempts")
+		return false, nil
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondProblem(w, http.StatusTooManyRequests, code, detail)
+		return false, nil
+	}
+
+	err = check()
+	if !errors.Is(err, wrong) {
+		if err := counter.ClearLoginFailures(c.Subject, c.Key); err != nil {
+			log.Printf("failed to clear %s failures: %v", c.Subject, err)
+		}
+	}
+
+	return true, err
+}
+
+// throttleTOTP runs check, which verifies a TOTP code of userID, under the
+// account part of policy, so codes can not be guessed faster than passwords
+func throttleTOTP(w http.ResponseWriter, counter failureCounter, policy LoginPolicy, userID int64, check func() error) (bool, error) {
+	c := db.LoginCounter{Subject: db.LoginSubjectTOTP, Key: strconv.FormatInt(userID, 10), Policy: policy.Account, Delay: policy.delay}
+	return throttleGuess(w, counter, c, db.ErrInvalidTOTPCode, problem.CodeTOTPThrottled, "Too many wrong codes, try again later", check)
+}
+
+// throttleVerify runs check, which verifies a contact code of userID, under
+// the account part of policy, so sending new codes does not allow more guesses
+func throttleVerify(w http.ResponseWriter, counter failureCounter, policy LoginPolicy, userID int64, check func() error) (bool, error) {
+	c := db.LoginCounter{Subject: db.LoginSubjectVerify, Key: strconv.FormatInt(userID, 10), Policy: policy.Account, Delay: policy.delay}
+	return throttleGuess(w, counter, c, db.ErrVerificationCodeInvalid, problem.CodeVerifyThrottled, "Too many wrong codes, try again later", check)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyPassword spends the time of a password check on logins for
+// unknown emails, so response times do not tell them apart
+func compareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	})
+
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// UnlockRequest optionally names an IP address to unlock along with the user
+type UnlockRequest struct {
+	IP string `json:"ip"`
+}
+
+func (req *UnlockRequest) validate(v *validator) {
+	v.check(req.IP == "" || net.ParseIP(req.IP) != nil, "ip", "must be an IP address")
+}
+
+// UnlockUser handles POST /admin/users/{id}/unlock. It lifts a lockout of the
+// user's account, and of the address in the optional ip field.
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	var req UnlockRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	adminID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := h.DB.GetUserByID(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get user")
+		return
+	}
+
+	for _, subject := range []string{db.LoginSubjectAccount, db.LoginSubjectReset} {
+		if err := h.DB.UnlockLogin(subject, loginKey(user.Email), adminID); err != nil {
+			respondFailure(w, err, "Failed to unlock user")
+			return
+		}
+	}
+
+	for _, subject := range []string{db.LoginSubjectTOTP, db.LoginSubjectVerify} {
+		if err := h.DB.UnlockLogin(subject, strconv.FormatInt(user.ID, 10), adminID); err != nil {
+			respondFailure(w, err, "Failed to unlock user")
+			return
+		}
+	}
+
+	if req.IP != "" {
+		for _, subject := range []string{db.LoginSubjectIP, db.LoginSubjectResetIP} {
+			if err := h.DB.UnlockLogin(subject, req.IP, adminID); err != nil {
+				respondFailure(w, err, "Failed to unlock address")
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLockoutEvents handles GET /admin/lockouts, which lists recent lockouts
+// and unlocks, newest first. limit defaults to 100.
+func (h *UserHandler) GetLockoutEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.DB.GetLockoutEvents(limit)
+	if err != nil {
+		respondFailure(w, err, "Failed to get lockout events")
+		return
+	}
+
+	if events == nil {
+		events = []*db.LockoutEvent{}
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func attemptLogin(handler *UserHandler, email, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "`+email+`", "password": "`+password+`"}`))
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+	return w
+}
+
+func TestLoginGivesUniformInvalidCredentials(t *testing.T) {
+	handler := newSessionTestHandler(t)
+
+	unknown := attemptLogin(handler, "nobody@example.com", "password123")
+	wrong := attemptLogin(handler, "user1@example.com", "wrong-password")
+
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, http.StatusUnauthorized, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+	assert.Equal(t, problem.CodeInvalidCredentials, problemCode(wrong))
+}
+
+func TestLoginDelaysAndLocksOut(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	handler.LoginPolicy = LoginPolicy{
+		Account:   db.LockoutPolicy{MaxFailures: 3, Window: 24 * time.Hour, Lockout: time.Hour},
+		IP:        db.LockoutPolicy{MaxFailures: 100, Window: time.Minute, Lockout: time.Hour},
+		BaseDelay: time.Minute,
+	}
+
+	w := attemptLogin(handler, "user1@example.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the next attempt has to wait, even with the right password
+	w = attemptLogin(handler, "user1@example.com", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// other accounts from the same address are not delayed
+	w = attemptLogin(handler, "user2@example.com", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// pretend the delays passed between failures until the account locks
+	mock := handler.DB.(*mockDB)
+	account := db.LoginSubjectAccount + "/user1@example.com"
+	for i := 0; i < 2; i++ {
+		mock.loginFailures[account].LastFailureAt = time.Now().Add(-time.Hour)
+		w = attemptLogin(handler, "USER1@example.com", "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	mock.loginFailures[account].LastFailureAt = time.Now().Add(-time.Hour)
+
+	w = attemptLogin(handler, "user1@example.com", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	if assert.Len(t, mock.lockoutEvents, 1) {
+		assert.Equal(t, db.LockoutEventLocked, mock.lockoutEvents[0].Event)
+	}
+
+	// an admin lifts the lockout
+	req := mux.SetURLVars(withRole(httptest.NewRequest("POST", "/admin/users/1/unlock", nil), 2, utils.RoleAdmin), map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	handler.UnlockUser(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = attemptLogin(handler, "user1@example.com", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = withRole(httptest.NewRequest("GET", "/admin/lockouts", nil), 2, utils.RoleAdmin)
+	w = httptest.NewRecorder()
+	handler.GetLockoutEvents(w, req)
+
+	var events []*db.LockoutEvent
+	json.NewDecoder(w.Body).Decode(&events)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, db.LockoutEventUnlocked, events[0].Event)
+		assert.Equal(t, int64(2), *events[0].ActorID)
+	}
+}
+
+func TestParallelLoginsAreCountedBeforeThePasswordCheck(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	handler.LoginPolicy = LoginPolicy{
+		Account:   db.LockoutPolicy{MaxFailures: 3, Window: time.Hour, Lockout: time.Hour},
+		IP:        db.LockoutPolicy{MaxFailures: 100, Window: time.Hour, Lockout: time.Hour},
+		BaseDelay: time.Minute,
+	}
+
+	// every attempt starts before any password check is done, yet only
+	// the first one gets to guess
+	codes := make([]int, 20)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = attemptLogin(handler, "user1@example.com", "wrong-password").Code
+		}(i)
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 1, http.StatusTooManyRequests: 19}, counts)
+
+	// a successful login takes its attempt back from the address
+	w := attemptLogin(handler, "user2@example.com", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	mock := handler.DB.(*mockDB)
+	assert.Equal(t, 1, mock.loginFailures[db.LoginSubjectIP+"/192.0.2.1"].Failures)
+	assert.NotContains(t, mock.loginFailures, db.LoginSubjectAccount+"/user2@example.com")
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := LoginPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Duration(0), p.delay(0))
+	assert.Equal(t, time.Second, p.delay(1))
+	assert.Equal(t, 4*time.Second, p.delay(3))
+	assert.Equal(t, 10*time.Second, p.delay(5))
+	assert.Equal(t, 10*time.Second, p.delay(200))
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/totp"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+const (
+	// TOTPIssuer is the account issuer authenticator apps show next to codes
+	TOTPIssuer = "Ewallet"
+
+	// MFAChallengeTTL is how long a login waits for its TOTP code
+	MFAChallengeTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+// MFAChallengeResponse is returned by POST /login instead of tokens when the
+// user has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	Challenge   string    `json:"mfa_challenge"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// startMFAChallenge parks the login of session until POST /login/mfa
+func (h *UserHandler) startMFAChallenge(w http.ResponseWriter, session *db.Session) {
+	raw, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Secure token generation error")
+		return
+	}
+
+	challenge := &db.MFAChallenge{
+		UserID:      session.UserID,
+		Token:       utils.HashToken(raw),
+		DeviceLabel: session.DeviceLabel,
+		IPAddress:   session.IPAddress,
+		UserAgent:   session.UserAgent,
+		ExpiresAt:   time.Now().Add(MFAChallengeTTL),
+	}
+
+	if err := h.DB.CreateMFAChallenge(challenge); err != nil {
+		respondFailure(w, err, "Failed to create MFA challenge")
+		return
+	}
+
+	json.NewEncoder(w).Encode(MFAChallengeResponse{MFARequired: true, Challenge: raw, ExpiresAt: challenge.ExpiresAt})
+}
+
+// LoginMFARequest completes a login with either a TOTP code or a recovery code
+type LoginMFARequest struct {
+	Challenge    string `json:"mfa_challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (req *LoginMFARequest) validate(v *validator) {
+	v.required("mfa_challenge", req.Challenge)
+	v.check(req.Code != "" || req.RecoveryCode != "", "code", "is required unless a recovery_code is given")
+}
+
+// LoginMFA handles POST /login/mfa. It completes a login with the code from
+// the user's authenticator, or with one of their recovery codes.
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFARequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	var recoveryHash string
+	if req.RecoveryCode != "" {
+		recoveryHash = utils.HashToken(normalizeRecoveryCode(req.RecoveryCode))
+	}
+
+	challenge, err := h.DB.CompleteMFAChallenge(utils.HashToken(req.Challenge), req.Code, recoveryHash)
+	switch {
+	case errors.Is(err, db.ErrMFAChallengeInvalid):
+		respondProblem(w, http.StatusUnauthorized, problem.CodeMFAChallenge, "Invalid or expired MFA challenge")
+		return
+	case errors.Is(err, db.ErrInvalidTOTPCode), errors.Is(err, db.ErrTOTPNotEnrolled):
+		respondProblem(w, http.StatusUnauthorized, problem.CodeInvalidTOTPCode, "Invalid code")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to verify code")
+		return
+	}
+
+	user, err := h.DB.GetUserByID(challenge.UserID)
+	if err != nil || user == nil || user.Status != "active" {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	h.startSession(w, user, &db.Session{
+		UserID:      user.ID,
+		DeviceLabel: challenge.DeviceLabel,
+		IPAddress:   challenge.IPAddress,
+		UserAgent:   challenge.UserAgent,
+	})
+}
+
+// EnrollTOTP handles POST /mfa/totp/enroll. It returns a new secret and its
+// otpauth:// URI; two-factor authentication is enabled by confirming a code
+// generated from it.
+func (h *UserHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get user")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Secret generation error")
+		return
+	}
+
+	err = h.DB.StartTOTPEnrolment(userID, secret)
+	if errors.Is(err, db.ErrTOTPAlreadyEnabled) {
+		respondProblem(w, http.StatusConflict, problem.CodeTOTPEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to start enrolment")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(TOTPIssuer, user.Email, secret),
+	})
+}
+
+// TOTPCodeRequest carries a code from the user's authenticator
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (req *TOTPCodeRequest) validate(v *validator) {
+	v.required("code", req.Code)
+}
+
+// ConfirmTOTP handles POST /mfa/totp/confirm. A valid code enables
+// two-factor authentication; the response carries recovery codes, which are
+// shown this once.
+func (h *UserHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req TOTPCodeRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Recovery code generation error")
+		return
+	}
+
+	err = h.DB.ConfirmTOTPEnrolment(userID, req.Code, hashes)
+	switch {
+	case errors.Is(err, db.ErrTOTPNotEnrolled):
+		respondProblem(w, http.StatusConflict, problem.CodeTOTPNotEnrolled, "Start enrolment first")
+		return
+	case errors.Is(err, db.ErrTOTPAlreadyEnabled):
+		respondProblem(w, http.StatusConflict, problem.CodeTOTPEnabled, "Two-factor authentication is already enabled")
+		return
+	case errors.Is(err, db.ErrInvalidTOTPCode):
+		respondProblem(w, http.StatusUnprocessableEntity, problem.CodeInvalidTOTPCode, "Invalid code")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP handles POST /mfa/totp/disable, which needs a current code
+func (h *UserHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req TOTPCodeRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	ok, err := throttleTOTP(w, h.DB, h.LoginPolicy, userID, func() error {
+		return h.DB.DisableTOTP(userID, req.Code)
+	})
+	if !ok {
+		return
+	}
+
+	switch {
+	case errors.Is(err, db.ErrTOTPNotEnrolled):
+		respondProblem(w, http.StatusConflict, problem.CodeTOTPNotEnrolled, "Two-factor authentication is not enabled")
+		return
+	case errors.Is(err, db.ErrInvalidTOTPCode):
+		respondProblem(w, http.StatusUnprocessableEntity, problem.CodeInvalidTOTPCode, "Invalid code")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newRecoveryCodes returns recovery codes formatted for the user, e.g.
+// "ABCDE-FGHJK", and the hashes stored for them
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := base32.StdEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = utils.HashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes typed in lower case or without
+// the dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/totp"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+	return code
+}
+
+func TestTOTPEnrolmentAndLogin(t *testing.T) {
+	handler := newSessionTestHandler(t)
+
+	w := postAsUser(handler.EnrollTOTP, "/mfa/totp/enroll", 1, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var enrolment map[string]string
+	json.NewDecoder(w.Body).Decode(&enrolment)
+	secret := enrolment["secret"]
+	assert.Contains(t, enrolment["otpauth_uri"], "secret="+secret)
+
+	w = postAsUser(handler.ConfirmTOTP, "/mfa/totp/confirm", 1, map[string]string{"code": "12345"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = postAsUser(handler.ConfirmTOTP, "/mfa/totp/confirm", 1, map[string]string{"code": currentCode(t, secret)})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var confirmed map[string][]string
+	json.NewDecoder(w.Body).Decode(&confirmed)
+	assert.Len(t, confirmed["recovery_codes"], recoveryCodeCount)
+
+	// the password alone now only yields a challenge
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "user1@example.com", "password": "password123"}`))
+	w = httptest.NewRecorder()
+	handler.Login(w, req)
+
+	var challenge MFAChallengeResponse
+	json.NewDecoder(w.Body).Decode(&challenge)
+	assert.True(t, challenge.MFARequired)
+	assert.NotEmpty(t, challenge.Challenge)
+
+	loginMFA := func(payload map[string]string) *httptest.ResponseRecorder {
+		payload["mfa_challenge"] = challenge.Challenge
+		return postAsUser(handler.LoginMFA, "/login/mfa", 0, payload)
+	}
+
+	recovery := strings.ToLower(confirmed["recovery_codes"][0])
+	w = loginMFA(map[string]string{"recovery_code": "AAAAA-AAAAA"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = loginMFA(map[string]string{"recovery_code": recovery})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tokens LoginResponse
+	json.NewDecoder(w.Body).Decode(&tokens)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	// the challenge is used up
+	w = loginMFA(map[string]string{"code": currentCode(t, secret)})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestTransferAboveThresholdRequiresTOTP(t *testing.T) {
+	mockDB := NewMockDB()
+	from, _ := mockDB.CreateWallet(1, "USD")
+	to, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(from.ID, usd(t, "500"))
+
+	threshold, _ := money.Parse("100", "USD")
+	handler := &WalletHandler{DB: mockDB, TOTPThresholds: map[string]money.Money{"USD": threshold}}
+
+	transfer := func(amount, code string) int {
+		return postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{
+			"from_wallet_id": from.ID,
+			"to_wallet_id":   to.ID,
+			"amount":         amount,
+			"totp_code":      code,
+		}).Code
+	}
+
+	assert.Equal(t, http.StatusOK, transfer("100", ""))
+	assert.Equal(t, http.StatusForbidden, transfer("150", ""), "not enrolled")
+
+	mockDB.TOTPCodes = map[int64]string{1: "123456"}
+	assert.Equal(t, http.StatusForbidden, transfer("150", "654321"))
+	assert.Equal(t, http.StatusOK, transfer("150", "123456"))
+
+	wallet, _ := mockDB.GetWalletByID(from.ID)
+	assert.Equal(t, "250.00", wallet.Balance.String())
+}
+
+func TestWrongTOTPCodesLockOutTransfers(t *testing.T) {
+	mockDB := NewMockDB()
+	from, _ := mockDB.CreateWallet(1, "USD")
+	to, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(from.ID, usd(t, "500"))
+	mockDB.TOTPCodes = map[int64]string{1: "123456"}
+
+	threshold, _ := money.Parse("100", "USD")
+	handler := &WalletHandler{DB: mockDB, TOTPThresholds: map[string]money.Money{"USD": threshold}}
+	handler.LoginPolicy.Account = db.LockoutPolicy{MaxFailures: 3, Window: time.Hour, Lockout: time.Hour}
+
+	transfer := func(code string) *httptest.ResponseRecorder {
+		return postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{
+			"from_wallet_id": from.ID,
+			"to_wallet_id":   to.ID,
+			"amount":         "150",
+			"totp_code":      code,
+		})
+	}
+
+	// a right code starts the count over
+	assert.Equal(t, http.StatusForbidden, transfer("000000").Code)
+	assert.Equal(t, http.StatusForbidden, transfer("000001").Code)
+	assert.Equal(t, http.StatusOK, transfer("123456").Code)
+
+	for _, code := range []string{"000000", "000001", "000002"} {
+		assert.Equal(t, http.StatusForbidden, transfer(code).Code)
+	}
+
+	// after three wrong codes not even the right one is checked
+	w := transfer("123456")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problem.CodeTOTPThrottled, problemCode(w))
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	assert.Equal(t, "350.00", from.Balance.String())
+
+	if assert.Len(t, mockDB.lockoutEvents, 1) {
+		assert.Equal(t, db.LoginSubjectTOTP, mockDB.lockoutEvents[0].Subject)
+		assert.Equal(t, "1", mockDB.lockoutEvents[0].Key)
+	}
+}
+
+func TestWrongTOTPCodesLockOutDisabling(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	handler.LoginPolicy.Account = db.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+
+	w := postAsUser(handler.EnrollTOTP, "/mfa/totp/enroll", 1, nil)
+	var enrolment map[string]string
+	json.NewDecoder(w.Body).Decode(&enrolment)
+	w = postAsUser(handler.ConfirmTOTP, "/mfa/totp/confirm", 1, map[string]string{"code": currentCode(t, enrolment["secret"])})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	disable := func(code string) *httptest.ResponseRecorder {
+		return postAsUser(handler.DisableTOTP, "/mfa/totp/disable", 1, map[string]string{"code": code})
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, disable("000000").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, disable("000001").Code)
+
+	w = disable("000002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problem.CodeTOTPThrottled, problemCode(w))
+
+	// unlocking the user lifts the lockout of their codes too
+	req := mux.SetURLVars(withRole(httptest.NewRequest("POST", "/admin/users/1/unlock", nil), 2, utils.RoleAdmin), map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	handler.UnlockUser(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	user, _ := handler.DB.GetUserByID(1)
+	assert.True(t, user.TOTPEnabled)
+	w = disable("000003")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+const (
+	// PasswordResetTTL is how long a reset token from POST /password/forgot works
+	PasswordResetTTL = 30 * time.Minute
+
+	minPasswordLength = 8
+)
+
+// ChangePasswordRequest is the payload of POST /password/change
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (req *ChangePasswordRequest) validate(v *validator) {
+	v.required("current_password", req.CurrentPassword)
+	validatePassword(v, "new_password", req.NewPassword)
+}
+
+// ChangePassword handles POST /password/change
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get user")
+		return
+	}
+
+	// a wrong current password counts against the account like a failed
+	// login, so a stolen token can not be used to guess the password
+	account := db.LoginCounter{Subject: db.LoginSubjectAccount, Key: loginKey(user.Email), Policy: h.LoginPolicy.Account, Delay: h.LoginPolicy.delay}
+	ok, err := throttleGuess(w, h.DB, account, db.ErrWrongPassword, problem.CodeLoginThrottled, "Too many failed login attempts, try again later", func() error {
+		return h.DB.ChangePassword(userID, req.CurrentPassword, req.NewPassword)
+	})
+	if !ok {
+		return
+	}
+
+	switch {
+	case errors.Is(err, db.ErrWrongPassword):
+		respondProblem(w, http.StatusForbidden, problem.CodeWrongPassword, "Current password is wrong")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to change password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPasswordRequest is the payload of POST /password/forgot
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+func (req *ForgotPasswordRequest) validate(v *validator) {
+	validateEmail(v, "email", req.Email)
+}
+
+// ForgotPassword handles POST /password/forgot. It sends a reset token to the
+// user's email and answers the same whether or not the email is registered,
+// so it can not be used to find accounts: the lookup and sending happen after
+// the answer. Requests are limited per email and per IP address by
+// ResetPolicy.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	wait, err := h.DB.ReserveLoginAttempt(
+		db.LoginCounter{Subject: db.LoginSubjectReset, Key: loginKey(req.Email), Policy: h.ResetPolicy.Email},
+		db.LoginCounter{Subject: db.LoginSubjectResetIP, Key: clientIP(r), Policy: h.ResetPolicy.IP},
+	)
+	if err != nil {
+		respondFailure(w, err, "Failed to check attempts")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondProblem(w, http.StatusTooManyRequests, problem.CodeResetThrottled, "Too many password reset requests, try again later")
+		return
+	}
+
+	h.resets.Add(1)
+	go func() {
+		defer h.resets.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := h.sendPasswordReset(ctx, req.Email); err != nil {
+			log.Printf("failed to send password reset: %v", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset sends a reset token to the user with email, and does
+// nothing when there is none
+func (h *UserHandler) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := h.DB.GetUserByEmail(email)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if h.Notifier == nil {
+		return errors.New("no notifier configured")
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	if err := h.DB.CreatePasswordReset(user.ID, utils.HashToken(token), time.Now().Add(PasswordResetTTL)); err != nil {
+		return fmt.Errorf("user %d: %w", user.ID, err)
+	}
+
+	err = h.Notifier.Send(ctx, notify.Message{
+		Channel: notify.ChannelEmail,
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use this token to reset your password within %s: %s", PasswordResetTTL, token),
+	})
+	if err != nil {
+		return fmt.Errorf("user %d: %w", user.ID, err)
+	}
+
+	return nil
+}
+
+// ResetPasswordRequest is the payload of POST /password/reset
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+func (req *ResetPasswordRequest) validate(v *validator) {
+	v.required("token", req.Token)
+	validatePassword(v, "new_password", req.NewPassword)
+}
+
+// ResetPassword handles POST /password/reset. The token works once, and all
+// of the user's sessions are revoked.
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := h.DB.ResetPassword(utils.HashToken(req.Token), req.NewPassword)
+	if errors.Is(err, db.ErrResetTokenInvalid) {
+		respondProblem(w, http.StatusBadRequest, problem.CodeResetTokenInvalid, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to reset password")
+		return
+	}
+
+	utils.ForgetTokenState(userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, msg notify.Message) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func TestChangePassword(t *testing.T) {
+	handler := newSessionTestHandler(t)
+
+	w := postAsUser(handler.ChangePassword, "/password/change", 1, map[string]string{"current_password": "wrong", "new_password": "new-password"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postAsUser(handler.ChangePassword, "/password/change", 1, map[string]string{"current_password": "password123", "new_password": "short"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = postAsUser(handler.ChangePassword, "/password/change", 1, map[string]string{"current_password": "password123", "new_password": "new-password"})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "user1@example.com", "password": "password123"}`))
+	w = httptest.NewRecorder()
+	handler.Login(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestChangePasswordCountsWrongPasswords(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	handler.LoginPolicy.Account = db.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+
+	change := func(current string) *httptest.ResponseRecorder {
+		return postAsUser(handler.ChangePassword, "/password/change", 1, map[string]string{"current_password": current, "new_password": "new-password"})
+	}
+
+	assert.Equal(t, http.StatusForbidden, change("wrong").Code)
+	assert.Equal(t, http.StatusForbidden, change("wrong-again").Code)
+
+	// the account is locked for password changes and logins alike
+	w := change("password123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problem.CodeLoginThrottled, problemCode(w))
+	assert.Equal(t, http.StatusTooManyRequests, attemptLogin(handler, "user1@example.com", "password123").Code)
+}
+
+func TestPasswordResetRevokesSessions(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+	before := login(t, handler, "user1@example.com", "laptop")
+
+	w := postAsUser(handler.ForgotPassword, "/password/forgot", 0, map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	handler.resets.Wait()
+	assert.Empty(t, notifier.messages)
+
+	w = postAsUser(handler.ForgotPassword, "/password/forgot", 0, map[string]string{"email": "user1@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	handler.resets.Wait()
+	if !assert.Len(t, notifier.messages, 1) {
+		return
+	}
+	assert.Equal(t, "user1@example.com", notifier.messages[0].To)
+
+	body := notifier.messages[0].Body
+	token := body[strings.LastIndex(body, " ")+1:]
+
+	reset := map[string]string{"token": token, "new_password": "brand-new-password"}
+	w = postAsUser(handler.ResetPassword, "/password/reset", 0, reset)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// the token is single-use and sessions from before the reset are gone
+	w = postAsUser(handler.ResetPassword, "/password/reset", 0, reset)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = refresh(handler, before.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "user1@example.com", "password": "brand-new-password"}`))
+	w = httptest.NewRecorder()
+	handler.Login(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestForgotPasswordIsLimited(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+	handler.ResetPolicy = ResetPolicy{
+		Email: db.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour},
+		IP:    db.LockoutPolicy{MaxFailures: 4, Window: time.Hour, Lockout: time.Hour},
+	}
+
+	// the mock is not safe for parallel sends, so each one is waited for
+	forgot := func(email string) *httptest.ResponseRecorder {
+		w := postAsUser(handler.ForgotPassword, "/password/forgot", 0, map[string]string{"email": email})
+		handler.resets.Wait()
+		return w
+	}
+
+	// registered and unknown emails are limited alike
+	for _, email := range []string{"user1@example.com", "nobody@example.com"} {
+		assert.Equal(t, http.StatusAccepted, forgot(email).Code)
+		assert.Equal(t, http.StatusAccepted, forgot(email).Code)
+
+		w := forgot(email)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, problem.CodeResetThrottled, problemCode(w))
+		assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	}
+
+	// the address has used up its requests too
+	w := forgot("user2@example.com")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Len(t, notifier.messages, 2)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+)
+
+// DefaultPaymentRequestTTL is how long a payment request without an
+// expires_at can be accepted; MaxPaymentRequestTTL caps a given expires_at.
+const (
+	DefaultPaymentRequestTTL = 7 * 24 * time.Hour
+	MaxPaymentRequestTTL     = 30 * 24 * time.Hour
+)
+
+// RequestPaymentRequest asks payer_id to pay an amount into the caller's
+// wallet to_wallet_id. Times are RFC 3339 times or dates; an expires_at date
+// includes the whole day.
+type RequestPaymentRequest struct {
+	PayerID    int64       `json:"payer_id"`
+	ToWalletID int64       `json:"to_wallet_id"`
+	Amount     json.Number `json:"amount"`
+	Note       string      `json:"note"`
+	ExpiresAt  string      `json:"expires_at"`
+
+	expires time.Time
+}
+
+func (req *RequestPaymentRequest) validate(v *validator) {
+	v.check(req.PayerID > 0, "payer_id", "is required")
+	v.check(req.ToWalletID > 0, "to_wallet_id", "is required")
+	validateAmount(v, "amount", req.Amount)
+	v.maxLength("note", req.Note, 256)
+
+	if req.ExpiresAt != "" {
+		var ok bool
+		req.expires, ok = parseTime(req.ExpiresAt, true)
+		v.check(ok, "expires_at", "must be a date or an RFC 3339 time")
+		v.check(!ok || req.expires.After(time.Now()), "expires_at", "must be in the future")
+		v.check(!ok || req.expires.Before(time.Now().Add(MaxPaymentRequestTTL)), "expires_at", "must be within 30 days")
+	}
+}
+
+// AcceptRequest pays a payment request. Without from_wallet_id the payer
+// pays from their wallet in the request's currency.
+type AcceptRequest struct {
+	FromWalletID int64  `json:"from_wallet_id"`
+	TOTPCode     string `json:"totp_code"`
+}
+
+func (req *AcceptRequest) validate(v *validator) {
+	v.check(req.FromWalletID >= 0, "from_wallet_id", "must be a wallet ID")
+}
+
+// RequestPayment handles POST /wallets/requests
+func (h *WalletHandler) RequestPayment(w http.ResponseWriter, r *http.Request) {
+	var req RequestPaymentRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	if req.PayerID == userID {
+		respondInvalid(w, invalidField("payer_id", "must be another user"))
+		return
+	}
+
+	toWallet, err := h.DB.GetWalletByID(req.ToWalletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if toWallet.UserID != userID {
+		respondError(w, http.StatusForbidden, "Wallet does not belong to you")
+		return
+	}
+
+	amount, err := money.Parse(req.Amount.String(), toWallet.Currency)
+	if err != nil {
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+toWallet.Currency))
+		return
+	}
+
+	expires := req.expires
+	if req.ExpiresAt == "" {
+		expires = time.Now().Add(DefaultPaymentRequestTTL)
+	}
+
+	// an unknown payer, one that is not active and one without a wallet in
+	// the currency all get the same answer
+	pr := &db.PaymentRequest{RequesterID: userID, PayerID: req.PayerID, ToWalletID: req.ToWalletID, Amount: amount, Note: req.Note, ExpiresAt: expires}
+	if err := h.DB.CreatePaymentRequest(pr); err != nil {
+		respondFailure(w, err, "Failed to request payment")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, pr)
+}
+
+// IncomingPaymentRequests handles GET /wallets/requests/incoming. It returns
+// the pending requests the caller can still accept or decline, newest first.
+func (h *WalletHandler) IncomingPaymentRequests(w http.ResponseWriter, r *http.Request) {
+	h.paymentRequests(w, r, db.PaymentRequestQuery{Incoming: true, Status: db.RequestPending})
+}
+
+// PaymentRequests handles GET /wallets/requests. It returns every request the
+// caller sent or received, newest first, optionally only those with status.
+func (h *WalletHandler) PaymentRequests(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	v := &validator{}
+	if status != "" {
+		validateChoice(v, "status", status, db.RequestStatuses)
+	}
+	if err := v.err(); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	h.paymentRequests(w, r, db.PaymentRequestQuery{Status: status})
+}
+
+func (h *WalletHandler) paymentRequests(w http.ResponseWriter, r *http.Request, q db.PaymentRequestQuery) {
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	q.UserID = userID
+	requests, err := h.DB.GetPaymentRequests(q)
+	if err != nil {
+		respondFailure(w, err, "Failed to get payment requests")
+		return
+	}
+
+	if requests == nil {
+		requests = []*db.PaymentRequest{}
+	}
+
+	respondJSON(w, http.StatusOK, requests)
+}
+
+// paymentRequest returns the payment request named in the path when the
+// caller is its payer or, with requester set, its requester
+func (h *WalletHandler) paymentRequest(w http.ResponseWriter, r *http.Request, requester bool) (*db.PaymentRequest, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid payment request ID")
+		return nil, false
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return nil, false
+	}
+
+	pr, err := h.DB.GetPaymentRequest(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get payment request")
+		return nil, false
+	}
+
+	switch {
+	case requester && pr.RequesterID != userID:
+		respondError(w, http.StatusForbidden, "Payment request was not sent by you")
+		return nil, false
+	case !requester && pr.PayerID != userID:
+		respondError(w, http.StatusForbidden, "Payment request is not addressed to you")
+		return nil, false
+	}
+
+	return pr, true
+}
+
+// AcceptPaymentRequest handles POST /wallets/requests/{id}/accept. The payer
+// pays the request with a transfer to the requester, fee included.
+func (h *WalletHandler) AcceptPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	var req AcceptRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	pr, ok := h.paymentRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	if !h.requireVerified(w, pr.PayerID) {
+		return
+	}
+
+	var fromWallet *db.Wallet
+	var err error
+	if req.FromWalletID != 0 {
+		fromWallet, err = h.DB.GetWalletByID(req.FromWalletID)
+	} else {
+		fromWallet, err = h.DB.GetWalletByUserIDAndCurrency(pr.PayerID, pr.Currency)
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if fromWallet.UserID != pr.PayerID {
+		respondError(w, http.StatusForbidden, "Wallet does not belong to you")
+		return
+	}
+
+	if !h.confirmTOTP(w, pr.PayerID, pr.Amount, pr.Currency, req.TOTPCode) {
+		return
+	}
+
+	pr, err = h.DB.AcceptPaymentRequest(pr.ID, fromWallet.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to accept payment request")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, pr)
+}
+
+// DeclinePaymentRequest handles POST /wallets/requests/{id}/decline
+func (h *WalletHandler) DeclinePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	pr, ok := h.paymentRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	pr, err := h.DB.DeclinePaymentRequest(pr.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to decline payment request")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, pr)
+}
+
+// CancelPaymentRequest handles POST /wallets/requests/{id}/cancel. The
+// requester withdraws a request the payer has not answered yet.
+func (h *WalletHandler) CancelPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	pr, ok := h.paymentRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	pr, err := h.DB.CancelPaymentRequest(pr.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to cancel payment request")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, pr)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+// listRequests gets the payment requests of userID from handler
+func listRequests(t *testing.T, handler http.HandlerFunc, target string, userID int64) []map[string]interface{} {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, withUser(httptest.NewRequest("GET", target, nil), userID))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var requests []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &requests))
+	return requests
+}
+
+func TestPaymentRequestAcceptAndDecline(t *testing.T) {
+	mockDB := NewMockDB()
+	requester, _ := mockDB.CreateWallet(1, "USD")
+	payer, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(payer.ID, usd(t, "100"))
+	handler := &WalletHandler{DB: mockDB}
+
+	dinner := map[string]interface{}{"payer_id": 2, "to_wallet_id": requester.ID, "amount": "30", "note": "dinner"}
+	w := postAsUser(handler.RequestPayment, "/wallets/requests", 1, dinner)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, db.RequestPending, mockDB.Requests[1].Status)
+
+	// unknown users, users without a wallet in the currency and users that
+	// are not active get the same answer
+	mockDB.CreateWallet(3, "EUR")
+	banned, _ := mockDB.CreateWallet(4, "USD")
+	mockDB.Users = []*db.User{{ID: 4, Status: "banned"}}
+	for _, payerID := range []int64{99, 3, banned.UserID} {
+		w = postAsUser(handler.RequestPayment, "/wallets/requests", 1, map[string]interface{}{"payer_id": payerID, "to_wallet_id": requester.ID, "amount": "30"})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, problem.CodePayerUnavailable, problemCode(w))
+	}
+	mockDB.Users = nil
+
+	incoming := listRequests(t, handler.IncomingPaymentRequests, "/wallets/requests/incoming", 2)
+	assert.Len(t, incoming, 1)
+	assert.Equal(t, "dinner", incoming[0]["note"])
+	assert.Empty(t, listRequests(t, handler.IncomingPaymentRequests, "/wallets/requests/incoming", 1))
+
+	// only the payer answers a request
+	w = postToID(handler.AcceptPaymentRequest, "1", 1, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postToID(handler.AcceptPaymentRequest, "1", 2, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "70.00", payer.Balance.String())
+	assert.Equal(t, "30.00", requester.Balance.String())
+
+	var accepted map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.Equal(t, db.RequestAccepted, accepted["status"])
+	assert.Equal(t, float64(1), accepted["send_transaction_id"])
+	assert.Equal(t, float64(2), accepted["receive_transaction_id"])
+
+	w = postToID(handler.DeclinePaymentRequest, "1", 2, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problem.CodeRequestNotPending, problemCode(w))
+
+	// a second request is declined, a third cancelled by its requester
+	postAsUser(handler.RequestPayment, "/wallets/requests", 1, dinner)
+	postAsUser(handler.RequestPayment, "/wallets/requests", 1, dinner)
+
+	w = postToID(handler.DeclinePaymentRequest, "2", 2, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "70.00", payer.Balance.String())
+
+	w = postToID(handler.CancelPaymentRequest, "3", 2, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = postToID(handler.CancelPaymentRequest, "3", 1, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	history := listRequests(t, handler.PaymentRequests, "/wallets/requests", 1)
+	assert.Len(t, history, 3)
+	assert.Equal(t, db.RequestCancelled, history[0]["status"])
+
+	declined := listRequests(t, handler.PaymentRequests, "/wallets/requests?status=declined", 2)
+	assert.Len(t, declined, 1)
+
+	w = postToID(handler.AcceptPaymentRequest, "9", 2, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeRequestNotFound, problemCode(w))
+}
+
+func TestPaymentRequestInsufficientFunds(t *testing.T) {
+	mockDB := NewMockDB()
+	requester, _ := mockDB.CreateWallet(1, "USD")
+	mockDB.CreateWallet(2, "USD")
+	handler := &WalletHandler{DB: mockDB}
+
+	w := postAsUser(handler.RequestPayment, "/wallets/requests", 1, map[string]interface{}{"payer_id": 2, "to_wallet_id": requester.ID, "amount": "30"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// a failed payment leaves the request pending
+	w = postToID(handler.AcceptPaymentRequest, "1", 2, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeInsufficientFunds, problemCode(w))
+	assert.Equal(t, db.RequestPending, mockDB.Requests[1].Status)
+
+	// the request is for the caller's own wallet only
+	w = postAsUser(handler.RequestPayment, "/wallets/requests", 2, map[string]interface{}{"payer_id": 1, "to_wallet_id": requester.ID, "amount": "30"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postAsUser(handler.RequestPayment, "/wallets/requests", 1, map[string]interface{}{"payer_id": 1, "to_wallet_id": requester.ID, "amount": "30"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
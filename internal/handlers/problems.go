@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/fees"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+)
+
+// Every error response is an RFC 7807 problem. Errors the client can act on
+// are answered with a stable code and their own message; anything else is
+// logged and answered with a generic message, so database errors never reach
+// the client.
+
+// respondError answers with the generic code of status
+func respondError(w http.ResponseWriter, status int, message string) {
+	problem.Error(w, status, "", message)
+}
+
+// respondProblem answers with a specific code
+func respondProblem(w http.ResponseWriter, status int, code, detail string) {
+	problem.Error(w, status, code, detail)
+}
+
+// knownError is an error the client is told about
+type knownError struct {
+	err    error
+	status int
+	code   string
+}
+
+// knownErrors is searched in order, so errors that wrap a more general one,
+// e.g. db.ErrWalletNotFound and db.ErrNotFound, are listed first
+var knownErrors = []knownError{
+	{db.ErrUserNotFound, http.StatusNotFound, problem.CodeUserNotFound},
+	{db.ErrWalletNotFound, http.StatusNotFound, problem.CodeWalletNotFound},
+	{db.ErrSessionNotFound, http.StatusNotFound, problem.CodeSessionNotFound},
+	{db.ErrFeeRuleNotFound, http.StatusNotFound, problem.CodeFeeRuleNotFound},
+	{db.ErrQuoteNotFound, http.StatusNotFound, problem.CodeQuoteNotFound},
+	{db.ErrHoldNotFound, http.StatusNotFound, problem.CodeHoldNotFound},
+	{db.ErrTransactionNotFound, http.StatusNotFound, problem.CodeTxNotFound},
+	{db.ErrScheduleNotFound, http.StatusNotFound, problem.CodeScheduleNotFound},
+	{db.ErrPaymentRequestNotFound, http.StatusNotFound, problem.CodeRequestNotFound},
+	{db.ErrNotFound, http.StatusNotFound, problem.CodeNotFound},
+
+	{db.ErrWalletExists, http.StatusConflict, problem.CodeWalletExists},
+	{db.ErrEmailTaken, http.StatusConflict, problem.CodeEmailTaken},
+	{db.ErrPhoneTaken, http.StatusConflict, problem.CodePhoneTaken},
+	{db.ErrDuplicate, http.StatusConflict, problem.CodeDuplicate},
+	{db.ErrQuoteExpired, http.StatusConflict, problem.CodeQuoteExpired},
+	{db.ErrQuoteUsed, http.StatusConflict, problem.CodeQuoteUsed},
+	{db.ErrHoldNotActive, http.StatusConflict, problem.CodeHoldNotActive},
+	{db.ErrAlreadyRefunded, http.StatusConflict, problem.CodeAlreadyRefunded},
+	{db.ErrNotReversible, http.StatusConflict, problem.CodeNotReversible},
+	{db.ErrScheduleNotActive, http.StatusConflict, problem.CodeScheduleNotActive},
+	{db.ErrPaymentRequestNotPending, http.StatusConflict, problem.CodeRequestNotPending},
+
+	{db.ErrInsufficientFunds, http.StatusBadRequest, problem.CodeInsufficientFunds},
+	{db.ErrQuoteMismatch, http.StatusBadRequest, problem.CodeQuoteMismatch},
+	{db.ErrCaptureExceedsHold, http.StatusBadRequest, problem.CodeCaptureExceedsHold},
+	{db.ErrRefundExceedsOriginal, http.StatusBadRequest, problem.CodeRefundExceeds},
+	{db.ErrInvalidSchedule, http.StatusBadRequest, problem.CodeInvalidSchedule},
+	{money.ErrCurrencyMismatch, http.StatusBadRequest, problem.CodeCurrencyMismatch},
+	{errCurrencyRequired, http.StatusBadRequest, problem.CodeCurrencyRequired},
+	{fees.ErrInvalidRule, http.StatusBadRequest, problem.CodeInvalidFeeRule},
+	{money.ErrUnknownCurrency, http.StatusBadRequest, problem.CodeInvalidRequest},
+	{money.ErrInvalidAmount, http.StatusBadRequest, problem.CodeInvalidRequest},
+	{money.ErrOverflow, http.StatusBadRequest, problem.CodeInvalidRequest},
+
+	{db.ErrWalletFrozen, http.StatusForbidden, problem.CodeWalletFrozen},
+	{fx.ErrRateNotFound, http.StatusUnprocessableEntity, problem.CodeRateNotFound},
+	{db.ErrPayerUnavailable, http.StatusUnprocessableEntity, problem.CodePayerUnavailable},
+
+	{db.ErrRefreshTokenReused, http.StatusUnauthorized, problem.CodeRefreshTokenReused},
+	{db.ErrRefreshTokenInvalid, http.StatusUnauthorized, problem.CodeTokenInvalid},
+	{db.ErrMFAChallengeInvalid, http.StatusUnauthorized, problem.CodeMFAChallenge},
+	{db.ErrInvalidTOTPCode, http.StatusUnauthorized, problem.CodeInvalidTOTPCode},
+	{db.ErrTOTPNotEnrolled, http.StatusConflict, problem.CodeTOTPNotEnrolled},
+	{db.ErrTOTPAlreadyEnabled, http.StatusConflict, problem.CodeTOTPEnabled},
+	{db.ErrWrongPassword, http.StatusUnauthorized, problem.CodeWrongPassword},
+	{db.ErrPasswordNotChanged, http.StatusUnprocessableEntity, problem.CodePasswordUnchanged},
+	{db.ErrResetTokenInvalid, http.StatusBadRequest, problem.CodeResetTokenInvalid},
+	{db.ErrUnknownContact, http.StatusBadRequest, problem.CodeUnknownContact},
+	{db.ErrVerificationCodeInvalid, http.StatusBadRequest, problem.CodeVerificationCode},
+}
+
+// respondFailure answers err with its problem when it is a known error.
+// Otherwise err is logged and the client only gets message with a 500.
+func respondFailure(w http.ResponseWriter, err error, message string) {
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			respondProblem(w, known.status, known.code, capitalize(known.err.Error()))
+			return
+		}
+	}
+
+	log.Printf("%s: %v", message, err)
+	respondProblem(w, http.StatusInternalServerError, problem.CodeInternal, message)
+}
+
+// capitalize turns an error message into a sentence
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
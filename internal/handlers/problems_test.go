@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// problemCode returns the code of the problem in w
+func problemCode(w *httptest.ResponseRecorder) string {
+	var p problem.Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return p.Code
+}
+
+func TestRespondFailure(t *testing.T) {
+	driverErr := errors.New(`ERROR: duplicate key value violates unique constraint "users_email_key"`)
+
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{fmt.Errorf("failed to get wallet by id: %w", db.ErrWalletNotFound), http.StatusNotFound, problem.CodeWalletNotFound, "Wallet not found"},
+		{fmt.Errorf("failed to create user: %w: %w", db.ErrEmailTaken, driverErr), http.StatusConflict, problem.CodeEmailTaken, "Email already exists"},
+		{db.ErrWalletExists, http.StatusConflict, problem.CodeWalletExists, "Wallet already exists for this currency"},
+		{fmt.Errorf("failed to lock wallet: %w", db.ErrInsufficientFunds), http.StatusBadRequest, problem.CodeInsufficientFunds, "Insufficient funds"},
+		{db.ErrWalletFrozen, http.StatusForbidden, problem.CodeWalletFrozen, "Wallet is frozen"},
+		{driverErr, http.StatusInternalServerError, problem.CodeInternal, "Failed to create user"},
+	} {
+		w := httptest.NewRecorder()
+		respondFailure(w, tc.err, "Failed to create user")
+
+		var p problem.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		assert.Equal(t, tc.status, w.Code, tc.err.Error())
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, tc.status, p.Status)
+		assert.Equal(t, tc.code, p.Code)
+		assert.Equal(t, "/problems/"+tc.code, p.Type)
+		assert.Equal(t, tc.detail, p.Detail)
+		assert.NotContains(t, w.Body.String(), "users_email_key")
+	}
+}
+
+func TestTransferInsufficientFundsProblem(t *testing.T) {
+	mockDB := NewMockDB()
+	fromWallet, _ := mockDB.CreateWallet(1, "USD")
+	toWallet, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "10"))
+	r := setupRouterWithMockDB(mockDB)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"from_wallet_id": fromWallet.ID,
+		"to_wallet_id":   toWallet.ID,
+		"amount":         "10.01",
+	})
+	req := withUser(httptest.NewRequest("POST", "/wallets/transfer", bytes.NewReader(body)), 1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeInsufficientFunds, problemCode(w))
+}
+
+func TestUserErrorsAreProblems(t *testing.T) {
+	handler := &UserHandler{DB: &mockDB{}}
+
+	w, resp := createUser(handler, `{"first_name": ""}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, problem.CodeValidationFailed, resp.Code)
+
+	req := mux.SetURLVars(withRole(httptest.NewRequest("GET", "/users/9", nil), 1, utils.RoleAdmin), map[string]string{"id": "9"})
+	w = httptest.NewRecorder()
+	handler.GetUser(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeUserNotFound, problemCode(w))
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+)
+
+// Recipient is what a sender learns about the user behind an email or phone
+// number before sending them money: enough to confirm it is the right
+// person, and the currencies they can receive in.
+type Recipient struct {
+	DisplayName string   `json:"display_name"`
+	Currencies  []string `json:"currencies"`
+}
+
+// maskName shows the first letter of the first name and the initial of the
+// last name, e.g. "J*** D." for John Doe
+func maskName(first, last string) string {
+	r, n := utf8.DecodeRuneInString(first)
+	name := string(r) + strings.Repeat("*", utf8.RuneCountInString(first[n:]))
+
+	if r, _ := utf8.DecodeRuneInString(last); last != "" {
+		name += " " + string(r) + "."
+	}
+
+	return name
+}
+
+// recipient returns the user with email or, without one, phoneNumber.
+// Users who are not active can not receive money and are not found.
+func (h *WalletHandler) recipient(w http.ResponseWriter, email, phoneNumber string) (*db.User, bool) {
+	var user *db.User
+	var err error
+	if email != "" {
+		user, err = h.DB.GetUserByEmail(email)
+	} else {
+		user, err = h.DB.GetUserByPhoneNumber(phoneNumber)
+	}
+
+	if errors.Is(err, db.ErrUserNotFound) || (err == nil && user.Status != "active") {
+		respondProblem(w, http.StatusNotFound, problem.CodeRecipientNotFound, "No user can receive money at this email or phone number")
+		return nil, false
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to find recipient")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// transferRecipient returns the wallet a transfer goes to. A transfer
+// addressed by email or phone number goes to the recipient's wallet in the
+// currency it arrives in: the sender's currency, or the quote's target
+// currency for a conversion.
+func (h *WalletHandler) transferRecipient(w http.ResponseWriter, req *TransferRequest, fromWallet *db.Wallet) (*db.Wallet, bool) {
+	if req.ToWalletID != 0 {
+		toWallet, err := h.DB.GetWalletByID(req.ToWalletID)
+		if errors.Is(err, db.ErrWalletNotFound) {
+			respondProblem(w, http.StatusNotFound, problem.CodeWalletNotFound, "Receiver wallet not found")
+			return nil, false
+		}
+		if err != nil {
+			respondFailure(w, err, "Failed to get wallet")
+			return nil, false
+		}
+
+		return toWallet, true
+	}
+
+	user, ok := h.recipient(w, req.ToEmail, req.ToPhoneNumber)
+	if !ok {
+		return nil, false
+	}
+
+	currency := fromWallet.Currency
+	if req.QuoteID != "" {
+		quote, err := h.DB.GetFXQuote(req.QuoteID)
+		if err == nil && quote.UserID != fromWallet.UserID {
+			err = db.ErrQuoteNotFound
+		}
+		if err != nil {
+			respondFailure(w, err, "Failed to get quote")
+			return nil, false
+		}
+		currency = quote.ToCurrency
+	}
+
+	toWallet, err := h.DB.GetWalletByUserIDAndCurrency(user.ID, currency)
+	if errors.Is(err, db.ErrWalletNotFound) {
+		respondProblem(w, http.StatusNotFound, problem.CodeWalletNotFound, "Recipient has no "+currency+" wallet")
+		return nil, false
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return nil, false
+	}
+
+	if toWallet.ID == fromWallet.ID {
+		respondInvalid(w, invalidField("from_wallet_id", "must differ from the recipient's wallet"))
+		return nil, false
+	}
+
+	return toWallet, true
+}
+
+// LookupRecipient handles GET /wallets/recipients?email= or ?phone_number=.
+// It lets a sender confirm who they are about to pay without revealing the
+// recipient's full name or wallet IDs.
+func (h *WalletHandler) LookupRecipient(w http.ResponseWriter, r *http.Request) {
+	email, phoneNumber := r.URL.Query().Get("email"), r.URL.Query().Get("phone_number")
+
+	v := &validator{}
+	switch {
+	case email != "":
+		validateEmail(v, "email", email)
+		v.check(phoneNumber == "", "phone_number", "can not be combined with email")
+	default:
+		validatePhone(v, "phone_number", phoneNumber)
+	}
+	if err := v.err(); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	if _, err := callerID(r, 0); err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	user, ok := h.recipient(w, email, phoneNumber)
+	if !ok {
+		return
+	}
+
+	wallets, err := h.DB.GetWalletsByUserID(user.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallets")
+		return
+	}
+
+	recipient := Recipient{DisplayName: maskName(user.FirstName, user.LastName), Currencies: []string{}}
+	for _, wallet := range wallets {
+		recipient.Currencies = append(recipient.Currencies, wallet.Currency)
+	}
+	sort.Strings(recipient.Currencies)
+
+	respondJSON(w, http.StatusOK, recipient)
+}
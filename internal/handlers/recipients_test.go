@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskName(t *testing.T) {
+	assert.Equal(t, "J*** D.", maskName("John", "Doe"))
+	assert.Equal(t, "Z** Ö.", maskName("Zoë", "Östlund"))
+	assert.Equal(t, "A", maskName("A", ""))
+}
+
+func TestTransferToEmailOrPhone(t *testing.T) {
+	mockDB := NewMockDB()
+	sender, _ := mockDB.CreateWallet(1, "USD")
+	dollars, _ := mockDB.CreateWallet(2, "USD")
+	euros, _ := mockDB.CreateWallet(2, "EUR")
+	mockDB.UpdateWalletBalance(sender.ID, usd(t, "100"))
+	mockDB.Users = []*db.User{
+		{ID: 2, FirstName: "Jane", LastName: "Roe", Email: "jane@example.com", PhoneNumber: "+8801700000000", Status: "active"},
+		{ID: 3, FirstName: "Ben", LastName: "Banned", Email: "ben@example.com", PhoneNumber: "5550001", Status: "banned"},
+	}
+	handler := &WalletHandler{DB: mockDB}
+
+	w := postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_email": "jane@example.com", "amount": "30"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "30.00", dollars.Balance.String())
+
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_phone_number": "+8801700000000", "amount": "20"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "50.00", dollars.Balance.String())
+
+	// emails are matched regardless of case
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_email": "Jane@Example.com", "amount": "10"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "60.00", dollars.Balance.String())
+
+	// a conversion goes to the wallet in the quote's currency
+	nine, _ := money.Parse("9", "EUR")
+	mockDB.Quotes["q1"] = &db.FXQuote{ID: "q1", UserID: 1, FromCurrency: "USD", ToCurrency: "EUR", Amount: usd(t, "10"), Fee: usd(t, "0"), ConvertedAmount: nine, ExpiresAt: time.Now().Add(time.Minute)}
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_email": "jane@example.com", "quote_id": "q1"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "9.00", euros.Balance.String())
+
+	for _, unknown := range []map[string]interface{}{
+		{"from_wallet_id": sender.ID, "to_email": "nobody@example.com", "amount": "5"},
+		{"from_wallet_id": sender.ID, "to_phone_number": "5550001", "amount": "5"},
+	} {
+		w = postAsUser(handler.Transfer, "/wallets/transfer", 1, unknown)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, problem.CodeRecipientNotFound, problemCode(w))
+	}
+
+	mockDB.Users = append(mockDB.Users, &db.User{ID: 4, FirstName: "Eve", Email: "eve@example.com", Status: "active"})
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_email": "eve@example.com", "amount": "5"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeWalletNotFound, problemCode(w))
+
+	// a transfer has exactly one recipient
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "to_wallet_id": dollars.ID, "to_email": "jane@example.com", "amount": "5"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": sender.ID, "amount": "5"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestLookupRecipient(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(2, "USD")
+	mockDB.CreateWallet(2, "EUR")
+	mockDB.Users = []*db.User{{ID: 2, FirstName: "Jane", LastName: "Roe", Email: "jane@example.com", PhoneNumber: "5551234", Status: "active"}}
+	handler := &WalletHandler{DB: mockDB}
+
+	lookup := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.LookupRecipient(w, withUser(httptest.NewRequest("GET", "/wallets/recipients?"+query, nil), 1))
+		return w
+	}
+
+	w := lookup("phone_number=5551234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var recipient map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &recipient))
+	assert.Equal(t, "J*** R.", recipient["display_name"])
+	assert.Equal(t, []interface{}{"EUR", "USD"}, recipient["currencies"])
+	assert.NotContains(t, w.Body.String(), "Jane")
+
+	w = lookup("email=JANE@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = lookup("email=john@example.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeRecipientNotFound, problemCode(w))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, lookup("email=not-an-email").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, lookup("").Code)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// ReversalRequest refunds a transfer. Without an amount whatever is left to
+// refund is refunded.
+type ReversalRequest struct {
+	Amount json.Number `json:"amount"`
+	Note   string      `json:"note"`
+}
+
+func (req *ReversalRequest) validate(v *validator) {
+	if req.Amount != "" {
+		validateAmount(v, "amount", req.Amount)
+	}
+	v.maxLength("note", req.Note, 256)
+}
+
+// ReverseTransaction handles POST /wallets/transactions/{id}/reverse. The
+// transfer whose send record is {id} is refunded, in full or in part, by
+// its receiver, or by an admin on the receiver's behalf.
+func (h *WalletHandler) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid transaction ID")
+		return
+	}
+
+	var req ReversalRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	if _, err := callerID(r, 0); err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	original, err := h.DB.GetTransaction(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get transaction")
+		return
+	}
+
+	if original.Type != "send" || original.ToWalletID == 0 {
+		respondFailure(w, db.ErrNotReversible, "Failed to reverse transaction")
+		return
+	}
+
+	receiver, err := h.DB.GetWalletByID(original.ToWalletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if err := authorizeUser(r, receiver.UserID, utils.PermReverseTransactions); err != nil {
+		respondError(w, accessStatus(err), "Only the receiver can reverse this transaction")
+		return
+	}
+
+	reversal := &db.Reversal{TransactionID: original.ID, Note: req.Note}
+	if req.Amount != "" {
+		reversal.Amount, err = money.Parse(req.Amount.String(), original.Currency)
+		if err != nil {
+			respondInvalid(w, invalidField("amount", "is not a valid amount in "+original.Currency))
+			return
+		}
+	}
+
+	if err := h.DB.ReverseTransfer(reversal); err != nil {
+		respondFailure(w, err, "Failed to reverse transaction")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"message": "Reversed successfully.", "transaction": reversal.Send, "original": reversal.Original})
+}
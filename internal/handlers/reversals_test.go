@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseTransaction(t *testing.T) {
+	mockDB := NewMockDB()
+	customer, _ := mockDB.CreateWallet(1, "USD")
+	merchant, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(customer.ID, usd(t, "100"))
+	handler := &WalletHandler{DB: mockDB}
+
+	transfer := &db.Transfer{FromWalletID: customer.ID, ToWalletID: merchant.ID, Amount: usd(t, "80")}
+	assert.NoError(t, mockDB.TransferFunds(transfer))
+
+	// the sender can not take the money back
+	w := postToID(handler.ReverseTransaction, "1", 1, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postToID(handler.ReverseTransaction, "1", 2, map[string]string{"amount": "90"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeRefundExceeds, problemCode(w))
+
+	w = postToID(handler.ReverseTransaction, "1", 2, map[string]string{"amount": "30", "note": "damaged item"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "50.00", customer.Balance.String())
+
+	var body struct {
+		Transaction map[string]interface{} `json:"transaction"`
+		Original    map[string]interface{} `json:"original"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, float64(1), body.Transaction["reversal_of"])
+	assert.Equal(t, "30.00", body.Original["refunded_amount"])
+
+	// an admin refunds the rest on the merchant's behalf
+	w = postToID(func(w http.ResponseWriter, r *http.Request) {
+		handler.ReverseTransaction(w, withRole(r, 3, utils.RoleAdmin))
+	}, "1", 3, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "100.00", customer.Balance.String())
+	assert.Equal(t, "0.00", merchant.Balance.String())
+	assert.Equal(t, "cancelled", transfer.Send.Status)
+
+	w = postToID(handler.ReverseTransaction, "1", 2, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problem.CodeAlreadyRefunded, problemCode(w))
+
+	// receive records and reversals are not reversible
+	w = postToID(handler.ReverseTransaction, "2", 1, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problem.CodeNotReversible, problemCode(w))
+
+	w = postToID(handler.ReverseTransaction, "99", 2, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeTxNotFound, problemCode(w))
+}
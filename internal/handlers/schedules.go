@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// ScheduleRequest schedules a transfer once at start_at, or every day, week
+// or month from start_at until the optional end_at. Times are RFC 3339 times
+// or dates; an end_at date includes the whole day.
+type ScheduleRequest struct {
+	FromWalletID int64       `json:"from_wallet_id"`
+	ToWalletID   int64       `json:"to_wallet_id"`
+	Amount       json.Number `json:"amount"`
+	Note         string      `json:"note"`
+	Frequency    string      `json:"frequency"`
+	StartAt      string      `json:"start_at"`
+	EndAt        string      `json:"end_at"`
+	TOTPCode     string      `json:"totp_code"`
+
+	start, end time.Time
+}
+
+func (req *ScheduleRequest) validate(v *validator) {
+	v.check(req.FromWalletID > 0, "from_wallet_id", "is required")
+	v.check(req.ToWalletID > 0, "to_wallet_id", "is required")
+	v.check(req.FromWalletID != req.ToWalletID, "to_wallet_id", "must differ from from_wallet_id")
+	validateAmount(v, "amount", req.Amount)
+	v.maxLength("note", req.Note, 256)
+
+	if v.required("frequency", req.Frequency) {
+		validateChoice(v, "frequency", req.Frequency, db.ScheduleFrequencies)
+	}
+
+	var ok bool
+	if v.required("start_at", req.StartAt) {
+		req.start, ok = parseTime(req.StartAt, false)
+		v.check(ok, "start_at", "must be a date or an RFC 3339 time")
+		v.check(!ok || req.start.After(time.Now()), "start_at", "must be in the future")
+	}
+
+	if req.EndAt != "" {
+		req.end, ok = parseTime(req.EndAt, true)
+		v.check(ok, "end_at", "must be a date or an RFC 3339 time")
+		v.check(req.Frequency != db.ScheduleOnce, "end_at", "is only accepted for recurring transfers")
+		v.check(!ok || req.start.IsZero() || req.end.After(req.start), "end_at", "must be after start_at")
+	}
+}
+
+// CreateSchedule handles POST /wallets/schedules
+func (h *WalletHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	if !h.requireVerified(w, userID) {
+		return
+	}
+
+	fromWallet, err := h.DB.GetWalletByID(req.FromWalletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if fromWallet.UserID != userID {
+		respondError(w, http.StatusForbidden, "Wallet does not belong to you")
+		return
+	}
+
+	toWallet, err := h.DB.GetWalletByID(req.ToWalletID)
+	if errors.Is(err, db.ErrWalletNotFound) {
+		respondProblem(w, http.StatusNotFound, problem.CodeWalletNotFound, "Receiver wallet not found")
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if fromWallet.Currency != toWallet.Currency {
+		respondProblem(w, http.StatusBadRequest, problem.CodeCurrencyMismatch, "Currency mismatch between wallets")
+		return
+	}
+
+	amount, err := money.Parse(req.Amount.String(), fromWallet.Currency)
+	if err != nil {
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+fromWallet.Currency))
+		return
+	}
+
+	if !h.confirmTOTP(w, userID, amount, fromWallet.Currency, req.TOTPCode) {
+		return
+	}
+
+	schedule := &db.ScheduledTransfer{
+		UserID:       userID,
+		FromWalletID: req.FromWalletID,
+		ToWalletID:   req.ToWalletID,
+		Amount:       amount,
+		Note:         req.Note,
+		Frequency:    req.Frequency,
+		StartAt:      req.start,
+	}
+	if req.EndAt != "" {
+		schedule.EndAt = &req.end
+	}
+
+	if err := h.DB.CreateScheduledTransfer(schedule); err != nil {
+		respondFailure(w, err, "Failed to schedule transfer")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, schedule)
+}
+
+// Schedules handles GET /wallets/schedules. It returns the caller's
+// scheduled transfers, newest first.
+func (h *WalletHandler) Schedules(w http.ResponseWriter, r *http.Request) {
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	schedules, err := h.DB.GetScheduledTransfersByUserID(userID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get scheduled transfers")
+		return
+	}
+
+	if schedules == nil {
+		schedules = []*db.ScheduledTransfer{}
+	}
+
+	respondJSON(w, http.StatusOK, schedules)
+}
+
+// ownSchedule returns the schedule named in the path when the caller may
+// act on it with perm
+func (h *WalletHandler) ownSchedule(w http.ResponseWriter, r *http.Request, perm utils.Permission) (*db.ScheduledTransfer, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid schedule ID")
+		return nil, false
+	}
+
+	if _, err := callerID(r, 0); err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return nil, false
+	}
+
+	schedule, err := h.DB.GetScheduledTransfer(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get scheduled transfer")
+		return nil, false
+	}
+
+	if err := authorizeUser(r, schedule.UserID, perm); err != nil {
+		respondError(w, accessStatus(err), "Scheduled transfer does not belong to you")
+		return nil, false
+	}
+
+	return schedule, true
+}
+
+// CancelSchedule handles POST /wallets/schedules/{id}/cancel
+func (h *WalletHandler) CancelSchedule(w http.ResponseWriter, r *http.Request) {
+	// only the owner cancels; no role may stop another user's payments
+	schedule, ok := h.ownSchedule(w, r, "")
+	if !ok {
+		return
+	}
+
+	schedule, err := h.DB.CancelScheduledTransfer(schedule.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to cancel scheduled transfer")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+// ScheduleRuns handles GET /wallets/schedules/{id}/runs. It returns every
+// attempt the scheduler made at the schedule, newest first.
+func (h *WalletHandler) ScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := h.ownSchedule(w, r, utils.PermReadWallets)
+	if !ok {
+		return
+	}
+
+	runs, err := h.DB.GetScheduledTransferRuns(schedule.ID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get scheduled transfer runs")
+		return
+	}
+
+	if runs == nil {
+		runs = []*db.ScheduledTransferRun{}
+	}
+
+	respondJSON(w, http.StatusOK, runs)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAndCancelSchedule(t *testing.T) {
+	mockDB := NewMockDB()
+	tenant, _ := mockDB.CreateWallet(1, "USD")
+	landlord, _ := mockDB.CreateWallet(2, "USD")
+	euros, _ := mockDB.CreateWallet(2, "EUR")
+	handler := &WalletHandler{DB: mockDB}
+
+	start := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	rent := map[string]interface{}{"from_wallet_id": tenant.ID, "to_wallet_id": landlord.ID, "amount": "500", "note": "rent", "frequency": "monthly", "start_at": start, "end_at": "2099-12-31"}
+
+	w := postAsUser(handler.CreateSchedule, "/wallets/schedules", 1, rent)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var schedule map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &schedule))
+	assert.Equal(t, db.ScheduleActive, schedule["status"])
+	assert.Equal(t, "500.00", schedule["amount"])
+	assert.Equal(t, schedule["start_at"], schedule["next_run_at"])
+
+	// the payer's wallet, not the payee's, funds a schedule
+	w = postAsUser(handler.CreateSchedule, "/wallets/schedules", 2, rent)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	rent["to_wallet_id"] = euros.ID
+	w = postAsUser(handler.CreateSchedule, "/wallets/schedules", 1, rent)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.CodeCurrencyMismatch, problemCode(w))
+
+	// other users can not cancel it, its owner can once
+	w = postToID(handler.CancelSchedule, "1", 2, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postToID(handler.CancelSchedule, "1", 1, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, db.ScheduleCancelled, mockDB.Schedules[1].Status)
+
+	w = postToID(handler.CancelSchedule, "1", 1, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problem.CodeScheduleNotActive, problemCode(w))
+
+	w = postToID(handler.CancelSchedule, "9", 1, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.CodeScheduleNotFound, problemCode(w))
+}
+
+func TestScheduleValidation(t *testing.T) {
+	handler := &WalletHandler{DB: NewMockDB()}
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	w := postAsUser(handler.CreateSchedule, "/wallets/schedules", 1, map[string]interface{}{
+		"from_wallet_id": 1, "to_wallet_id": 2, "amount": "10", "frequency": "yearly", "start_at": past,
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var body validationResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.ElementsMatch(t, []string{"frequency", "start_at"}, fieldNames(body.Fields))
+
+	// a one-off transfer has no end
+	w = postAsUser(handler.CreateSchedule, "/wallets/schedules", 1, map[string]interface{}{
+		"from_wallet_id": 1, "to_wallet_id": 2, "amount": "10", "frequency": "once", "start_at": "2099-01-01", "end_at": "2099-02-01",
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []string{"end_at"}, fieldNames(body.Fields))
+}
+
+func TestScheduleRuns(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Schedules[1] = &db.ScheduledTransfer{ID: 1, UserID: 1, Status: db.ScheduleActive}
+	mockDB.Runs = []*db.ScheduledTransferRun{
+		{ID: 1, ScheduleID: 1, Attempt: 1, Status: db.RunRetrying, Error: db.ErrInsufficientFunds.Error()},
+		{ID: 2, ScheduleID: 1, Attempt: 2, Status: db.RunCompleted, TransactionID: 7},
+	}
+	handler := &WalletHandler{DB: mockDB}
+
+	runs := func(userID int64, role string) *httptest.ResponseRecorder {
+		req := withRole(httptest.NewRequest("GET", "/wallets/schedules/1/runs", nil), userID, role)
+		w := httptest.NewRecorder()
+		handler.ScheduleRuns(w, mux.SetURLVars(req, map[string]string{"id": "1"}))
+		return w
+	}
+
+	w := runs(1, utils.RoleCustomer)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body, 2)
+	assert.Equal(t, db.RunCompleted, body[0]["status"])
+
+	assert.Equal(t, http.StatusForbidden, runs(2, utils.RoleCustomer).Code)
+	assert.Equal(t, http.StatusOK, runs(2, utils.RoleSupport).Code)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// GetSessions handles GET /sessions and lists the caller's active sessions
+func (h *UserHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	sessions, err := h.DB.GetSessionsByUserID(userID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get sessions")
+		return
+	}
+
+	if sessions == nil {
+		sessions = []*db.Session{}
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// DeleteSession handles DELETE /sessions/{id}. Users can only revoke their
+// own sessions; anything else is reported as not found.
+func (h *UserHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid session id")
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	if err := h.DB.RevokeSession(userID, id); err != nil {
+		respondFailure(w, err, "Failed to revoke session")
+		return
+	}
+
+	utils.ForgetTokenState(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newSessionTestHandler(t *testing.T) *UserHandler {
+	if utils.CurrentKeyRing() == nil {
+		useTestKeyRing(t)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	users := []*db.User{
+		{ID: 1, Email: "user1@example.com", PasswordHash: string(hash), Role: "customer", Status: "active"},
+		{ID: 2, Email: "user2@example.com", PasswordHash: string(hash), Role: "customer", Status: "active"},
+	}
+	return &UserHandler{DB: &mockDB{users: users}}
+}
+
+func login(t *testing.T, handler *UserHandler, email, device string) LoginResponse {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "`+email+`", "password": "password123", "device": "`+device+`"}`))
+	req.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: expected status 200 OK, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp LoginResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp
+}
+
+func refresh(handler *UserHandler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/token/refresh", strings.NewReader(`{"refresh_token": "`+token+`"}`))
+	w := httptest.NewRecorder()
+	handler.RefreshToken(w, req)
+	return w
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	first := login(t, handler, "user1@example.com", "laptop")
+
+	w := refresh(handler, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rotated LoginResponse
+	json.NewDecoder(w.Body).Decode(&rotated)
+	assert.NotEmpty(t, rotated.AccessToken)
+	assert.NotEqual(t, first.RefreshToken, rotated.RefreshToken)
+
+	// the first token was used up; presenting it again revokes the session
+	w = refresh(handler, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = refresh(handler, rotated.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	sessions, _ := handler.DB.GetSessionsByUserID(1)
+	assert.Empty(t, sessions)
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	login(t, handler, "user1@example.com", "laptop")
+	phone := login(t, handler, "user1@example.com", "phone")
+	login(t, handler, "user2@example.com", "tablet")
+
+	req := withUser(httptest.NewRequest("GET", "/sessions", nil), 1)
+	w := httptest.NewRecorder()
+	handler.GetSessions(w, req)
+
+	var sessions []*db.Session
+	json.NewDecoder(w.Body).Decode(&sessions)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, "test-agent", sessions[0].UserAgent)
+
+	// user 2's session is not user 1's to revoke
+	req = mux.SetURLVars(withUser(httptest.NewRequest("DELETE", "/sessions/3", nil), 1), map[string]string{"id": "3"})
+	w = httptest.NewRecorder()
+	handler.DeleteSession(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req = mux.SetURLVars(withUser(httptest.NewRequest("DELETE", "/sessions/2", nil), 1), map[string]string{"id": "2"})
+	w = httptest.NewRecorder()
+	handler.DeleteSession(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = refresh(handler, phone.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	sessions, _ = handler.DB.GetSessionsByUserID(1)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "laptop", sessions[0].DeviceLabel)
+}
+
+func TestLoginRefusesInactiveUser(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	user, _ := handler.DB.GetUserByID(2)
+	user.Status = "banned"
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email": "user2@example.com", "password": "password123"}`))
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	// the answer is the same as for a wrong password
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, problem.CodeInvalidCredentials, problemCode(w))
+	sessions, _ := handler.DB.GetSessionsByUserID(2)
+	assert.Empty(t, sessions)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// maxTransactionLimit caps the page size of GET /wallets/transactions
+const maxTransactionLimit = 200
+
+// TransactionPage is a page of a wallet's transaction history. NextCursor is
+// passed as cursor to get the next page and is left out on the last one.
+type TransactionPage struct {
+	Transactions []*db.Transaction `json:"transactions"`
+	NextCursor   string            `json:"next_cursor,omitempty"`
+}
+
+// transactionCursor is the JSON form of a db.TransactionCursor. Clients get
+// it base64 encoded and treat it as opaque.
+type transactionCursor struct {
+	SortBy    string    `json:"s"`
+	Ascending bool      `json:"a,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	Amount    int64     `json:"m"`
+	Currency  string    `json:"c"`
+	ID        int64     `json:"i"`
+}
+
+func encodeCursor(c *db.TransactionCursor) string {
+	b, _ := json.Marshal(transactionCursor{
+		SortBy:    c.SortBy,
+		Ascending: c.Ascending,
+		CreatedAt: c.CreatedAt,
+		Amount:    c.Amount.Amount(),
+		Currency:  c.Amount.Currency(),
+		ID:        c.ID,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*db.TransactionCursor, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+
+	var c transactionCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, false
+	}
+
+	amount, err := money.New(c.Amount, c.Currency)
+	if err != nil {
+		return nil, false
+	}
+
+	return &db.TransactionCursor{SortBy: c.SortBy, Ascending: c.Ascending, CreatedAt: c.CreatedAt, Amount: amount, ID: c.ID}, true
+}
+
+// parseTime accepts an RFC 3339 time or a date. A date as the end of a range
+// includes the whole day.
+func parseTime(s string, end bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, true
+}
+
+// oneOf checks that every comma separated value of a query parameter is
+// allowed and returns the values
+func oneOf(v *validator, field, value string, allowed []string) []string {
+	if value == "" {
+		return nil
+	}
+
+	values := strings.Split(value, ",")
+	for _, value := range values {
+		found := false
+		for _, a := range allowed {
+			found = found || value == a
+		}
+		if !found {
+			v.add(field, "must be one of "+strings.Join(allowed, ", "))
+			break
+		}
+	}
+
+	return values
+}
+
+// transactionQuery reads the filters, order and page of a history request
+// for wallet. Amounts are in the wallet's currency.
+func transactionQuery(params url.Values, wallet *db.Wallet) (db.TransactionQuery, error) {
+	q := db.TransactionQuery{WalletID: wallet.ID, Limit: db.DefaultTransactionLimit}
+	v := &validator{}
+
+	if s := params.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		v.check(err == nil && n > 0 && n <= maxTransactionLimit, "limit", "must be between 1 and "+strconv.Itoa(maxTransactionLimit))
+		q.Limit = n
+	}
+
+	q.Types = oneOf(v, "type", params.Get("type"), db.TransactionTypes)
+	q.Statuses = oneOf(v, "status", params.Get("status"), db.TransactionStatuses)
+
+	for _, p := range []struct {
+		field string
+		end   bool
+		dest  **time.Time
+	}{
+		{"from", false, &q.Since},
+		{"to", true, &q.Until},
+	} {
+		if s := params.Get(p.field); s != "" {
+			t, ok := parseTime(s, p.end)
+			v.check(ok, p.field, "must be a date or an RFC 3339 time")
+			*p.dest = &t
+		}
+	}
+
+	for _, p := range []struct {
+		field string
+		dest  **money.Money
+	}{
+		{"min_amount", &q.MinAmount},
+		{"max_amount", &q.MaxAmount},
+	} {
+		if s := params.Get(p.field); s != "" {
+			amount, err := money.Parse(s, wallet.Currency)
+			v.check(err == nil, p.field, "is not a valid amount in "+wallet.Currency)
+			*p.dest = &amount
+		}
+	}
+
+	switch params.Get("sort") {
+	case "", db.TransactionSortCreatedAt:
+		q.SortBy = db.TransactionSortCreatedAt
+	case db.TransactionSortAmount:
+		q.SortBy = db.TransactionSortAmount
+	default:
+		v.add("sort", "must be one of created_at, amount")
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		v.add("order", "must be one of asc, desc")
+	}
+
+	if s := params.Get("cursor"); s != "" {
+		cursor, ok := decodeCursor(s)
+		v.check(ok, "cursor", "is not a valid cursor")
+		if ok {
+			v.check(cursor.SortBy == q.SortBy && cursor.Ascending == q.Ascending, "cursor", "was returned for a different sort or order")
+		}
+		q.After = cursor
+	}
+
+	return q, v.err()
+}
+
+// Transactions handles GET /wallets/transactions. It returns a page of the
+// history of wallet_id, newest first, with transactions sent and received.
+// See transactionQuery for the filters.
+func (h *WalletHandler) Transactions(w http.ResponseWriter, r *http.Request) {
+	walletIDParam := r.URL.Query().Get("wallet_id")
+	if walletIDParam == "" {
+		respondError(w, http.StatusBadRequest, "Missing wallet_id query parameter")
+		return
+	}
+
+	walletID, err := strconv.ParseInt(walletIDParam, 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid wallet_id query parameter")
+		return
+	}
+
+	if _, err := callerID(r, 0); err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	wallet, err := h.DB.GetWalletByID(walletID)
+	if err != nil {
+		respondFailure(w, err, "Failed to get wallet")
+		return
+	}
+
+	if err := authorizeUser(r, wallet.UserID, utils.PermReadWallets); err != nil {
+		respondError(w, accessStatus(err), "Wallet does not belong to you")
+		return
+	}
+
+	q, err := transactionQuery(r.URL.Query(), wallet)
+	if err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	transactions, next, err := h.DB.GetTransactions(q)
+	if err != nil {
+		respondFailure(w, err, "Failed to get transactions")
+		return
+	}
+
+	page := TransactionPage{Transactions: transactions}
+	if page.Transactions == nil {
+		page.Transactions = []*db.Transaction{}
+	}
+	if next != nil {
+		page.NextCursor = encodeCursor(next)
+	}
+
+	respondJSON(w, http.StatusOK, page)
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
 )
 
 type UserDBInterface interface {
@@ -16,40 +20,132 @@ type UserDBInterface interface {
 	CreateUser(user *db.User) error
 	UpdateUser(user *db.User) error
 	DeleteUser(id int64) error
-	CreateRefreshToken(refreshToken *db.RefreshToken) error
-	GetRefreshToken(userID int64) (*db.RefreshToken, error)
-	DeleteRefreshToken(userID int64) error
+	CreateSession(session *db.Session, refreshToken *db.RefreshToken) error
+	RotateRefreshToken(oldToken string, next *db.RefreshToken) (*db.Session, error)
+	GetSessionsByUserID(userID int64) ([]*db.Session, error)
+	RevokeSession(userID, sessionID int64) error
+	RevokeSessionByRefreshToken(userID int64, token string) error
+	RevokeUserSessions(userID int64) error
+	StartTOTPEnrolment(userID int64, secret string) error
+	ConfirmTOTPEnrolment(userID int64, code string, recoveryCodeHashes []string) error
+	DisableTOTP(userID int64, code string) error
+	CreateMFAChallenge(c *db.MFAChallenge) error
+	CompleteMFAChallenge(token, code, recoveryCodeHash string) (*db.MFAChallenge, error)
+	ChangePassword(userID int64, current, next string) error
+	CreatePasswordReset(userID int64, tokenHash string, expiresAt time.Time) error
+	ResetPassword(tokenHash, password string) (int64, error)
+	CreateVerificationCode(userID int64, channel, codeHash string, expiresAt time.Time, cooldown time.Duration) (string, time.Duration, error)
+	VerifyContact(userID int64, channel, codeHash string) error
+	ReserveLoginAttempt(counters ...db.LoginCounter) (time.Duration, error)
+	ClearLoginFailures(subject, key string) error
+	ReleaseLoginAttempt(subject, key string) error
+	UnlockLogin(subject, key string, actorID int64) error
+	GetLockoutEvents(limit int) ([]*db.LockoutEvent, error)
 }
 
 type UserHandler struct {
 	DB UserDBInterface
+
+	// Notifier delivers password reset tokens and verification codes
+	Notifier notify.Notifier
+
+	// LoginPolicy throttles failed logins; the zero value counts failures
+	// without ever delaying or locking out
+	LoginPolicy LoginPolicy
+
+	// ResetPolicy limits password reset requests
+	ResetPolicy ResetPolicy
+
+	// resets tracks password resets still being sent
+	resets sync.WaitGroup
 }
 
 func NewUserHandler(db *db.DB) *UserHandler {
-	return &UserHandler{DB: db}
+	return &UserHandler{DB: db, LoginPolicy: DefaultLoginPolicy, ResetPolicy: DefaultResetPolicy}
 }
 
 func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := h.DB.GetAllUsers()
 	if err != nil {
-		http.Error(w, "failed to get users: "+err.Error(), http.StatusInternalServerError)
+		respondFailure(w, err, "Failed to get users")
 		return
 	}
 
 	json.NewEncoder(w).Encode(users)
 }
 
-func (h *UserHandler) CreateUsers(w http.ResponseWriter, r *http.Request) {
-	var user db.User
+// CreateUserRequest signs up a new user. The ID, status, role, password
+// hash and timestamps are set by the server.
+type CreateUserRequest struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+}
+
+func (req *CreateUserRequest) validate(v *validator) {
+	validateName(v, "first_name", req.FirstName)
+	validateName(v, "last_name", req.LastName)
+	validatePhone(v, "phone_number", req.PhoneNumber)
+	validateEmail(v, "email", req.Email)
+	validatePassword(v, "password", req.Password)
+}
+
+// UpdateUserRequest changes the fields it carries and leaves the others as
+// they are. Status and role may only be changed by admins.
+type UpdateUserRequest struct {
+	FirstName   *string `json:"first_name"`
+	LastName    *string `json:"last_name"`
+	PhoneNumber *string `json:"phone_number"`
+	Email       *string `json:"email"`
+	Status      *string `json:"status"`
+	Role        *string `json:"role"`
+}
+
+func (req *UpdateUserRequest) validate(v *validator) {
+	if req.FirstName != nil {
+		validateName(v, "first_name", *req.FirstName)
+	}
+	if req.LastName != nil {
+		validateName(v, "last_name", *req.LastName)
+	}
+	if req.PhoneNumber != nil {
+		validatePhone(v, "phone_number", *req.PhoneNumber)
+	}
+	if req.Email != nil {
+		validateEmail(v, "email", *req.Email)
+	}
+	if req.Status != nil {
+		validateStatus(v, "status", *req.Status)
+	}
+	if req.Role != nil {
+		v.check(utils.ValidRole(*req.Role), "role", "must be one of customer, support, admin")
+	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusInternalServerError)
+func (h *UserHandler) CreateUsers(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
+	// sign-ups are always active customers; admins grant other roles and
+	// ban users via UpdateUser
+	user := db.User{
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		PhoneNumber: req.PhoneNumber,
+		Email:       req.Email,
+		Password:    req.Password,
+		Status:      "active",
+		Role:        utils.RoleCustomer,
+	}
+
 	err := h.DB.CreateUser(&user)
 	if err != nil {
-		http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+		respondFailure(w, err, "Failed to create user")
 		return
 	}
 
@@ -64,17 +160,18 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(userIdStr, 10, 64)
 
 	if err != nil {
-		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return
 	}
 
-	user, err := h.DB.GetUserByID(id)
-	if err != nil {
-		http.Error(w, "Failed to get user: "+err.Error(), http.StatusInternalServerError)
+	if err := authorizeUser(r, id, utils.PermReadUsers); err != nil {
+		respondError(w, accessStatus(err), "Not allowed to access this user")
 		return
 	}
 
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	user, err := h.DB.GetUserByID(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get user")
 		return
 	}
 
@@ -86,22 +183,69 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	userIdStr := vars["id"]
 	id, err := strconv.ParseInt(userIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	if err := authorizeUser(r, id, utils.PermManageUsers); err != nil {
+		respondError(w, accessStatus(err), "Not allowed to update this user")
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	existing, err := h.DB.GetUserByID(id)
+	if err != nil {
+		respondFailure(w, err, "Failed to get user")
+		return
+	}
+
+	user := *existing
+	for _, field := range []struct {
+		value *string
+		dest  *string
+	}{
+		{req.FirstName, &user.FirstName},
+		{req.LastName, &user.LastName},
+		{req.PhoneNumber, &user.PhoneNumber},
+		{req.Email, &user.Email},
+		{req.Status, &user.Status},
+		{req.Role, &user.Role},
+	} {
+		if field.value != nil {
+			*field.dest = *field.value
+		}
 	}
 
-	var user db.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	// banning and role changes are reserved for admins
+	if (user.Status != existing.Status || user.Role != existing.Role) && !utils.HasPermission(utils.RoleFromContext(r.Context()), utils.PermManageUsers) {
+		respondError(w, http.StatusForbidden, "Only admins can change status or role")
 		return
 	}
 
-	user.ID = id
 	err = h.DB.UpdateUser(&user)
 	if err != nil {
-		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
+		respondFailure(w, err, "Failed to update user")
 		return
 	}
 
+	// access tokens carry the role they were issued with, so a role change
+	// ends the user's sessions and the new role applies from the next login
+	if user.Role != existing.Role {
+		if err := h.DB.RevokeUserSessions(id); err != nil {
+			respondFailure(w, err, "Failed to revoke sessions")
+			return
+		}
+	}
+
+	// a ban, deactivation or role change must lock out tokens that are
+	// already issued
+	utils.ForgetTokenState(id)
+
 	json.NewEncoder(w).Encode(user)
 }
 
@@ -110,14 +254,17 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	userIdStr := vars["id"]
 	id, err := strconv.ParseInt(userIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return
 	}
 
 	err = h.DB.DeleteUser(id)
 	if err != nil {
-		http.Error(w, "Failed to delete user: "+err.Error(), http.StatusInternalServerError)
+		respondFailure(w, err, "Failed to delete user")
 		return
 	}
 
+	utils.ForgetTokenState(id)
+
 	w.WriteHeader(http.StatusNoContent)
 }
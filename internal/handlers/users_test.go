@@ -2,15 +2,48 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/totp"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type mockDB struct {
-	users []*db.User
+	users    []*db.User
+	sessions []*db.Session
+	tokens   map[string]*db.RefreshToken
+
+	totpSecrets   map[int64]string
+	recoveryCodes map[int64][]string
+	challenges    map[string]*db.MFAChallenge
+	resets        map[string]*passwordReset
+	verifications map[string]string // code hash by "user_id/channel"
+
+	verificationsSent map[string]time.Time // when the code was sent, by "user_id/channel"
+
+	mockLockout
+}
+
+// mockLockout keeps the failure counters of logins and TOTP codes for the
+// handler mocks
+type mockLockout struct {
+	lockoutMu     sync.Mutex                   // guards the counters of parallel requests
+	loginFailures map[string]*db.LoginFailures // by "subject/key"
+	lockoutEvents []*db.LockoutEvent
+}
+
+type passwordReset struct {
+	userID    int64
+	expiresAt time.Time
 }
 
 func (m *mockDB) GetAllUsers() ([]*db.User, error) {
@@ -19,11 +52,11 @@ func (m *mockDB) GetAllUsers() ([]*db.User, error) {
 
 func (m *mockDB) GetUserByEmail(email string) (*db.User, error) {
 	for _, u := range m.users {
-		if u.Email == email {
+		if strings.EqualFold(u.Email, email) {
 			return u, nil
 		}
 	}
-	return nil, nil
+	return nil, db.ErrUserNotFound
 }
 
 func (m *mockDB) CreateUser(user *db.User) error {
@@ -37,17 +70,19 @@ func (m *mockDB) GetUserByID(id int64) (*db.User, error) {
 			return u, nil
 		}
 	}
-	return nil, nil
+	return nil, db.ErrUserNotFound
 }
 
 func (m *mockDB) UpdateUser(user *db.User) error {
 	for i, u := range m.users {
 		if u.ID == user.ID {
+			user.EmailVerified = user.EmailVerified && user.Email == u.Email
+			user.PhoneVerified = user.PhoneVerified && user.PhoneNumber == u.PhoneNumber
 			m.users[i] = user
 			return nil
 		}
 	}
-	return nil
+	return db.ErrUserNotFound
 }
 
 func (m *mockDB) DeleteUser(id int64) error {
@@ -57,19 +92,359 @@ func (m *mockDB) DeleteUser(id int64) error {
 			return nil
 		}
 	}
+	return db.ErrUserNotFound
+}
+
+func (m *mockDB) CreateSession(session *db.Session, rt *db.RefreshToken) error {
+	session.ID = int64(len(m.sessions) + 1)
+	session.CreatedAt, session.LastUsedAt = time.Now(), time.Now()
+	m.sessions = append(m.sessions, session)
+
+	rt.UserID, rt.SessionID = session.UserID, session.ID
+	return m.insertToken(rt)
+}
+
+func (m *mockDB) insertToken(rt *db.RefreshToken) error {
+	if m.tokens == nil {
+		m.tokens = make(map[string]*db.RefreshToken)
+	}
+	m.tokens[rt.Token] = rt
+	return nil
+}
+
+func (m *mockDB) session(id int64) *db.Session {
+	for _, s := range m.sessions {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *mockDB) SessionActive(userID, sessionID int64) (bool, error) {
+	user, err := m.GetUserByID(userID)
+	if err != nil {
+		return false, nil
+	}
+
+	s := m.session(sessionID)
+	return user.Status == "active" && (sessionID == 0 || (s != nil && s.UserID == userID && s.RevokedAt == nil)), nil
+}
+
+func (m *mockDB) RotateRefreshToken(oldToken string, next *db.RefreshToken) (*db.Session, error) {
+	rt, ok := m.tokens[oldToken]
+	if !ok {
+		return nil, db.ErrRefreshTokenInvalid
+	}
+
+	s := m.session(rt.SessionID)
+	if s.RevokedAt != nil {
+		return nil, db.ErrRefreshTokenInvalid
+	}
+	if rt.UsedAt != nil {
+		now := time.Now()
+		s.RevokedAt = &now
+		return nil, db.ErrRefreshTokenReused
+	}
+	if rt.ExpiresAt.Before(time.Now()) {
+		return nil, db.ErrRefreshTokenInvalid
+	}
+
+	now := time.Now()
+	rt.UsedAt = &now
+	next.UserID, next.SessionID = s.UserID, s.ID
+	return s, m.insertToken(next)
+}
+
+func (m *mockDB) GetSessionsByUserID(userID int64) ([]*db.Session, error) {
+	var sessions []*db.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *mockDB) RevokeSession(userID, sessionID int64) error {
+	s := m.session(sessionID)
+	if s == nil || s.UserID != userID || s.RevokedAt != nil {
+		return db.ErrSessionNotFound
+	}
+
+	now := time.Now()
+	s.RevokedAt = &now
+	return nil
+}
+
+func (m *mockDB) RevokeSessionByRefreshToken(userID int64, token string) error {
+	rt, ok := m.tokens[token]
+	if !ok {
+		return db.ErrSessionNotFound
+	}
+	return m.RevokeSession(userID, rt.SessionID)
+}
+
+func (m *mockDB) RevokeUserSessions(userID int64) error {
+	now := time.Now()
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockDB) StartTOTPEnrolment(userID int64, secret string) error {
+	user, _ := m.GetUserByID(userID)
+	if user.TOTPEnabled {
+		return db.ErrTOTPAlreadyEnabled
+	}
+
+	if m.totpSecrets == nil {
+		m.totpSecrets = make(map[int64]string)
+	}
+	m.totpSecrets[userID] = secret
+	return nil
+}
+
+func (m *mockDB) checkTOTP(userID int64, code string, enabled bool) error {
+	user, _ := m.GetUserByID(userID)
+	secret, ok := m.totpSecrets[userID]
+	switch {
+	case !ok || (enabled && !user.TOTPEnabled):
+		return db.ErrTOTPNotEnrolled
+	case !enabled && user.TOTPEnabled:
+		return db.ErrTOTPAlreadyEnabled
+	}
+
+	if _, ok := totp.Validate(secret, code, time.Now()); !ok {
+		return db.ErrInvalidTOTPCode
+	}
 	return nil
 }
 
-func (m *mockDB) CreateRefreshToken(rt *db.RefreshToken) error {
+func (m *mockDB) ConfirmTOTPEnrolment(userID int64, code string, hashes []string) error {
+	if err := m.checkTOTP(userID, code, false); err != nil {
+		return err
+	}
+
+	user, _ := m.GetUserByID(userID)
+	user.TOTPEnabled = true
+	if m.recoveryCodes == nil {
+		m.recoveryCodes = make(map[int64][]string)
+	}
+	m.recoveryCodes[userID] = hashes
 	return nil
 }
 
-func (m *mockDB) DeleteRefreshToken(userID int64) error {
+func (m *mockDB) DisableTOTP(userID int64, code string) error {
+	if err := m.checkTOTP(userID, code, true); err != nil {
+		return err
+	}
+
+	user, _ := m.GetUserByID(userID)
+	user.TOTPEnabled = false
+	delete(m.totpSecrets, userID)
+	delete(m.recoveryCodes, userID)
 	return nil
 }
 
-func (m *mockDB) GetRefreshToken(userID int64) (*db.RefreshToken, error) {
-	return nil, nil
+func (m *mockDB) CreateMFAChallenge(c *db.MFAChallenge) error {
+	if m.challenges == nil {
+		m.challenges = make(map[string]*db.MFAChallenge)
+	}
+	m.challenges[c.Token] = c
+	return nil
+}
+
+func (m *mockDB) CompleteMFAChallenge(token, code, recoveryCodeHash string) (*db.MFAChallenge, error) {
+	c, ok := m.challenges[token]
+	if !ok || c.ExpiresAt.Before(time.Now()) {
+		return nil, db.ErrMFAChallengeInvalid
+	}
+
+	err := db.ErrInvalidTOTPCode
+	if recoveryCodeHash != "" {
+		for i, hash := range m.recoveryCodes[c.UserID] {
+			if hash == recoveryCodeHash {
+				codes := m.recoveryCodes[c.UserID]
+				m.recoveryCodes[c.UserID] = append(codes[:i], codes[i+1:]...)
+				err = nil
+				break
+			}
+		}
+	} else {
+		err = m.checkTOTP(c.UserID, code, true)
+	}
+
+	if err != nil {
+		c.Attempts++
+		if c.Attempts >= db.MaxMFAAttempts {
+			delete(m.challenges, token)
+		}
+		return nil, err
+	}
+
+	delete(m.challenges, token)
+	return c, nil
+}
+
+func (m *mockDB) ChangePassword(userID int64, current, next string) error {
+	user, _ := m.GetUserByID(userID)
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return db.ErrWrongPassword
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte(next), bcrypt.MinCost)
+	user.PasswordHash = string(hash)
+	return nil
+}
+
+func (m *mockDB) CreatePasswordReset(userID int64, tokenHash string, expiresAt time.Time) error {
+	if m.resets == nil {
+		m.resets = make(map[string]*passwordReset)
+	}
+	m.resets[tokenHash] = &passwordReset{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *mockDB) ResetPassword(tokenHash, password string) (int64, error) {
+	reset, ok := m.resets[tokenHash]
+	if !ok || reset.expiresAt.Before(time.Now()) {
+		return 0, db.ErrResetTokenInvalid
+	}
+	delete(m.resets, tokenHash)
+
+	user, _ := m.GetUserByID(reset.userID)
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	user.PasswordHash = string(hash)
+	return reset.userID, m.RevokeUserSessions(reset.userID)
+}
+
+func (m *mockDB) CreateVerificationCode(userID int64, channel, codeHash string, expiresAt time.Time, cooldown time.Duration) (string, time.Duration, error) {
+	user, _ := m.GetUserByID(userID)
+	if m.verifications == nil {
+		m.verifications = make(map[string]string)
+		m.verificationsSent = make(map[string]time.Time)
+	}
+
+	key := fmt.Sprintf("%d/%s", userID, channel)
+	if sent, ok := m.verificationsSent[key]; ok && time.Since(sent) < cooldown {
+		return "", cooldown - time.Since(sent), nil
+	}
+
+	switch channel {
+	case db.ContactEmail:
+		m.verifications[key], m.verificationsSent[key] = codeHash, time.Now()
+		return user.Email, 0, nil
+	case db.ContactPhone:
+		m.verifications[key], m.verificationsSent[key] = codeHash, time.Now()
+		return user.PhoneNumber, 0, nil
+	}
+	return "", 0, db.ErrUnknownContact
+}
+
+func (m *mockDB) VerifyContact(userID int64, channel, codeHash string) error {
+	key := fmt.Sprintf("%d/%s", userID, channel)
+	if stored, ok := m.verifications[key]; !ok || stored != codeHash {
+		return db.ErrVerificationCodeInvalid
+	}
+	delete(m.verifications, key)
+
+	user, _ := m.GetUserByID(userID)
+	if channel == db.ContactEmail {
+		user.EmailVerified = true
+	} else {
+		user.PhoneVerified = true
+	}
+	return nil
+}
+
+func (m *mockLockout) ReserveLoginAttempt(counters ...db.LoginCounter) (time.Duration, error) {
+	m.lockoutMu.Lock()
+	defer m.lockoutMu.Unlock()
+
+	if m.loginFailures == nil {
+		m.loginFailures = make(map[string]*db.LoginFailures)
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, c := range counters {
+		f, ok := m.loginFailures[c.Subject+"/"+c.Key]
+		if !ok || f.LastFailureAt.Before(now.Add(-c.Policy.Window)) || (f.LockedUntil != nil && !f.LockedUntil.After(now)) {
+			f = &db.LoginFailures{Subject: c.Subject, Key: c.Key, LastFailureAt: now}
+			m.loginFailures[c.Subject+"/"+c.Key] = f
+		}
+
+		if f.LockedUntil == nil && c.Policy.MaxFailures > 0 && f.Failures >= c.Policy.MaxFailures {
+			lockedUntil := now.Add(c.Policy.Lockout)
+			f.LockedUntil = &lockedUntil
+			m.lockoutEvents = append(m.lockoutEvents, &db.LockoutEvent{Subject: c.Subject, Key: c.Key, Event: db.LockoutEventLocked, Failures: f.Failures, LockedUntil: f.LockedUntil})
+		}
+
+		ready := f.LastFailureAt
+		if c.Delay != nil {
+			ready = ready.Add(c.Delay(f.Failures))
+		}
+		if f.LockedUntil != nil && f.LockedUntil.After(ready) {
+			ready = *f.LockedUntil
+		}
+		if ready.Sub(now) > wait {
+			wait = ready.Sub(now)
+		}
+	}
+
+	if wait > 0 {
+		return wait, nil
+	}
+
+	for _, c := range counters {
+		f := m.loginFailures[c.Subject+"/"+c.Key]
+		f.Failures++
+		f.LastFailureAt = now
+	}
+	return 0, nil
+}
+
+func (m *mockLockout) ReleaseLoginAttempt(subject, key string) error {
+	m.lockoutMu.Lock()
+	defer m.lockoutMu.Unlock()
+
+	if f, ok := m.loginFailures[subject+"/"+key]; ok && f.LockedUntil == nil && f.Failures > 0 {
+		f.Failures--
+	}
+	return nil
+}
+
+func (m *mockLockout) ClearLoginFailures(subject, key string) error {
+	m.lockoutMu.Lock()
+	defer m.lockoutMu.Unlock()
+
+	if f, ok := m.loginFailures[subject+"/"+key]; ok && f.LockedUntil == nil {
+		delete(m.loginFailures, subject+"/"+key)
+	}
+	return nil
+}
+
+func (m *mockLockout) UnlockLogin(subject, key string, actorID int64) error {
+	f, ok := m.loginFailures[subject+"/"+key]
+	if !ok {
+		return nil
+	}
+
+	delete(m.loginFailures, subject+"/"+key)
+	m.lockoutEvents = append(m.lockoutEvents, &db.LockoutEvent{Subject: subject, Key: key, Event: db.LockoutEventUnlocked, Failures: f.Failures, ActorID: &actorID})
+	return nil
+}
+
+func (m *mockLockout) GetLockoutEvents(limit int) ([]*db.LockoutEvent, error) {
+	var events []*db.LockoutEvent
+	for i := len(m.lockoutEvents) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, m.lockoutEvents[i])
+	}
+	return events, nil
 }
 
 func TestGetUsers(t *testing.T) {
@@ -102,3 +477,130 @@ func TestGetUsers(t *testing.T) {
 		t.Fatalf("expected %d users, got %d", len(mockUsers), len(users))
 	}
 }
+
+func TestUserOwnershipIsEnforced(t *testing.T) {
+	mockUsers := []*db.User{
+		{ID: 1, FirstName: "User1", LastName: "Test", Email: "user1@example.com"},
+		{ID: 2, FirstName: "User2", LastName: "Test", Email: "user2@example.com"},
+	}
+	handler := &UserHandler{DB: &mockDB{users: mockUsers}}
+
+	handlers := map[string]http.HandlerFunc{
+		"GET":    handler.GetUser,
+		"PUT":    handler.UpdateUser,
+		"DELETE": utils.RequirePermission(utils.PermManageUsers, handler.DeleteUser),
+	}
+
+	for method, h := range handlers {
+		req := httptest.NewRequest(method, "/users/1", strings.NewReader(`{"first_name": "Mallory"}`))
+		req = mux.SetURLVars(withUser(req, 2), map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		h(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s /users/1: expected status 403, got %d", method, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/users/2", nil)
+	req = mux.SetURLVars(withUser(req, 2), map[string]string{"id": "2"})
+	w := httptest.NewRecorder()
+	handler.GetUser(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 OK for own user, got %d", w.Code)
+	}
+
+	req = withUser(httptest.NewRequest("POST", "/logout", strings.NewReader(`{"user_id": 1}`)), 2)
+	w = httptest.NewRecorder()
+	handler.Logout(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("logout of another user: expected status 403, got %d", w.Code)
+	}
+}
+
+// withRole marks req as authenticated for userID with role
+func withRole(req *http.Request, userID int64, role string) *http.Request {
+	ctx := utils.ContextWithRole(utils.ContextWithUserID(req.Context(), userID), role)
+	return req.WithContext(ctx)
+}
+
+func TestRolePermissions(t *testing.T) {
+	mockUsers := []*db.User{
+		{ID: 1, FirstName: "Customer", Email: "customer@example.com", Status: "active", Role: utils.RoleCustomer},
+		{ID: 2, FirstName: "Support", Email: "support@example.com", Status: "active", Role: utils.RoleSupport},
+		{ID: 3, FirstName: "Admin", Email: "admin@example.com", Status: "active", Role: utils.RoleAdmin},
+	}
+	handler := &UserHandler{DB: &mockDB{users: mockUsers}}
+
+	send := func(h http.HandlerFunc, method string, userID int64, role string, targetID, body string) int {
+		req := httptest.NewRequest(method, "/users/"+targetID, strings.NewReader(body))
+		req = mux.SetURLVars(withRole(req, userID, role), map[string]string{"id": targetID})
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w.Code
+	}
+
+	listUsers := utils.RequirePermission(utils.PermListUsers, handler.GetUsers)
+
+	// customers cannot ban themselves or list users
+	if code := send(handler.UpdateUser, "PUT", 1, utils.RoleCustomer, "1", `{"first_name": "Customer", "status": "banned"}`); code != http.StatusForbidden {
+		t.Fatalf("customer banning self: expected 403, got %d", code)
+	}
+	if code := send(listUsers, "GET", 1, utils.RoleCustomer, "", ""); code != http.StatusForbidden {
+		t.Fatalf("customer listing users: expected 403, got %d", code)
+	}
+
+	// support can read other users but not edit them
+	if code := send(handler.GetUser, "GET", 2, utils.RoleSupport, "1", ""); code != http.StatusOK {
+		t.Fatalf("support reading a user: expected 200, got %d", code)
+	}
+	if code := send(handler.UpdateUser, "PUT", 2, utils.RoleSupport, "1", `{"status": "banned"}`); code != http.StatusForbidden {
+		t.Fatalf("support banning a user: expected 403, got %d", code)
+	}
+
+	// admins can list and ban
+	if code := send(listUsers, "GET", 3, utils.RoleAdmin, "", ""); code != http.StatusOK {
+		t.Fatalf("admin listing users: expected 200, got %d", code)
+	}
+	if code := send(handler.UpdateUser, "PUT", 3, utils.RoleAdmin, "1", `{"first_name": "Customer", "status": "banned"}`); code != http.StatusOK {
+		t.Fatalf("admin banning a user: expected 200, got %d", code)
+	}
+	if mockUsers[0].Status != "banned" {
+		t.Fatalf("expected user 1 to be banned, got %q", mockUsers[0].Status)
+	}
+}
+
+func TestDemotedAdminIsRefusedOnTheNextRequest(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	admin, _ := handler.DB.GetUserByID(1)
+	admin.Role = utils.RoleAdmin
+
+	utils.SetTokenStateCache(utils.NewTokenStateCache(handler.DB.(*mockDB), time.Minute))
+	defer utils.SetTokenStateCache(nil)
+
+	token := login(t, handler, "user1@example.com", "laptop").AccessToken
+	listUsers := func() int {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		utils.JWTMiddleware(utils.RequirePermission(utils.PermListUsers, handler.GetUsers))(w, req)
+		return w.Code
+	}
+	if code := listUsers(); code != http.StatusOK {
+		t.Fatalf("admin listing users: expected 200, got %d", code)
+	}
+
+	// another admin takes the role away while the token is still valid
+	req := withRole(httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"role": "customer"}`)), 2, utils.RoleAdmin)
+	w := httptest.NewRecorder()
+	handler.UpdateUser(w, mux.SetURLVars(req, map[string]string{"id": "1"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("demoting the admin: expected 200, got %d", w.Code)
+	}
+
+	if code := listUsers(); code != http.StatusUnauthorized {
+		t.Fatalf("demoted admin listing users: expected 401, got %d", code)
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+)
+
+// FieldError is one invalid field of a request
+type FieldError = problem.Field
+
+// ValidationError lists every invalid field of a request. Payloads that are
+// not valid JSON, have fields of the wrong type or fields the endpoint does
+// not accept answer 400; well-formed payloads with invalid values answer 422.
+type ValidationError struct {
+	Status int
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + " " + f.Message
+	}
+
+	return "invalid request: " + strings.Join(parts, ", ")
+}
+
+// invalidField reports a single field that failed a check made outside of
+// a request's validate method, e.g. one that needs the wallet's currency
+func invalidField(field, message string) error {
+	return &ValidationError{Status: http.StatusUnprocessableEntity, Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+// validatable is implemented by every request payload. validate records each
+// invalid field in v.
+type validatable interface {
+	validate(v *validator)
+}
+
+// validator collects the field errors of a request
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: message})
+}
+
+// check records message for field unless ok
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.add(field, message)
+	}
+}
+
+// required records a missing value and reports whether it is present
+func (v *validator) required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+		return false
+	}
+
+	return true
+}
+
+// maxLength checks that value fits a VARCHAR(n) column
+func (v *validator) maxLength(field, value string, n int) {
+	v.check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must be at most %d characters", n))
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Status: http.StatusUnprocessableEntity, Fields: v.fields}
+}
+
+// decodeRequest decodes the JSON body of r into req and validates it. An
+// empty body decodes as an empty payload, so required fields are reported
+// rather than the body.
+func decodeRequest(r *http.Request, req validatable) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		return malformedRequest(err)
+	}
+
+	v := &validator{}
+	req.validate(v)
+	return v.err()
+}
+
+// malformedRequest names the field a JSON decoding error is about, when it
+// is about one
+func malformedRequest(err error) *ValidationError {
+	e := &ValidationError{Status: http.StatusBadRequest}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		e.Fields = []FieldError{{Field: typeErr.Field, Message: "must be of type " + jsonType(typeErr.Type.Kind().String())}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		e.Fields = []FieldError{{Field: field, Message: "is not accepted"}}
+	}
+
+	return e
+}
+
+// jsonType names a Go kind the way API clients know it
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice":
+		return "array"
+	case kind == "map", kind == "struct":
+		return "object"
+	}
+
+	return kind
+}
+
+// respondInvalid answers a request that failed decodeRequest, or a check
+// reported with invalidField
+func respondInvalid(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		verr = &ValidationError{Status: http.StatusBadRequest}
+	}
+
+	p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Validation failed")
+	if verr.Status == http.StatusBadRequest {
+		p = problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+	}
+
+	p.Fields = verr.Fields
+	p.Write(w)
+}
+
+// Limits of the users table
+const (
+	maxNameLength  = 20
+	maxPhoneLength = 20
+	maxEmailLength = 100
+
+	// bcrypt ignores everything after the first 72 bytes
+	maxPasswordBytes = 72
+)
+
+// userStatuses are the values of users.status
+var userStatuses = []string{"active", "inactive", "banned"}
+
+var (
+	phonePattern   = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+	decimalPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+)
+
+func validateName(v *validator, field, value string) {
+	if v.required(field, value) {
+		v.maxLength(field, value, maxNameLength)
+	}
+}
+
+func validateEmail(v *validator, field, value string) {
+	if !v.required(field, value) {
+		return
+	}
+
+	v.maxLength(field, value, maxEmailLength)
+	addr, err := mail.ParseAddress(value)
+	v.check(err == nil && addr.Address == value, field, "must be a valid email address")
+}
+
+func validatePhone(v *validator, field, value string) {
+	if v.required(field, value) {
+		v.check(phonePattern.MatchString(value), field, "must be 6 to 15 digits with an optional leading +")
+	}
+}
+
+// validatePassword asks for at least minPasswordLength characters from two
+// of the classes letters, digits and symbols
+func validatePassword(v *validator, field, value string) {
+	if !v.required(field, value) {
+		return
+	}
+
+	if utf8.RuneCountInString(value) < minPasswordLength {
+		v.add(field, fmt.Sprintf("must be at least %d characters", minPasswordLength))
+		return
+	}
+	if len(value) > maxPasswordBytes {
+		v.add(field, fmt.Sprintf("must be at most %d bytes", maxPasswordBytes))
+		return
+	}
+
+	var letters, digits, symbols int
+	for _, r := range value {
+		switch {
+		case unicode.IsLetter(r):
+			letters = 1
+		case unicode.IsDigit(r):
+			digits = 1
+		default:
+			symbols = 1
+		}
+	}
+	v.check(letters+digits+symbols >= 2, field, "must mix at least two of letters, digits and symbols")
+}
+
+func validateStatus(v *validator, field, value string) {
+	validateChoice(v, field, value, userStatuses)
+}
+
+// validateChoice checks that value is exactly one of allowed
+func validateChoice(v *validator, field, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	v.add(field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// validateCurrency checks an optional ISO 4217 code
+func validateCurrency(v *validator, field, value string) {
+	v.check(validCurrency(value), field, "is not a supported currency")
+}
+
+// validateAmount checks that value is a positive plain decimal such as
+// "12.50". Whether its scale suits the currency is checked by money.Parse
+// once the currency is known.
+func validateAmount(v *validator, field string, value json.Number) {
+	if !v.required(field, value.String()) {
+		return
+	}
+
+	s := value.String()
+	v.check(decimalPattern.MatchString(s) && strings.Trim(s, "0.") != "", field, "must be a positive decimal amount")
+}
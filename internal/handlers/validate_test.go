@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type validationResponse struct {
+	Code   string       `json:"code"`
+	Fields []FieldError `json:"fields"`
+}
+
+func createUser(handler *UserHandler, body string) (*httptest.ResponseRecorder, validationResponse) {
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.CreateUsers(w, req)
+
+	var resp validationResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func fieldNames(fields []FieldError) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Field
+	}
+	return names
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	handler := &UserHandler{DB: &mockDB{}}
+
+	w, resp := createUser(handler, `{"first_name": "", "last_name": "Averyveryverylonglastname", "phone_number": "12ab", "email": "not-an-email", "password": "password"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, []string{"first_name", "last_name", "phone_number", "email", "password"}, fieldNames(resp.Fields))
+
+	// server-managed fields can not be sent
+	w, resp = createUser(handler, `{"first_name": "Md", "last_name": "Rana", "phone_number": "809890899", "email": "rana@example.com", "password": "example123", "passwrod_hash": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"passwrod_hash"}, fieldNames(resp.Fields))
+
+	w, resp = createUser(handler, `{"first_name": 7}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []FieldError{{Field: "first_name", Message: "must be of type string"}}, resp.Fields)
+
+	w, _ = createUser(handler, `{"first_name": "Md", "last_name": "Rana", "phone_number": "+809890899", "email": "rana@example.com", "password": "example123"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "password")
+
+	user := handler.DB.(*mockDB).users[0]
+	assert.Equal(t, "active", user.Status)
+	assert.Equal(t, utils.RoleCustomer, user.Role)
+}
+
+func TestUpdateUserKeepsOmittedFields(t *testing.T) {
+	user := &db.User{ID: 1, FirstName: "Md", LastName: "Rana", PhoneNumber: "809890899", Email: "rana@example.com", Status: "active", Role: utils.RoleCustomer}
+	handler := &UserHandler{DB: &mockDB{users: []*db.User{user}}}
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/users/1", strings.NewReader(body))
+		req = mux.SetURLVars(withUser(req, 1), map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		handler.UpdateUser(w, req)
+		return w
+	}
+
+	w := send(`{"phone_number": "8098908080"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	updated, _ := handler.DB.GetUserByID(1)
+	assert.Equal(t, "8098908080", updated.PhoneNumber)
+	assert.Equal(t, "Md", updated.FirstName)
+	assert.Equal(t, "rana@example.com", updated.Email)
+
+	w = send(`{"status": "frozen", "email": "rana@"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = send(`{"id": 2}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestValidatePassword(t *testing.T) {
+	for password, ok := range map[string]bool{
+		"short1":                 false,
+		"onlyletters":            false,
+		"12345678":               false,
+		"new-password":           true,
+		"password123":            true,
+		strings.Repeat("a1", 37): false,
+	} {
+		v := &validator{}
+		validatePassword(v, "password", password)
+		assert.Equal(t, ok, v.err() == nil, password)
+	}
+}
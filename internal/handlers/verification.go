@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
+)
+
+// VerificationCodeTTL is how long an email or phone verification code works;
+// VerificationResendCooldown is how long a user waits before another code
+// is sent to the same channel.
+const (
+	VerificationCodeTTL        = 15 * time.Minute
+	VerificationResendCooldown = time.Minute
+)
+
+// SendVerificationCode handles POST /verify/{channel}/send, where channel is
+// email or phone. It sends a six digit code to the caller's address, at most
+// once per VerificationResendCooldown.
+func (h *UserHandler) SendVerificationCode(w http.ResponseWriter, r *http.Request) {
+	channel := mux.Vars(r)["channel"]
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	if h.Notifier == nil {
+		respondError(w, http.StatusServiceUnavailable, "Verification is not available")
+		return
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Code generation error")
+		return
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	destination, wait, err := h.DB.CreateVerificationCode(userID, channel, utils.HashToken(code), time.Now().Add(VerificationCodeTTL), VerificationResendCooldown)
+	if errors.Is(err, db.ErrUnknownContact) {
+		respondProblem(w, http.StatusNotFound, problem.CodeUnknownContact, "Channel must be email or phone")
+		return
+	}
+	if err != nil {
+		respondFailure(w, err, "Failed to create verification code")
+		return
+	}
+
+	// the code sent last is still the one to use
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondProblem(w, http.StatusTooManyRequests, problem.CodeResendTooSoon, "A code was sent recently, try again later")
+		return
+	}
+
+	msg := notify.Message{To: destination, Body: fmt.Sprintf("Your verification code is %s", code)}
+	if channel == db.ContactPhone {
+		msg.Channel = notify.ChannelSMS
+	} else {
+		msg.Channel = notify.ChannelEmail
+		msg.Subject = "Verify your email"
+	}
+
+	if err := h.Notifier.Send(r.Context(), msg); err != nil {
+		respondError(w, http.StatusBadGateway, "Failed to send verification code")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyContactRequest carries the code sent by SendVerificationCode
+type VerifyContactRequest struct {
+	Code string `json:"code"`
+}
+
+func (req *VerifyContactRequest) validate(v *validator) {
+	v.required("code", req.Code)
+}
+
+// VerifyContact handles POST /verify/{channel} with the code sent by
+// SendVerificationCode
+func (h *UserHandler) VerifyContact(w http.ResponseWriter, r *http.Request) {
+	channel := mux.Vars(r)["channel"]
+
+	var req VerifyContactRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
+		return
+	}
+
+	ok, err := throttleVerify(w, h.DB, h.LoginPolicy, userID, func() error {
+		return h.DB.VerifyContact(userID, channel, utils.HashToken(req.Code))
+	})
+	if !ok {
+		return
+	}
+
+	switch {
+	case errors.Is(err, db.ErrUnknownContact):
+		respondProblem(w, http.StatusNotFound, problem.CodeUnknownContact, "Channel must be email or phone")
+		return
+	case errors.Is(err, db.ErrVerificationCodeInvalid):
+		respondProblem(w, http.StatusUnprocessableEntity, problem.CodeVerificationCode, "Invalid or expired verification code")
+		return
+	case err != nil:
+		respondFailure(w, err, "Failed to verify")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/notify"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func postChannel(handler http.HandlerFunc, path, channel, body string) *httptest.ResponseRecorder {
+	req := withUser(httptest.NewRequest("POST", path, strings.NewReader(body)), 1)
+	req = mux.SetURLVars(req, map[string]string{"channel": channel})
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestVerifyPhone(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+	user, _ := handler.DB.GetUserByID(1)
+	user.PhoneNumber = "8801700000000"
+
+	w := postChannel(handler.SendVerificationCode, "/verify/fax/send", "fax", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = postChannel(handler.SendVerificationCode, "/verify/phone/send", "phone", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	if !assert.Len(t, notifier.messages, 1) {
+		return
+	}
+	assert.Equal(t, notify.ChannelSMS, notifier.messages[0].Channel)
+	assert.Equal(t, "8801700000000", notifier.messages[0].To)
+
+	body := notifier.messages[0].Body
+	code := body[len(body)-6:]
+
+	w = postChannel(handler.VerifyContact, "/verify/phone", "phone", `{"code": "abcdef"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = postChannel(handler.VerifyContact, "/verify/phone", "phone", `{"code": "`+code+`"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.True(t, user.PhoneVerified)
+	assert.False(t, user.EmailVerified)
+}
+
+func TestResendWaitsForCooldown(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+
+	w := postChannel(handler.SendVerificationCode, "/verify/email/send", "email", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	w = postChannel(handler.SendVerificationCode, "/verify/email/send", "email", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problem.CodeResendTooSoon, problemCode(w))
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Len(t, notifier.messages, 1)
+
+	// the cooldown is per channel
+	w = postChannel(handler.SendVerificationCode, "/verify/phone/send", "phone", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestWrongVerificationCodesAreThrottled(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	handler.LoginPolicy.Account = db.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+
+	w := postChannel(handler.SendVerificationCode, "/verify/email/send", "email", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	body := notifier.messages[0].Body
+	code := body[len(body)-6:]
+
+	verify := func(code string) *httptest.ResponseRecorder {
+		return postChannel(handler.VerifyContact, "/verify/email", "email", `{"code": "`+code+`"}`)
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, verify("abcdef").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, verify("abcdeg").Code)
+
+	// the count is kept per user, so not even the right code is checked
+	w = verify(code)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problem.CodeVerifyThrottled, problemCode(w))
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+
+	user, _ := handler.DB.GetUserByID(1)
+	assert.False(t, user.EmailVerified)
+}
+
+func TestChangedEmailIsReturnedUnverified(t *testing.T) {
+	handler := newSessionTestHandler(t)
+	user, _ := handler.DB.GetUserByID(1)
+	user.EmailVerified, user.PhoneVerified = true, true
+
+	req := withUser(httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"email": "new@example.com"}`)), 1)
+	w := httptest.NewRecorder()
+	handler.UpdateUser(w, mux.SetURLVars(req, map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&updated)
+	assert.Equal(t, "new@example.com", updated["email"])
+	assert.Equal(t, false, updated["email_verified"])
+	assert.Equal(t, true, updated["phone_verified"])
+}
+
+func TestUnverifiedUsersCanNotMoveMoney(t *testing.T) {
+	mockDB := NewMockDB()
+	from, _ := mockDB.CreateWallet(1, "USD")
+	to, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(from.ID, usd(t, "100"))
+	mockDB.Unverified = map[int64]bool{1: true}
+	handler := &WalletHandler{DB: mockDB}
+
+	w := postAsUser(handler.Transfer, "/wallets/transfer", 1, map[string]interface{}{"from_wallet_id": from.ID, "to_wallet_id": to.ID, "amount": "10"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postAsUser(handler.Withdraw, "/wallets/withdraw", 1, map[string]interface{}{"amount": "10"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// deposits are still accepted
+	w = postAsUser(handler.Deposit, "/wallets/deposit", 1, map[string]interface{}{"amount": "10"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	wallet, _ := mockDB.GetWalletByID(from.ID)
+	assert.Equal(t, "110.00", wallet.Balance.String())
+}
@@ -2,53 +2,235 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"math/big"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/fx"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
 	"github.com/masudcsesust04/ewallet-api/internal/utils"
 )
 
 type WalletDBInterface interface {
 	GetWalletByID(walletID int64) (*db.Wallet, error)
-	GetWalletByUserID(userID int64) (*db.Wallet, error)
-	CreateWallet(userID int64) (*db.Wallet, error)
-	UpdateWalletBalance(walletID int64, newBalance float64) error
-	CreateTransaction(tx *db.Transaction) error
-	TransferFunds(fromWalletID, toWalletID int64, amount float64) error
-	GetTransactionsByWalletID(fromWalletID int64) ([]*db.Transaction, error)
+	GetWalletsByUserID(userID int64) ([]*db.Wallet, error)
+	GetWalletByUserIDAndCurrency(userID int64, currency string) (*db.Wallet, error)
+	CreateWallet(userID int64, currency string) (*db.Wallet, error)
+	Deposit(walletID int64, amount money.Money) (*db.Transaction, error)
+	Withdraw(walletID int64, amount money.Money) (*db.Transaction, error)
+	TransferFunds(transfer *db.Transfer) error
+	GetTransactions(q db.TransactionQuery) ([]*db.Transaction, *db.TransactionCursor, error)
+	GetTransaction(id int64) (*db.Transaction, error)
+	ReverseTransfer(reversal *db.Reversal) error
+	CreateFXQuote(quote *db.FXQuote) error
+	VerifyTOTP(userID int64, code string) error
+	ReserveLoginAttempt(counters ...db.LoginCounter) (time.Duration, error)
+	ClearLoginFailures(subject, key string) error
+	UserVerified(userID int64) (bool, error)
+	PlaceHold(hold *db.Hold) error
+	GetHold(id int64) (*db.Hold, error)
+	GetHoldsByWalletID(walletID int64) ([]*db.Hold, error)
+	CaptureHold(id int64, amount money.Money) (*db.Hold, error)
+	VoidHold(id int64) (*db.Hold, error)
+	CreateScheduledTransfer(s *db.ScheduledTransfer) error
+	GetScheduledTransfer(id int64) (*db.ScheduledTransfer, error)
+	GetScheduledTransfersByUserID(userID int64) ([]*db.ScheduledTransfer, error)
+	CancelScheduledTransfer(id int64) (*db.ScheduledTransfer, error)
+	GetScheduledTransferRuns(scheduleID int64) ([]*db.ScheduledTransferRun, error)
+	CreatePaymentRequest(pr *db.PaymentRequest) error
+	GetPaymentRequest(id int64) (*db.PaymentRequest, error)
+	GetPaymentRequests(q db.PaymentRequestQuery) ([]*db.PaymentRequest, error)
+	AcceptPaymentRequest(id, fromWalletID int64) (*db.PaymentRequest, error)
+	DeclinePaymentRequest(id int64) (*db.PaymentRequest, error)
+	CancelPaymentRequest(id int64) (*db.PaymentRequest, error)
+	GetFXQuote(id string) (*db.FXQuote, error)
+	GetUserByEmail(email string) (*db.User, error)
+	GetUserByPhoneNumber(phoneNumber string) (*db.User, error)
 }
 
 type WalletHandler struct {
-	DB WalletDBInterface
+	DB             WalletDBInterface
+	Idempotency    IdempotencyStore
+	IdempotencyTTL time.Duration
+
+	// Rates prices currency conversions; quotes are refused when it is nil
+	Rates    fx.RateProvider
+	QuoteTTL time.Duration
+	FXSpread *big.Rat
+
+	// TOTPThresholds maps a currency to the transfer amount above which a
+	// fresh TOTP code is required
+	TOTPThresholds map[string]money.Money
+
+	// LoginPolicy throttles wrong TOTP codes like failed logins; the zero
+	// value counts them without ever delaying or locking out
+	LoginPolicy LoginPolicy
+
+	// HoldTTL is how long a hold reserves money before it expires
+	HoldTTL time.Duration
 }
 
-func NewWalletHandler(db *db.DB) *WalletHandler {
-	return &WalletHandler{DB: db}
+// WalletConfig holds the settings for the wallet routes
+type WalletConfig struct {
+	// IdempotencyTTL is how long a response stored for an Idempotency-Key is replayed
+	IdempotencyTTL time.Duration
+
+	// Rates, QuoteTTL and FXSpread configure currency conversion quotes.
+	// FXSpread is the fraction of the amount charged as a fee, e.g. 0.005.
+	Rates    fx.RateProvider
+	QuoteTTL time.Duration
+	FXSpread *big.Rat
+
+	// TOTPThresholds maps a currency to the transfer amount above which the
+	// sender must confirm with a TOTP code
+	TOTPThresholds map[string]money.Money
+
+	// HoldTTL is how long a hold reserves money before it expires
+	HoldTTL time.Duration
 }
 
+func NewWalletHandler(db *db.DB, cfg WalletConfig) *WalletHandler {
+	return &WalletHandler{
+		DB:             db,
+		Idempotency:    db,
+		IdempotencyTTL: cfg.IdempotencyTTL,
+		Rates:          cfg.Rates,
+		QuoteTTL:       cfg.QuoteTTL,
+		FXSpread:       cfg.FXSpread,
+		TOTPThresholds: cfg.TOTPThresholds,
+		LoginPolicy:    DefaultLoginPolicy,
+		HoldTTL:        cfg.HoldTTL,
+	}
+}
+
+// Amounts in request payloads are decoded as json.Number so the exact decimal
+// text reaches money.Parse; they may be sent either as a JSON number or as a
+// string such as "12.50".
+//
+// The acting user is taken from the access token. A user_id in a request is
+// optional and is refused with 403 unless it is the caller's own; support and
+// admin users may read, but not move, other users' money.
+
 type WalletRequest struct {
-	UserID   int64   `json:"user_id"`
-	Balance  float64 `json:"balance"`
-	Currency string  `json:"curency"`
+	UserID   int64       `json:"user_id"`
+	Balance  json.Number `json:"balance"`
+	Currency string      `json:"currency"`
 }
 
+func (req *WalletRequest) validate(v *validator) {
+	validateCurrency(v, "currency", req.Currency)
+	if req.Balance == "" || !validCurrency(req.Currency) {
+		return
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if balance, err := money.Parse(req.Balance.String(), currency); err != nil || balance.IsNegative() {
+		v.add("balance", "must be a non-negative amount in "+currency)
+	}
+}
+
+// DepositRequest and WithdrawRequest pick the user's wallet by currency. The
+// currency may be omitted when the user holds a single wallet.
+
 type DepositRequest struct {
-	UserID int64   `json:"user_id"`
-	Amount float64 `json:"amount"`
+	UserID   int64       `json:"user_id"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
 }
 
 type WithdrawRequest struct {
-	UserID int64   `json:"user_id"`
-	Amount float64 `json:"amount"`
+	UserID   int64       `json:"user_id"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+func (req *DepositRequest) validate(v *validator) {
+	validateCurrency(v, "currency", req.Currency)
+	validateAmount(v, "amount", req.Amount)
+}
+
+func (req *WithdrawRequest) validate(v *validator) {
+	validateCurrency(v, "currency", req.Currency)
+	validateAmount(v, "amount", req.Amount)
 }
 
+// TransferRequest moves money to the wallet to_wallet_id, or to the wallet of
+// the user with the email to_email or phone number to_phone_number. A
+// transfer between currencies must reference a quote from POST /fx/quotes;
+// the amount may then be omitted.
 type TransferRequest struct {
-	FromWalletID int64   `json:"from_wallet_id"`
-	ToWalletID   int64   `json:"to_wallet_id"`
-	Amount       float64 `json:"amount"`
+	FromWalletID  int64       `json:"from_wallet_id"`
+	ToWalletID    int64       `json:"to_wallet_id"`
+	ToEmail       string      `json:"to_email"`
+	ToPhoneNumber string      `json:"to_phone_number"`
+	Amount        json.Number `json:"amount"`
+	QuoteID       string      `json:"quote_id"`
+	TOTPCode      string      `json:"totp_code"`
+}
+
+func (req *TransferRequest) validate(v *validator) {
+	v.check(req.FromWalletID > 0, "from_wallet_id", "is required")
+
+	switch {
+	case req.ToEmail != "":
+		validateEmail(v, "to_email", req.ToEmail)
+		v.check(req.ToWalletID == 0 && req.ToPhoneNumber == "", "to_email", "can not be combined with to_wallet_id or to_phone_number")
+	case req.ToPhoneNumber != "":
+		validatePhone(v, "to_phone_number", req.ToPhoneNumber)
+		v.check(req.ToWalletID == 0, "to_phone_number", "can not be combined with to_wallet_id")
+	default:
+		v.check(req.ToWalletID > 0, "to_wallet_id", "is required without to_email or to_phone_number")
+		v.check(req.FromWalletID != req.ToWalletID, "to_wallet_id", "must differ from from_wallet_id")
+	}
+
+	if req.QuoteID == "" || req.Amount != "" {
+		validateAmount(v, "amount", req.Amount)
+	}
+}
+
+// DefaultCurrency is used for new wallets when a request does not name a currency
+const DefaultCurrency = "USD"
+
+var errCurrencyRequired = errors.New("currency is required when holding wallets in several currencies")
+
+// userWallet returns the user's wallet in currency. When currency is empty
+// and the user holds exactly one wallet, that wallet is returned.
+func (h *WalletHandler) userWallet(userID int64, currency string) (*db.Wallet, error) {
+	if currency != "" {
+		return h.DB.GetWalletByUserIDAndCurrency(userID, currency)
+	}
+
+	wallets, err := h.DB.GetWalletsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(wallets) {
+	case 0:
+		return nil, db.ErrWalletNotFound
+	case 1:
+		return wallets[0], nil
+	default:
+		return nil, errCurrencyRequired
+	}
+}
+
+// validCurrency reports whether code is empty or a supported ISO 4217 code
+func validCurrency(code string) bool {
+	if code == "" {
+		return true
+	}
+
+	_, err := money.LookupCurrency(code)
+	return err == nil
 }
 
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -57,99 +239,114 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	json.NewEncoder(w).Encode(payload)
 }
 
-func respondError(w http.ResponseWriter, status int, message string) {
-	respondJSON(w, status, map[string]string{"error": message})
-}
-
-func RegisterWalletRoutes(r *mux.Router, db *db.DB) {
-	handler := &WalletHandler{DB: db}
+func RegisterWalletRoutes(r *mux.Router, db *db.DB, cfg WalletConfig) {
+	handler := NewWalletHandler(db, cfg)
 	r.HandleFunc("/wallets/new", utils.JWTMiddleware(handler.CreateNewWallet)).Methods("POST")
-	r.HandleFunc("/wallets/deposit", utils.JWTMiddleware(handler.Deposit)).Methods("POST")
-	r.HandleFunc("/wallets/withdraw", utils.JWTMiddleware(handler.Withdraw)).Methods("POST")
-	r.HandleFunc("/wallets/transfer", utils.JWTMiddleware(handler.Transfer)).Methods("POST")
+	r.HandleFunc("/wallets/deposit", utils.JWTMiddleware(handler.idempotent(handler.Deposit))).Methods("POST")
+	r.HandleFunc("/wallets/withdraw", utils.JWTMiddleware(handler.idempotent(handler.Withdraw))).Methods("POST")
+	r.HandleFunc("/wallets/transfer", utils.JWTMiddleware(handler.idempotent(handler.Transfer))).Methods("POST")
 	r.HandleFunc("/wallets/balance", utils.JWTMiddleware(handler.Balance)).Methods("GET")
 	r.HandleFunc("/wallets/transactions", utils.JWTMiddleware(handler.Transactions)).Methods("GET")
+	r.HandleFunc("/wallets/transactions/{id}/reverse", utils.JWTMiddleware(handler.idempotent(handler.ReverseTransaction))).Methods("POST")
+	r.HandleFunc("/wallets/holds", utils.JWTMiddleware(handler.idempotent(handler.PlaceHold))).Methods("POST")
+	r.HandleFunc("/wallets/holds", utils.JWTMiddleware(handler.Holds)).Methods("GET")
+	r.HandleFunc("/wallets/holds/{id}/capture", utils.JWTMiddleware(handler.idempotent(handler.CaptureHold))).Methods("POST")
+	r.HandleFunc("/wallets/holds/{id}/void", utils.JWTMiddleware(handler.idempotent(handler.VoidHold))).Methods("POST")
+	r.HandleFunc("/wallets/schedules", utils.JWTMiddleware(handler.idempotent(handler.CreateSchedule))).Methods("POST")
+	r.HandleFunc("/wallets/schedules", utils.JWTMiddleware(handler.Schedules)).Methods("GET")
+	r.HandleFunc("/wallets/schedules/{id}/cancel", utils.JWTMiddleware(handler.CancelSchedule)).Methods("POST")
+	r.HandleFunc("/wallets/schedules/{id}/runs", utils.JWTMiddleware(handler.ScheduleRuns)).Methods("GET")
+	r.HandleFunc("/wallets/requests", utils.JWTMiddleware(handler.RequestPayment)).Methods("POST")
+	r.HandleFunc("/wallets/requests", utils.JWTMiddleware(handler.PaymentRequests)).Methods("GET")
+	r.HandleFunc("/wallets/requests/incoming", utils.JWTMiddleware(handler.IncomingPaymentRequests)).Methods("GET")
+	r.HandleFunc("/wallets/requests/{id}/accept", utils.JWTMiddleware(handler.idempotent(handler.AcceptPaymentRequest))).Methods("POST")
+	r.HandleFunc("/wallets/requests/{id}/decline", utils.JWTMiddleware(handler.DeclinePaymentRequest)).Methods("POST")
+	r.HandleFunc("/wallets/requests/{id}/cancel", utils.JWTMiddleware(handler.CancelPaymentRequest)).Methods("POST")
+	r.HandleFunc("/wallets/recipients", utils.JWTMiddleware(handler.LookupRecipient)).Methods("GET")
+	r.HandleFunc("/fx/quotes", utils.JWTMiddleware(handler.CreateQuote)).Methods("POST")
 }
 
 func (h *WalletHandler) CreateNewWallet(w http.ResponseWriter, r *http.Request) {
 	var req WalletRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
-	wallet, err := h.DB.CreateWallet(req.UserID)
+	userID, err := callerID(r, req.UserID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create wallet")
+		respondError(w, accessStatus(err), "Not allowed to create a wallet for this user")
 		return
 	}
 
-	newBalance := wallet.Balance + req.Balance
-	err = h.DB.UpdateWalletBalance(wallet.ID, newBalance)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update wallet balance")
-		return
-	}
-
-	wallet.Balance = newBalance
-	tx := &db.Transaction{
-		FromWalletID: wallet.ID,
-		Type:         "deposit",
-		Amount:       req.Balance,
-		Status:       "completed",
-		CreatedAt:    time.Now(),
+	if req.Currency == "" {
+		req.Currency = DefaultCurrency
 	}
 
-	err = h.DB.CreateTransaction(tx)
+	wallet, err := h.DB.CreateWallet(userID, req.Currency)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to log transaction")
+		respondFailure(w, err, "Failed to create wallet")
 		return
 	}
 
+	// The opening balance is optional and was validated in the wallet's
+	// currency; only a positive amount is deposited.
+	if req.Balance != "" {
+		balance, _ := money.Parse(req.Balance.String(), wallet.Currency)
+		if balance.IsPositive() {
+			if _, err := h.DB.Deposit(wallet.ID, balance); err != nil {
+				respondFailure(w, err, "Failed to deposit opening balance")
+				return
+			}
+
+			wallet.Balance, wallet.AvailableBalance = balance, balance
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(wallet)
 }
 
 func (h *WalletHandler) Deposit(w http.ResponseWriter, r *http.Request) {
 	var req DepositRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
-	if req.Amount <= 0 {
-		respondError(w, http.StatusBadRequest, "Amount must be positive")
+	userID, err := callerID(r, req.UserID)
+	if err != nil {
+		respondError(w, accessStatus(err), "Not allowed to deposit to this user's wallet")
 		return
 	}
 
-	wallet, err := h.DB.GetWalletByUserID(req.UserID)
+	wallet, err := h.userWallet(userID, req.Currency)
+	if errors.Is(err, errCurrencyRequired) {
+		respondFailure(w, err, "Failed to find wallet")
+		return
+	}
 	if err != nil {
 		// If wallet not found, create one
-		wallet, err = h.DB.CreateWallet(req.UserID)
+		currency := req.Currency
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+
+		wallet, err = h.DB.CreateWallet(userID, currency)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "Failed to create wallet")
+			respondFailure(w, err, "Failed to create wallet")
 			return
 		}
 	}
 
-	newBalance := wallet.Balance + req.Amount
-	err = h.DB.UpdateWalletBalance(wallet.ID, newBalance)
+	amount, err := money.Parse(req.Amount.String(), wallet.Currency)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update wallet balance")
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+wallet.Currency))
 		return
 	}
 
-	tx := &db.Transaction{
-		FromWalletID: wallet.ID,
-		Type:         "deposit",
-		Amount:       req.Amount,
-		Status:       "completed",
-		CreatedAt:    time.Now(),
-	}
-
-	err = h.DB.CreateTransaction(tx)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to log transaction")
+	if _, err := h.DB.Deposit(wallet.ID, amount); err != nil {
+		respondFailure(w, err, "Failed to deposit")
 		return
 	}
 
@@ -158,45 +355,35 @@ func (h *WalletHandler) Deposit(w http.ResponseWriter, r *http.Request) {
 
 func (h *WalletHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	var req WithdrawRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	if req.Amount <= 0 {
-		respondError(w, http.StatusBadRequest, "Amount must be positive")
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
-	wallet, err := h.DB.GetWalletByUserID(req.UserID)
+	userID, err := callerID(r, req.UserID)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Wallet not found")
+		respondError(w, accessStatus(err), "Not allowed to withdraw from this user's wallet")
 		return
 	}
 
-	if wallet.Balance < req.Amount {
-		respondError(w, http.StatusBadRequest, "Insufficient funds")
+	if !h.requireVerified(w, userID) {
 		return
 	}
 
-	newBalance := wallet.Balance - req.Amount
-	err = h.DB.UpdateWalletBalance(wallet.ID, newBalance)
+	wallet, err := h.userWallet(userID, req.Currency)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update wallet balance")
+		respondFailure(w, err, "Failed to find wallet")
 		return
 	}
 
-	tx := &db.Transaction{
-		FromWalletID: wallet.ID,
-		Type:         "withdrawal",
-		Amount:       req.Amount,
-		Status:       "completed",
-		CreatedAt:    time.Now(),
+	amount, err := money.Parse(req.Amount.String(), wallet.Currency)
+	if err != nil {
+		respondInvalid(w, invalidField("amount", "is not a valid amount in "+wallet.Currency))
+		return
 	}
 
-	err = h.DB.CreateTransaction(tx)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to log transaction")
+	if _, err := h.DB.Withdraw(wallet.ID, amount); err != nil {
+		respondFailure(w, err, "Failed to withdraw")
 		return
 	}
 
@@ -205,75 +392,161 @@ func (h *WalletHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 
 func (h *WalletHandler) Transfer(w http.ResponseWriter, r *http.Request) {
 	var req TransferRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := decodeRequest(r, &req); err != nil {
+		respondInvalid(w, err)
 		return
 	}
 
-	if req.Amount <= 0 {
-		respondError(w, http.StatusBadRequest, "Amount must be positive")
+	userID, err := callerID(r, 0)
+	if err != nil {
+		respondError(w, accessStatus(err), "Unauthorized")
 		return
 	}
 
-	if req.FromWalletID == req.ToWalletID {
-		respondError(w, http.StatusBadRequest, "Can not transfer to the same wallet account")
+	if !h.requireVerified(w, userID) {
 		return
 	}
 
-	// Use atomic transfer function in DB layer
-	err := h.DB.TransferFunds(req.FromWalletID, req.ToWalletID, req.Amount)
+	fromWallet, err := h.DB.GetWalletByID(req.FromWalletID)
 	if err != nil {
-		if err.Error() == "insufficient funds" {
-			respondError(w, http.StatusBadRequest, "insufficient funds")
-		} else {
-			respondError(w, http.StatusInternalServerError, "Failed to perform transfer: "+err.Error())
-		}
+		respondFailure(w, err, "Failed to get wallet")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Transfered successfully."})
-}
+	if fromWallet.UserID != userID {
+		respondError(w, http.StatusForbidden, "Wallet does not belong to you")
+		return
+	}
 
-func (h *WalletHandler) Balance(w http.ResponseWriter, r *http.Request) {
-	userIDParam := r.URL.Query().Get("user_id")
-	if userIDParam == "" {
-		respondError(w, http.StatusBadRequest, "Missing user_id query parameter")
+	toWallet, ok := h.transferRecipient(w, &req, fromWallet)
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(userIDParam, 10, 64)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid user_id query parameter")
+	if fromWallet.Currency != toWallet.Currency && req.QuoteID == "" {
+		respondProblem(w, http.StatusBadRequest, problem.CodeCurrencyMismatch, "Currency mismatch between wallets")
 		return
 	}
 
-	wallet, err := h.DB.GetWalletByUserID(userID)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Wallet not found")
+	transfer := &db.Transfer{FromWalletID: req.FromWalletID, ToWalletID: toWallet.ID, QuoteID: req.QuoteID}
+
+	// With a quote the amount is optional; if given it must match the quote.
+	if req.QuoteID == "" || req.Amount != "" {
+		transfer.Amount, err = money.Parse(req.Amount.String(), fromWallet.Currency)
+		if err != nil {
+			respondInvalid(w, invalidField("amount", "is not a valid amount in "+fromWallet.Currency))
+			return
+		}
+	}
+
+	if !h.confirmTOTP(w, userID, transfer.Amount, fromWallet.Currency, req.TOTPCode) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(wallet)
+	// Use atomic transfer function in DB layer
+	if err := h.DB.TransferFunds(transfer); err != nil {
+		respondFailure(w, err, "Failed to perform transfer")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"message": "Transfered successfully.", "transaction": transfer.Send})
 }
 
-func (h *WalletHandler) Transactions(w http.ResponseWriter, r *http.Request) {
-	walletIDParam := r.URL.Query().Get("wallet_id")
-	if walletIDParam == "" {
-		respondError(w, http.StatusBadRequest, "Missing wallet_id query parameter")
+// requireVerified refuses to move money out of accounts whose email or phone
+// is not verified. It reports whether the request may go on.
+func (h *WalletHandler) requireVerified(w http.ResponseWriter, userID int64) bool {
+	verified, err := h.DB.UserVerified(userID)
+	if err != nil {
+		respondFailure(w, err, "Failed to check verification")
+		return false
+	}
+
+	if !verified {
+		respondProblem(w, http.StatusForbidden, problem.CodeUnverified, "Verify your email and phone number before moving money")
+		return false
+	}
+
+	return true
+}
+
+// confirmTOTP checks code when moving amount needs a TOTP code. It reports
+// whether the request may go on.
+func (h *WalletHandler) confirmTOTP(w http.ResponseWriter, userID int64, amount money.Money, currency, code string) bool {
+	if !h.requiresTOTP(amount, currency) {
+		return true
+	}
+
+	ok, err := throttleTOTP(w, h.DB, h.LoginPolicy, userID, func() error {
+		return h.DB.VerifyTOTP(userID, code)
+	})
+	if !ok {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, db.ErrTOTPNotEnrolled):
+		respondProblem(w, http.StatusForbidden, problem.CodeTOTPNotEnrolled, "Enable two-factor authentication to send this amount")
+		return false
+	case errors.Is(err, db.ErrInvalidTOTPCode):
+		respondProblem(w, http.StatusForbidden, problem.CodeMFARequired, "A valid totp_code is required for this amount")
+		return false
+	case err != nil:
+		respondFailure(w, err, "Failed to verify code")
+		return false
+	}
+
+	return true
+}
+
+// requiresTOTP reports whether a transfer of amount needs a TOTP code. A
+// quote transfer without an amount is only known after the quote is locked,
+// so it needs a code whenever the currency has a threshold.
+func (h *WalletHandler) requiresTOTP(amount money.Money, currency string) bool {
+	threshold, ok := h.TOTPThresholds[currency]
+	if !ok {
+		return false
+	}
+
+	if amount.Currency() == "" {
+		return true
+	}
+
+	cmp, err := amount.Cmp(threshold)
+	return err != nil || cmp > 0
+}
+
+func (h *WalletHandler) Balance(w http.ResponseWriter, r *http.Request) {
+	// user_id is optional and must be the caller's own
+	var requestedID int64
+	if userIDParam := r.URL.Query().Get("user_id"); userIDParam != "" {
+		var err error
+		requestedID, err = strconv.ParseInt(userIDParam, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid user_id query parameter")
+			return
+		}
+	}
+
+	userID := requestedID
+	if userID == 0 {
+		userID, _ = utils.UserIDFromContext(r.Context())
+	}
+
+	if err := authorizeUser(r, userID, utils.PermReadWallets); err != nil {
+		respondError(w, accessStatus(err), "Not allowed to view this user's wallets")
 		return
 	}
 
-	walletID, err := strconv.ParseInt(walletIDParam, 10, 64)
+	wallets, err := h.DB.GetWalletsByUserID(userID)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid wallet_id query parameter")
+		respondFailure(w, err, "Failed to get wallets")
 		return
 	}
 
-	transactions, err := h.DB.GetTransactionsByWalletID(walletID)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get transactions")
+	if len(wallets) == 0 {
+		respondProblem(w, http.StatusNotFound, problem.CodeWalletNotFound, "Wallet not found")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, transactions)
+	respondJSON(w, http.StatusOK, wallets)
 }
@@ -3,15 +3,17 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/masudcsesust04/ewallet-api/internal/db"
+	"github.com/masudcsesust04/ewallet-api/internal/money"
+	"github.com/masudcsesust04/ewallet-api/internal/utils"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -20,14 +22,26 @@ import (
 type MockDB struct {
 	Wallets      map[int64]*db.Wallet
 	Transactions []*db.Transaction
+	Quotes       map[string]*db.FXQuote
+	TOTPCodes    map[int64]string // the code each enrolled user's authenticator shows
+	Unverified   map[int64]bool   // users whose email or phone is not verified
+	Holds        map[int64]*db.Hold
+	Schedules    map[int64]*db.ScheduledTransfer
+	Runs         []*db.ScheduledTransferRun
+	Requests     map[int64]*db.PaymentRequest
+	Users        []*db.User // recipients found by email or phone number
+
+	mockLockout
 }
 
-var ErrNotFound = errors.New("not found")
-
 func NewMockDB() *MockDB {
 	return &MockDB{
 		Wallets:      make(map[int64]*db.Wallet),
 		Transactions: []*db.Transaction{},
+		Quotes:       make(map[string]*db.FXQuote),
+		Holds:        make(map[int64]*db.Hold),
+		Schedules:    make(map[int64]*db.ScheduledTransfer),
+		Requests:     make(map[int64]*db.PaymentRequest),
 	}
 }
 
@@ -45,33 +59,48 @@ func generateMockJWTToken(userID int) (string, error) {
 func (m *MockDB) GetWalletByID(walletID int64) (*db.Wallet, error) {
 	wallet, ok := m.Wallets[walletID]
 	if !ok {
-		return nil, ErrNotFound
+		return nil, db.ErrWalletNotFound
 	}
 	return wallet, nil
 }
 
-func (m *MockDB) GetWalletByUserID(userID int64) (*db.Wallet, error) {
-	wallet, ok := m.Wallets[userID]
-	if !ok {
-		return nil, ErrNotFound
+func (m *MockDB) GetWalletsByUserID(userID int64) ([]*db.Wallet, error) {
+	var wallets []*db.Wallet
+	for id := int64(1); id <= int64(len(m.Wallets)); id++ {
+		if w, ok := m.Wallets[id]; ok && w.UserID == userID {
+			wallets = append(wallets, w)
+		}
 	}
-	return wallet, nil
+	return wallets, nil
+}
+
+func (m *MockDB) GetWalletByUserIDAndCurrency(userID int64, currency string) (*db.Wallet, error) {
+	for _, w := range m.Wallets {
+		if w.UserID == userID && w.Currency == currency {
+			return w, nil
+		}
+	}
+	return nil, db.ErrWalletNotFound
 }
 
-func (m *MockDB) CreateWallet(userID int64) (*db.Wallet, error) {
+func (m *MockDB) CreateWallet(userID int64, currency string) (*db.Wallet, error) {
+	if _, err := m.GetWalletByUserIDAndCurrency(userID, currency); err == nil {
+		return nil, db.ErrWalletExists
+	}
+
 	wallet := &db.Wallet{
 		ID:        int64(len(m.Wallets) + 1),
 		UserID:    userID,
-		Balance:   0,
-		Currency:  "USD",
+		Balance:   money.Zero(currency),
+		Currency:  currency,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	m.Wallets[userID] = wallet
+	m.Wallets[wallet.ID] = wallet
 	return wallet, nil
 }
 
-func (m *MockDB) UpdateWalletBalance(walletID int64, newBalance float64) error {
+func (m *MockDB) UpdateWalletBalance(walletID int64, newBalance money.Money) error {
 	for _, w := range m.Wallets {
 		if w.ID == walletID {
 			w.Balance = newBalance
@@ -79,69 +108,520 @@ func (m *MockDB) UpdateWalletBalance(walletID int64, newBalance float64) error {
 			return nil
 		}
 	}
-	return ErrNotFound
+	return db.ErrWalletNotFound
+}
+
+func (m *MockDB) Deposit(walletID int64, amount money.Money) (*db.Transaction, error) {
+	return m.applyWalletChange(walletID, "deposit", amount)
+}
+
+func (m *MockDB) Withdraw(walletID int64, amount money.Money) (*db.Transaction, error) {
+	return m.applyWalletChange(walletID, "withdrawal", amount)
+}
+
+func (m *MockDB) applyWalletChange(walletID int64, txType string, amount money.Money) (*db.Transaction, error) {
+	for _, w := range m.Wallets {
+		if w.ID != walletID {
+			continue
+		}
+
+		delta := amount
+		if txType == "withdrawal" {
+			delta = amount.Neg()
+		}
+
+		newBalance, err := w.Balance.Add(delta)
+		if err != nil {
+			return nil, err
+		}
+		if newBalance.IsNegative() {
+			return nil, db.ErrInsufficientFunds
+		}
+
+		w.Balance = newBalance
+		txn := &db.Transaction{
+			ID:           int64(len(m.Transactions) + 1),
+			FromWalletID: walletID,
+			Type:         txType,
+			Amount:       amount,
+			Status:       "completed",
+			CreatedAt:    time.Now(),
+		}
+		m.Transactions = append(m.Transactions, txn)
+		return txn, nil
+	}
+	return nil, db.ErrWalletNotFound
 }
 
 func (m *MockDB) CreateTransaction(txn *db.Transaction) error {
+	txn.ID = int64(len(m.Transactions) + 1)
 	m.Transactions = append(m.Transactions, txn)
 	return nil
 }
 
-func (m *MockDB) GetTransactionsByWalletID(walletID int64) ([]*db.Transaction, error) {
+// GetTransactions lists the wallet's own records, filtered and ordered like
+// the database does. Records of the other side of a transfer are left out.
+func (m *MockDB) GetTransactions(q db.TransactionQuery) ([]*db.Transaction, *db.TransactionCursor, error) {
+	in := func(value string, values []string) bool {
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return len(values) == 0
+	}
+
+	key := func(txn *db.Transaction) (int64, int64) {
+		if q.SortBy == db.TransactionSortAmount {
+			return txn.Amount.Amount(), txn.ID
+		}
+		return txn.CreatedAt.UnixNano(), txn.ID
+	}
+	before := func(a, b *db.Transaction) bool {
+		ka, ia := key(a)
+		kb, ib := key(b)
+		if ka != kb {
+			return (ka < kb) == q.Ascending
+		}
+		return ia != ib && (ia < ib) == q.Ascending
+	}
+
+	var after *db.Transaction
+	if q.After != nil {
+		after = &db.Transaction{ID: q.After.ID, CreatedAt: q.After.CreatedAt, Amount: q.After.Amount}
+	}
+
 	var txns []*db.Transaction
 	for _, txn := range m.Transactions {
-		if txn.FromWalletID == walletID {
-			txns = append(txns, txn)
+		if txn.FromWalletID != q.WalletID || !in(txn.Type, q.Types) || !in(txn.Status, q.Statuses) {
+			continue
+		}
+		if (q.Since != nil && txn.CreatedAt.Before(*q.Since)) || (q.Until != nil && !txn.CreatedAt.Before(*q.Until)) {
+			continue
+		}
+		if q.MinAmount != nil && txn.Amount.Amount() < q.MinAmount.Amount() || q.MaxAmount != nil && txn.Amount.Amount() > q.MaxAmount.Amount() {
+			continue
 		}
+		if after != nil && !before(after, txn) {
+			continue
+		}
+		txns = append(txns, txn)
 	}
-	return txns, nil
+
+	sort.Slice(txns, func(i, j int) bool { return before(txns[i], txns[j]) })
+	if len(txns) <= q.Limit {
+		return txns, nil, nil
+	}
+
+	last := txns[q.Limit-1]
+	return txns[:q.Limit], &db.TransactionCursor{SortBy: q.SortBy, Ascending: q.Ascending, CreatedAt: last.CreatedAt, Amount: last.Amount, ID: last.ID}, nil
 }
 
-// TransferFunds performs an atomic transfer between two wallets
-func (m *MockDB) TransferFunds(fromWalletID, toWalletID int64, amount float64) error {
-	if amount <= 0 {
-		return errors.New("amount must be positive")
+func (m *MockDB) GetTransaction(id int64) (*db.Transaction, error) {
+	if id < 1 || id > int64(len(m.Transactions)) {
+		return nil, db.ErrTransactionNotFound
+	}
+	return m.Transactions[id-1], nil
+}
+
+func (m *MockDB) ReverseTransfer(reversal *db.Reversal) error {
+	original, err := m.GetTransaction(reversal.TransactionID)
+	if err != nil {
+		return err
+	}
+	if original.Type != "send" || original.ReversalOf != 0 {
+		return db.ErrNotReversible
+	}
+
+	refunded := money.Zero(original.Currency)
+	if original.RefundedAmount != nil {
+		refunded = *original.RefundedAmount
+	}
+	remaining, _ := original.Amount.Sub(refunded)
+	if !remaining.IsPositive() {
+		return db.ErrAlreadyRefunded
+	}
+
+	amount := reversal.Amount
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if cmp, _ := amount.Cmp(remaining); cmp > 0 {
+		return db.ErrRefundExceedsOriginal
+	}
+
+	transfer := &db.Transfer{FromWalletID: original.ToWalletID, ToWalletID: original.FromWalletID, Amount: amount}
+	if err := m.TransferFunds(transfer); err != nil {
+		return err
+	}
+	transfer.Send.ReversalOf, transfer.Receive.ReversalOf = original.ID, original.ID
+
+	refunded, _ = refunded.Add(amount)
+	original.RefundedAmount = &refunded
+	if refunded == original.Amount {
+		original.Status = "cancelled"
 	}
 
-	fromWallet, ok := m.Wallets[fromWalletID]
+	reversal.Original, reversal.Send, reversal.Receive = original, transfer.Send, transfer.Receive
+	return nil
+}
+
+// TransferFunds performs an atomic transfer between two wallets
+func (m *MockDB) TransferFunds(transfer *db.Transfer) error {
+	fromWallet, ok := m.Wallets[transfer.FromWalletID]
 	if !ok {
-		return fmt.Errorf("sender wallet not found")
+		return db.ErrWalletNotFound
 	}
 
-	toWallet, ok := m.Wallets[toWalletID]
+	toWallet, ok := m.Wallets[transfer.ToWalletID]
 	if !ok {
-		return fmt.Errorf("receiver wallet not found")
+		return db.ErrWalletNotFound
 	}
 
-	if fromWallet.Balance < amount {
-		return errors.New("insufficient funds")
+	debit, fee, credit := transfer.Amount, money.Zero(fromWallet.Currency), transfer.Amount
+	if transfer.QuoteID != "" {
+		quote, ok := m.Quotes[transfer.QuoteID]
+		if !ok || quote.UserID != fromWallet.UserID {
+			return db.ErrQuoteNotFound
+		}
+		if quote.UsedAt != nil {
+			return db.ErrQuoteUsed
+		}
+		if !quote.ExpiresAt.After(time.Now()) {
+			return db.ErrQuoteExpired
+		}
+
+		debit, fee, credit = quote.Amount, quote.Fee, quote.ConvertedAmount
+	} else if fromWallet.Currency != toWallet.Currency {
+		return money.ErrCurrencyMismatch
 	}
 
-	fromWallet.Balance -= amount
-	toWallet.Balance += amount
+	if !debit.IsPositive() {
+		return money.ErrInvalidAmount
+	}
+
+	total, _ := debit.Add(fee)
+	if cmp, err := fromWallet.Balance.Cmp(total); err != nil || cmp < 0 {
+		return db.ErrInsufficientFunds
+	}
+
+	fromWallet.Balance, _ = fromWallet.Balance.Sub(total)
+	toWallet.Balance, _ = toWallet.Balance.Add(credit)
 
 	now := time.Now()
 	fromWallet.UpdatedAt = now
 	toWallet.UpdatedAt = now
+	if quote, ok := m.Quotes[transfer.QuoteID]; ok {
+		quote.UsedAt = &now
+	}
 
-	m.Transactions = append(m.Transactions, &db.Transaction{
-		FromWalletID: fromWalletID,
-		Type:         "transfer",
-		Amount:       amount,
-		ToWalletID:   toWalletID,
+	transfer.Send = &db.Transaction{
+		FromWalletID: transfer.FromWalletID,
+		Type:         "send",
+		Amount:       debit,
+		Fee:          fee,
+		ToWalletID:   transfer.ToWalletID,
+		Currency:     debit.Currency(),
+		Status:       "completed",
 		CreatedAt:    now,
-	})
-	m.Transactions = append(m.Transactions, &db.Transaction{
-		FromWalletID: toWalletID,
-		Type:         "transfer",
-		Amount:       amount,
-		ToWalletID:   fromWalletID,
+	}
+	transfer.Receive = &db.Transaction{
+		FromWalletID: transfer.ToWalletID,
+		Type:         "receive",
+		Amount:       credit,
+		ToWalletID:   transfer.FromWalletID,
+		Currency:     credit.Currency(),
+		Status:       "completed",
 		CreatedAt:    now,
-	})
+	}
+	m.CreateTransaction(transfer.Send)
+	m.CreateTransaction(transfer.Receive)
+
+	return nil
+}
+
+func (m *MockDB) CreateFXQuote(quote *db.FXQuote) error {
+	quote.CreatedAt = time.Now()
+	m.Quotes[quote.ID] = quote
+	return nil
+}
+
+func (m *MockDB) VerifyTOTP(userID int64, code string) error {
+	expected, ok := m.TOTPCodes[userID]
+	if !ok {
+		return db.ErrTOTPNotEnrolled
+	}
+	if code != expected {
+		return db.ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+func (m *MockDB) UserVerified(userID int64) (bool, error) {
+	return !m.Unverified[userID], nil
+}
+
+func (m *MockDB) PlaceHold(hold *db.Hold) error {
+	wallet, ok := m.Wallets[hold.WalletID]
+	if !ok {
+		return db.ErrWalletNotFound
+	}
+
+	available := wallet.Balance
+	for _, h := range m.Holds {
+		if h.WalletID == wallet.ID && h.Status == db.HoldActive {
+			available, _ = available.Sub(h.Amount)
+		}
+	}
+	if cmp, err := available.Cmp(hold.Amount); err != nil || cmp < 0 {
+		return db.ErrInsufficientFunds
+	}
+
+	send := &db.Transaction{Type: "send", FromWalletID: hold.WalletID, ToWalletID: hold.PayeeWalletID, Amount: hold.Amount, Status: "pending"}
+	m.CreateTransaction(send)
+
+	hold.ID = int64(len(m.Holds) + 1)
+	hold.Currency = hold.Amount.Currency()
+	hold.Status = db.HoldActive
+	hold.TransactionID = send.ID
+	m.Holds[hold.ID] = hold
+	return nil
+}
+
+func (m *MockDB) GetHold(id int64) (*db.Hold, error) {
+	hold, ok := m.Holds[id]
+	if !ok {
+		return nil, db.ErrHoldNotFound
+	}
+	return hold, nil
+}
+
+func (m *MockDB) GetHoldsByWalletID(walletID int64) ([]*db.Hold, error) {
+	var holds []*db.Hold
+	for id := int64(len(m.Holds)); id > 0; id-- {
+		if h := m.Holds[id]; h.WalletID == walletID || h.PayeeWalletID == walletID {
+			holds = append(holds, h)
+		}
+	}
+	return holds, nil
+}
+
+func (m *MockDB) CaptureHold(id int64, amount money.Money) (*db.Hold, error) {
+	hold, ok := m.Holds[id]
+	if !ok {
+		return nil, db.ErrHoldNotFound
+	}
+	if hold.Status != db.HoldActive {
+		return nil, db.ErrHoldNotActive
+	}
+
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if cmp, _ := amount.Cmp(hold.Amount); cmp > 0 {
+		return nil, db.ErrCaptureExceedsHold
+	}
+
+	payer, payee := m.Wallets[hold.WalletID], m.Wallets[hold.PayeeWalletID]
+	payer.Balance, _ = payer.Balance.Sub(amount)
+	payee.Balance, _ = payee.Balance.Add(amount)
+
+	send := m.Transactions[hold.TransactionID-1]
+	send.Amount, send.Status = amount, "completed"
+	m.CreateTransaction(&db.Transaction{Type: "receive", FromWalletID: hold.PayeeWalletID, ToWalletID: hold.WalletID, Amount: amount, Status: "completed"})
+
+	hold.Status, hold.CapturedAmount = db.HoldCaptured, &amount
+	return hold, nil
+}
+
+func (m *MockDB) VoidHold(id int64) (*db.Hold, error) {
+	hold, ok := m.Holds[id]
+	if !ok {
+		return nil, db.ErrHoldNotFound
+	}
+	if hold.Status != db.HoldActive {
+		return nil, db.ErrHoldNotActive
+	}
+
+	m.Transactions[hold.TransactionID-1].Status = "cancelled"
+	hold.Status = db.HoldVoided
+	return hold, nil
+}
+
+func (m *MockDB) CreateScheduledTransfer(s *db.ScheduledTransfer) error {
+	s.ID = int64(len(m.Schedules) + 1)
+	s.Currency = s.Amount.Currency()
+	s.Status = db.ScheduleActive
+	s.NextRunAt = &s.StartAt
+	m.Schedules[s.ID] = s
+	return nil
+}
+
+func (m *MockDB) GetScheduledTransfer(id int64) (*db.ScheduledTransfer, error) {
+	s, ok := m.Schedules[id]
+	if !ok {
+		return nil, db.ErrScheduleNotFound
+	}
+	return s, nil
+}
+
+func (m *MockDB) GetScheduledTransfersByUserID(userID int64) ([]*db.ScheduledTransfer, error) {
+	var schedules []*db.ScheduledTransfer
+	for id := int64(len(m.Schedules)); id > 0; id-- {
+		if s := m.Schedules[id]; s.UserID == userID {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+func (m *MockDB) CancelScheduledTransfer(id int64) (*db.ScheduledTransfer, error) {
+	s, ok := m.Schedules[id]
+	if !ok {
+		return nil, db.ErrScheduleNotFound
+	}
+	if s.Status != db.ScheduleActive {
+		return nil, db.ErrScheduleNotActive
+	}
+
+	s.Status, s.NextRunAt = db.ScheduleCancelled, nil
+	return s, nil
+}
 
+func (m *MockDB) GetScheduledTransferRuns(scheduleID int64) ([]*db.ScheduledTransferRun, error) {
+	var runs []*db.ScheduledTransferRun
+	for i := len(m.Runs) - 1; i >= 0; i-- {
+		if m.Runs[i].ScheduleID == scheduleID {
+			runs = append(runs, m.Runs[i])
+		}
+	}
+	return runs, nil
+}
+
+func (m *MockDB) CreatePaymentRequest(pr *db.PaymentRequest) error {
+	for _, user := range m.Users {
+		if user.ID == pr.PayerID && user.Status != "active" {
+			return db.ErrPayerUnavailable
+		}
+	}
+	if _, err := m.GetWalletByUserIDAndCurrency(pr.PayerID, pr.Amount.Currency()); err != nil {
+		return db.ErrPayerUnavailable
+	}
+
+	pr.ID = int64(len(m.Requests) + 1)
+	pr.Currency = pr.Amount.Currency()
+	pr.Status = db.RequestPending
+	m.Requests[pr.ID] = pr
 	return nil
 }
 
+func (m *MockDB) GetPaymentRequest(id int64) (*db.PaymentRequest, error) {
+	pr, ok := m.Requests[id]
+	if !ok {
+		return nil, db.ErrPaymentRequestNotFound
+	}
+	return pr, nil
+}
+
+func (m *MockDB) GetPaymentRequests(q db.PaymentRequestQuery) ([]*db.PaymentRequest, error) {
+	var requests []*db.PaymentRequest
+	for id := int64(len(m.Requests)); id > 0; id-- {
+		pr := m.Requests[id]
+		if (pr.PayerID == q.UserID || (!q.Incoming && pr.RequesterID == q.UserID)) && (q.Status == "" || pr.Status == q.Status) {
+			requests = append(requests, pr)
+		}
+	}
+	return requests, nil
+}
+
+func (m *MockDB) AcceptPaymentRequest(id, fromWalletID int64) (*db.PaymentRequest, error) {
+	pr, err := m.pendingRequest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := &db.Transfer{FromWalletID: fromWalletID, ToWalletID: pr.ToWalletID, Amount: pr.Amount}
+	if err := m.TransferFunds(transfer); err != nil {
+		return nil, err
+	}
+
+	pr.Status, pr.FromWalletID = db.RequestAccepted, fromWalletID
+	pr.SendTransactionID, pr.ReceiveTransactionID = transfer.Send.ID, transfer.Receive.ID
+	return pr, nil
+}
+
+func (m *MockDB) DeclinePaymentRequest(id int64) (*db.PaymentRequest, error) {
+	pr, err := m.pendingRequest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pr.Status = db.RequestDeclined
+	return pr, nil
+}
+
+func (m *MockDB) CancelPaymentRequest(id int64) (*db.PaymentRequest, error) {
+	pr, err := m.pendingRequest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pr.Status = db.RequestCancelled
+	return pr, nil
+}
+
+func (m *MockDB) pendingRequest(id int64) (*db.PaymentRequest, error) {
+	pr, ok := m.Requests[id]
+	if !ok {
+		return nil, db.ErrPaymentRequestNotFound
+	}
+	if pr.Status != db.RequestPending {
+		return nil, db.ErrPaymentRequestNotPending
+	}
+	return pr, nil
+}
+
+func (m *MockDB) GetFXQuote(id string) (*db.FXQuote, error) {
+	quote, ok := m.Quotes[id]
+	if !ok {
+		return nil, db.ErrQuoteNotFound
+	}
+	return quote, nil
+}
+
+func (m *MockDB) GetUserByEmail(email string) (*db.User, error) {
+	for _, user := range m.Users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, db.ErrUserNotFound
+}
+
+func (m *MockDB) GetUserByPhoneNumber(phoneNumber string) (*db.User, error) {
+	for _, user := range m.Users {
+		if user.PhoneNumber == phoneNumber {
+			return user, nil
+		}
+	}
+	return nil, db.ErrUserNotFound
+}
+
+func usd(t *testing.T, amount string) money.Money {
+	t.Helper()
+
+	m, err := money.Parse(amount, "USD")
+	if err != nil {
+		t.Fatalf("failed to parse amount %q: %v", amount, err)
+	}
+	return m
+}
+
+// withUser marks req as authenticated for userID, as JWTMiddleware would
+func withUser(req *http.Request, userID int64) *http.Request {
+	return req.WithContext(utils.ContextWithUserID(req.Context(), userID))
+}
+
 func setupRouterWithMockDB(mockDB WalletDBInterface) *mux.Router {
 	r := mux.NewRouter()
 	handler := &WalletHandler{DB: mockDB}
@@ -163,6 +643,7 @@ func TestCreateNewWallet(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 	req := httptest.NewRequest("POST", "/wallets/new", bytes.NewReader(body))
+	req = withUser(req, 1)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -170,7 +651,7 @@ func TestCreateNewWallet(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 	// assert.Contains(t, w.Body.String(), "Wallet created successfully")
-	wallet, _ := mockDB.GetWalletByUserID(1)
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
 	assert.NotNil(t, wallet)
 }
 
@@ -191,6 +672,7 @@ func TestDeposit(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 	req := httptest.NewRequest("POST", "/wallets/deposit", bytes.NewReader(body))
+	req = withUser(req, 1)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token) // Add JWT token to the Authorization header
 	w := httptest.NewRecorder()
@@ -204,14 +686,14 @@ func TestDeposit(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Deposited successful")
-	wallet, _ := mockDB.GetWalletByUserID(1)
-	assert.Equal(t, 100.0, wallet.Balance)
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	assert.Equal(t, "100.00", wallet.Balance.String())
 }
 
 func TestWithdraw(t *testing.T) {
 	mockDB := NewMockDB()
-	mockDB.CreateWallet(1)
-	mockDB.UpdateWalletBalance(1, 100.0)
+	mockDB.CreateWallet(1, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "100"))
 	r := setupRouterWithMockDB(mockDB)
 
 	payload := map[string]interface{}{
@@ -220,6 +702,7 @@ func TestWithdraw(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 	req := httptest.NewRequest("POST", "/wallets/withdraw", bytes.NewReader(body))
+	req = withUser(req, 1)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -227,16 +710,41 @@ func TestWithdraw(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Withdrawal successful")
-	wallet, _ := mockDB.GetWalletByUserID(1)
-	assert.Equal(t, 50.0, wallet.Balance)
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	assert.Equal(t, "50.00", wallet.Balance.String())
+}
+
+func TestWithdrawInsufficientFunds(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(1, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "20"))
+	r := setupRouterWithMockDB(mockDB)
+
+	payload := map[string]interface{}{
+		"user_id": 1,
+		"amount":  "20.01",
+	}
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/wallets/withdraw", bytes.NewReader(body))
+	req = withUser(req, 1)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Insufficient funds")
+	wallet, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	assert.Equal(t, "20.00", wallet.Balance.String())
+	assert.Empty(t, mockDB.Transactions)
 }
 
 func TestTransfer(t *testing.T) {
 	mockDB := NewMockDB()
-	fromWallet, _ := mockDB.CreateWallet(1)
-	toWallet, _ := mockDB.CreateWallet(2)
-	mockDB.UpdateWalletBalance(1, 100.0)
-	mockDB.UpdateWalletBalance(2, 20.0)
+	fromWallet, _ := mockDB.CreateWallet(1, "USD")
+	toWallet, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "100"))
+	mockDB.UpdateWalletBalance(2, usd(t, "20"))
 	r := setupRouterWithMockDB(mockDB)
 
 	payload := map[string]interface{}{
@@ -246,6 +754,7 @@ func TestTransfer(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 	req := httptest.NewRequest("POST", "/wallets/transfer", bytes.NewReader(body))
+	req = withUser(req, 1)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -253,47 +762,49 @@ func TestTransfer(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Transfered successfully")
-	wallet1, _ := mockDB.GetWalletByUserID(1)
-	wallet2, _ := mockDB.GetWalletByUserID(2)
-	assert.Equal(t, 70.0, wallet1.Balance)
-	assert.Equal(t, 50.0, wallet2.Balance)
+	wallet1, _ := mockDB.GetWalletByUserIDAndCurrency(1, "USD")
+	wallet2, _ := mockDB.GetWalletByUserIDAndCurrency(2, "USD")
+	assert.Equal(t, "70.00", wallet1.Balance.String())
+	assert.Equal(t, "50.00", wallet2.Balance.String())
 }
 
 func TestBalance(t *testing.T) {
 	mockDB := NewMockDB()
-	mockDB.CreateWallet(1)
-	mockDB.UpdateWalletBalance(1, 150.0)
+	mockDB.CreateWallet(1, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "150"))
 	r := setupRouterWithMockDB(mockDB)
 
 	req := httptest.NewRequest("GET", "/wallets/balance?user_id=1", nil)
+	req = withUser(req, 1)
 	w := httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), `"balance":150`)
+	assert.Contains(t, w.Body.String(), `"balance":"150.00"`)
 }
 
 func TestTransactions(t *testing.T) {
 	mockDB := NewMockDB()
-	mockDB.CreateWallet(1)
-	mockDB.UpdateWalletBalance(1, 150.0)
+	mockDB.CreateWallet(1, "USD")
+	mockDB.UpdateWalletBalance(1, usd(t, "150"))
 	mockDB.CreateTransaction(&db.Transaction{
 		FromWalletID: 1,
 		Type:         "deposit",
-		Amount:       150.0,
+		Amount:       usd(t, "150"),
 		CreatedAt:    time.Now(),
 	})
 	mockDB.CreateTransaction(&db.Transaction{
 		FromWalletID: 1,
 		Type:         "withdrawal",
-		Amount:       50.0,
+		Amount:       usd(t, "50"),
 		CreatedAt:    time.Now(),
 	})
 
 	r := setupRouterWithMockDB(mockDB)
 
 	req := httptest.NewRequest("GET", "/wallets/transactions?wallet_id=1", nil)
+	req = withUser(req, 1)
 	w := httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
@@ -302,3 +813,188 @@ func TestTransactions(t *testing.T) {
 	assert.Contains(t, w.Body.String(), `"type":"deposit"`)
 	assert.Contains(t, w.Body.String(), `"type":"withdrawal"`)
 }
+
+func TestTransactionsPagesAndFilters(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(1, "USD")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, amount := range []string{"10", "40", "20", "30"} {
+		txType := "deposit"
+		if i%2 == 1 {
+			txType = "withdrawal"
+		}
+		mockDB.CreateTransaction(&db.Transaction{FromWalletID: 1, Type: txType, Amount: usd(t, amount), Status: "completed", CreatedAt: start.AddDate(0, 0, i)})
+	}
+	r := setupRouterWithMockDB(mockDB)
+
+	get := func(query string) (*httptest.ResponseRecorder, TransactionPage) {
+		req := withUser(httptest.NewRequest("GET", "/wallets/transactions?wallet_id=1&"+query, nil), 1)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var page TransactionPage
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return w, page
+	}
+	ids := func(page TransactionPage) []int64 {
+		var ids []int64
+		for _, txn := range page.Transactions {
+			ids = append(ids, txn.ID)
+		}
+		return ids
+	}
+
+	w, page := get("limit=3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int64{4, 3, 2}, ids(page))
+	assert.NotEmpty(t, page.NextCursor)
+
+	_, page = get("limit=3&cursor=" + page.NextCursor)
+	assert.Equal(t, []int64{1}, ids(page))
+	assert.Empty(t, page.NextCursor)
+
+	_, page = get("sort=amount&order=asc&limit=2")
+	assert.Equal(t, []int64{1, 3}, ids(page))
+	cursor := page.NextCursor
+
+	_, page = get("sort=amount&order=asc&limit=2&cursor=" + cursor)
+	assert.Equal(t, []int64{4, 2}, ids(page))
+
+	_, page = get("type=deposit&min_amount=15")
+	assert.Equal(t, []int64{3}, ids(page))
+
+	_, page = get("from=2026-03-02&to=2026-03-03")
+	assert.Equal(t, []int64{3, 2}, ids(page))
+
+	// a cursor only continues the order it was returned for
+	w, _ = get("cursor=" + cursor)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w, _ = get("type=refund&limit=500&min_amount=1.001&sort=fee&cursor=x")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var resp validationResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, []string{"limit", "type", "min_amount", "sort", "cursor"}, fieldNames(resp.Fields))
+}
+
+func TestMultiCurrencyWallets(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.CreateWallet(1, "USD")
+	r := setupRouterWithMockDB(mockDB)
+
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 1, "currency": "EUR"})
+	req := httptest.NewRequest("POST", "/wallets/new", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"currency":"EUR"`)
+
+	// A second EUR wallet is rejected
+	req = httptest.NewRequest("POST", "/wallets/new", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Unknown currencies are rejected
+	body, _ = json.Marshal(map[string]interface{}{"user_id": 1, "currency": "XYZ"})
+	req = httptest.NewRequest("POST", "/wallets/new", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// With two wallets the deposit must name a currency
+	body, _ = json.Marshal(map[string]interface{}{"user_id": 1, "amount": "10"})
+	req = httptest.NewRequest("POST", "/wallets/deposit", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{"user_id": 1, "amount": "10", "currency": "EUR"})
+	req = httptest.NewRequest("POST", "/wallets/deposit", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	eur, _ := mockDB.GetWalletByUserIDAndCurrency(1, "EUR")
+	assert.Equal(t, "10.00", eur.Balance.String())
+
+	req = httptest.NewRequest("GET", "/wallets/balance?user_id=1", nil)
+	req = withUser(req, 1)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var wallets []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &wallets))
+	assert.Len(t, wallets, 2)
+}
+
+func TestTransferRejectsCurrencyMismatch(t *testing.T) {
+	mockDB := NewMockDB()
+	fromWallet, _ := mockDB.CreateWallet(1, "USD")
+	toWallet, _ := mockDB.CreateWallet(2, "EUR")
+	mockDB.UpdateWalletBalance(fromWallet.ID, usd(t, "100"))
+	r := setupRouterWithMockDB(mockDB)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"from_wallet_id": fromWallet.ID,
+		"to_wallet_id":   toWallet.ID,
+		"amount":         "10",
+	})
+	req := httptest.NewRequest("POST", "/wallets/transfer", bytes.NewReader(body))
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Currency mismatch")
+	assert.Equal(t, "100.00", fromWallet.Balance.String())
+}
+
+func TestWalletOwnershipIsEnforced(t *testing.T) {
+	mockDB := NewMockDB()
+	victimWallet, _ := mockDB.CreateWallet(1, "USD")
+	attackerWallet, _ := mockDB.CreateWallet(2, "USD")
+	mockDB.UpdateWalletBalance(victimWallet.ID, usd(t, "100"))
+	r := setupRouterWithMockDB(mockDB)
+
+	requests := []struct {
+		method string
+		path   string
+		body   map[string]interface{}
+	}{
+		{"POST", "/wallets/withdraw", map[string]interface{}{"user_id": 1, "amount": "10"}},
+		{"POST", "/wallets/deposit", map[string]interface{}{"user_id": 1, "amount": "10"}},
+		{"POST", "/wallets/new", map[string]interface{}{"user_id": 1, "currency": "EUR"}},
+		{"POST", "/wallets/transfer", map[string]interface{}{"from_wallet_id": victimWallet.ID, "to_wallet_id": attackerWallet.ID, "amount": "10"}},
+		{"GET", "/wallets/balance?user_id=1", nil},
+		{"GET", "/wallets/transactions?wallet_id=1", nil},
+	}
+
+	for _, tc := range requests {
+		body, _ := json.Marshal(tc.body)
+		req := withUser(httptest.NewRequest(tc.method, tc.path, bytes.NewReader(body)), 2)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, "%s %s", tc.method, tc.path)
+	}
+
+	assert.Equal(t, "100.00", victimWallet.Balance.String())
+	assert.Equal(t, "0.00", attackerWallet.Balance.String())
+}
+
+func TestWalletRequiresAuthenticatedUser(t *testing.T) {
+	r := setupRouterWithMockDB(NewMockDB())
+
+	req := httptest.NewRequest("GET", "/wallets/balance", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
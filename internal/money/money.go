@@ -0,0 +1,336 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrOverflow         = errors.New("amount out of range")
+)
+
+// Currency describes an ISO 4217 currency and the number of minor-unit
+// digits amounts in it are rounded to.
+type Currency struct {
+	Code     string
+	Exponent int32
+}
+
+// currencies lists the supported currencies. The schema stores amounts as
+// NUMERIC(20, 2), so only currencies with at most two minor-unit digits are
+// accepted.
+var currencies = map[string]Currency{
+	"AUD": {Code: "AUD", Exponent: 2},
+	"BDT": {Code: "BDT", Exponent: 2},
+	"CAD": {Code: "CAD", Exponent: 2},
+	"CHF": {Code: "CHF", Exponent: 2},
+	"CNY": {Code: "CNY", Exponent: 2},
+	"EUR": {Code: "EUR", Exponent: 2},
+	"GBP": {Code: "GBP", Exponent: 2},
+	"INR": {Code: "INR", Exponent: 2},
+	"JPY": {Code: "JPY", Exponent: 0},
+	"KRW": {Code: "KRW", Exponent: 0},
+	"SGD": {Code: "SGD", Exponent: 2},
+	"USD": {Code: "USD", Exponent: 2},
+}
+
+// LookupCurrency returns the currency for an ISO 4217 code
+func LookupCurrency(code string) (Currency, error) {
+	c, ok := currencies[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+
+	return c, nil
+}
+
+// Money is an exact amount of a currency, held as an integer number of
+// minor units (cents for USD).
+type Money struct {
+	amount   int64
+	currency string
+}
+
+// New returns an amount of minor units in the given currency
+func New(minor int64, currency string) (Money, error) {
+	if _, err := LookupCurrency(currency); err != nil {
+		return Money{}, err
+	}
+
+	return Money{amount: minor, currency: currency}, nil
+}
+
+// Zero returns a zero amount in the given currency
+func Zero(currency string) Money {
+	return Money{currency: currency}
+}
+
+// Parse parses a decimal string such as "12.50" into the given currency.
+// Amounts with more fractional digits than the currency allows are rejected
+// instead of rounded.
+func Parse(s, currency string) (Money, error) {
+	c, err := LookupCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	frac = strings.TrimRight(frac, "0")
+	if int32(len(frac)) > c.Exponent {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, s, c.Exponent)
+	}
+	frac += strings.Repeat("0", int(c.Exponent)-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	if neg {
+		minor = -minor
+	}
+
+	return Money{amount: minor, currency: currency}, nil
+}
+
+// FromNumeric converts a NUMERIC value read from the database into the given
+// currency. Values that are not a whole number of minor units are rejected.
+func FromNumeric(n pgtype.Numeric, currency string) (Money, error) {
+	c, err := LookupCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if !n.Valid || n.NaN || n.InfinityModifier != pgtype.Finite {
+		return Money{}, fmt.Errorf("%w: not a finite number", ErrInvalidAmount)
+	}
+
+	v := new(big.Int)
+	if n.Int != nil {
+		v.Set(n.Int)
+	}
+
+	shift := n.Exp + c.Exponent
+	if shift >= 0 {
+		v.Mul(v, pow10(shift))
+	} else {
+		var rem big.Int
+		v.QuoRem(v, pow10(-shift), &rem)
+		if rem.Sign() != 0 {
+			return Money{}, fmt.Errorf("%w: more than %d decimal places", ErrInvalidAmount, c.Exponent)
+		}
+	}
+
+	if !v.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{amount: v.Int64(), currency: currency}, nil
+}
+
+// Amount returns the amount in minor units
+func (m Money) Amount() int64 {
+	return m.amount
+}
+
+// Currency returns the ISO 4217 currency code
+func (m Money) Currency() string {
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.amount > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return Money{amount: -m.amount, currency: m.currency}
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+
+	if (o.amount > 0 && m.amount > math.MaxInt64-o.amount) || (o.amount < 0 && m.amount < math.MinInt64-o.amount) {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{amount: m.amount + o.amount, currency: m.currency}, nil
+}
+
+// Sub returns m - o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+
+	return m.Add(o.Neg())
+}
+
+// Cmp compares m and o and returns -1, 0 or +1. Both amounts must be in the
+// same currency.
+func (m Money) Cmp(o Money) (int, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.amount < o.amount:
+		return -1, nil
+	case m.amount > o.amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Mul multiplies m by r and rounds the result to the currency's minor unit
+// using round-half-to-even.
+func (m Money) Mul(r *big.Rat) (Money, error) {
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), r)
+
+	rounded := RoundHalfEven(v)
+	if !rounded.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{amount: rounded.Int64(), currency: m.currency}, nil
+}
+
+// Convert converts m into currency at rate, the price of one major unit of
+// m's currency in the target currency, rounding half to even.
+func (m Money) Convert(rate *big.Rat, currency string) (Money, error) {
+	c, err := LookupCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), rate)
+	v.Mul(v, new(big.Rat).SetFrac(pow10(c.Exponent), pow10(m.exponent())))
+
+	rounded := RoundHalfEven(v)
+	if !rounded.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{amount: rounded.Int64(), currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal, e.g. "12.50"
+func (m Money) String() string {
+	exp := m.exponent()
+	s := strconv.FormatInt(m.amount, 10)
+
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	if exp > 0 {
+		if len(s) <= int(exp) {
+			s = strings.Repeat("0", int(exp)-len(s)+1) + s
+		}
+		s = s[:len(s)-int(exp)] + "." + s[len(s)-int(exp):]
+	}
+
+	if neg {
+		s = "-" + s
+	}
+
+	return s
+}
+
+// MarshalJSON encodes the amount as a JSON string so clients never parse it
+// into a binary float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// ScanNumeric implements pgtype.NumericScanner. The currency must be set
+// beforehand, e.g. with Zero, since a NUMERIC column does not carry one.
+func (m *Money) ScanNumeric(n pgtype.Numeric) error {
+	v, err := FromNumeric(n, m.currency)
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
+
+// NumericValue implements pgtype.NumericValuer
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: big.NewInt(m.amount), Exp: -m.exponent(), Valid: true}, nil
+}
+
+func (m Money) exponent() int32 {
+	return currencies[m.currency].Exponent
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if m.currency != o.currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, o.currency)
+	}
+
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// RoundHalfEven rounds v to the nearest integer, breaking ties towards the
+// even neighbour.
+func RoundHalfEven(v *big.Rat) *big.Int {
+	q, r := new(big.Int).QuoRem(v.Num(), v.Denom(), new(big.Int))
+
+	// compare 2*|r| with the denominator to decide the rounding direction
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+
+	switch twice.Cmp(v.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(int64(v.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(v.Sign())))
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,141 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency string
+		minor    int64
+		err      error
+	}{
+		{"12.34", "USD", 1234, nil},
+		{"12", "USD", 1200, nil},
+		{"12.5", "USD", 1250, nil},
+		{"12.500", "USD", 1250, nil},
+		{"-0.01", "USD", -1, nil},
+		{"1500", "JPY", 1500, nil},
+		{"12.345", "USD", 0, ErrInvalidAmount},
+		{"15.5", "JPY", 0, ErrInvalidAmount},
+		{"abc", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"", "USD", 0, ErrInvalidAmount},
+		{"10", "XXX", 0, ErrUnknownCurrency},
+		{"99999999999999999999", "USD", 0, ErrOverflow},
+	}
+
+	for _, tc := range tests {
+		m, err := Parse(tc.input, tc.currency)
+		if tc.err != nil {
+			assert.True(t, errors.Is(err, tc.err), "Parse(%q, %s): expected %v, got %v", tc.input, tc.currency, tc.err, err)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.minor, m.Amount())
+		assert.Equal(t, tc.currency, m.Currency())
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "12.34", Money{amount: 1234, currency: "USD"}.String())
+	assert.Equal(t, "0.05", Money{amount: 5, currency: "USD"}.String())
+	assert.Equal(t, "-0.50", Money{amount: -50, currency: "USD"}.String())
+	assert.Equal(t, "1500", Money{amount: 1500, currency: "JPY"}.String())
+}
+
+func TestArithmeticRefusesMixedCurrencies(t *testing.T) {
+	usd, _ := New(1000, "USD")
+	eur, _ := New(1000, "EUR")
+
+	_, err := usd.Add(eur)
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+
+	_, err = usd.Sub(eur)
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+
+	_, err = usd.Cmp(eur)
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+
+	sum, err := usd.Add(usd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), sum.Amount())
+}
+
+func TestMulRoundsHalfToEven(t *testing.T) {
+	m, _ := New(1025, "USD")
+
+	// 10.25 * 0.5 = 5.125 -> 5.12
+	half, err := m.Mul(big.NewRat(1, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(512), half.Amount())
+
+	// 10.35 * 0.5 = 5.175 -> 5.18
+	m, _ = New(1035, "USD")
+	half, _ = m.Mul(big.NewRat(1, 2))
+	assert.Equal(t, int64(518), half.Amount())
+
+	m, _ = New(-1035, "USD")
+	half, _ = m.Mul(big.NewRat(1, 2))
+	assert.Equal(t, int64(-518), half.Amount())
+}
+
+func TestFromNumeric(t *testing.T) {
+	m, err := FromNumeric(pgtype.Numeric{Int: big.NewInt(15000), Exp: -2, Valid: true}, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15000), m.Amount())
+
+	m, err = FromNumeric(pgtype.Numeric{Int: big.NewInt(15), Exp: 1, Valid: true}, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15000), m.Amount())
+
+	m, err = FromNumeric(pgtype.Numeric{Int: big.NewInt(150000), Exp: -2, Valid: true}, "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), m.Amount())
+
+	_, err = FromNumeric(pgtype.Numeric{Int: big.NewInt(150050), Exp: -2, Valid: true}, "JPY")
+	assert.True(t, errors.Is(err, ErrInvalidAmount))
+
+	n, err := m.NumericValue()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), n.Int.Int64())
+	assert.Equal(t, int32(0), n.Exp)
+}
+
+func TestMarshalJSON(t *testing.T) {
+	m, _ := Parse("150", "USD")
+	b, err := json.Marshal(struct {
+		Balance Money `json:"balance"`
+	}{m})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"balance":"150.00"}`, string(b))
+}
+
+func TestConvert(t *testing.T) {
+	usd, _ := Parse("100", "USD")
+
+	eur, err := usd.Convert(big.NewRat(92, 100), "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "92.00", eur.String())
+	assert.Equal(t, "EUR", eur.Currency())
+
+	jpy, err := usd.Convert(big.NewRat(15025, 100), "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, "15025", jpy.String())
+
+	back, err := jpy.Convert(big.NewRat(100, 15025), "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "100.00", back.String())
+
+	_, err = usd.Convert(big.NewRat(1, 1), "XYZ")
+	assert.True(t, errors.Is(err, ErrUnknownCurrency))
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNotifierAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	n, err := New("file", path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, n.Send(context.Background(), Message{Channel: ChannelEmail, To: "a@example.com", Body: "first"}))
+	assert.NoError(t, n.Send(context.Background(), Message{Channel: ChannelSMS, To: "123", Body: "second"}))
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg Message
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		messages = append(messages, msg)
+	}
+
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "first", messages[0].Body)
+		assert.Equal(t, ChannelSMS, messages[1].Channel)
+		assert.False(t, messages[1].SentAt.IsZero())
+	}
+}
+
+func TestNewRejectsUnknownNotifier(t *testing.T) {
+	_, err := New("carrier-pigeon", "")
+	assert.Error(t, err)
+
+	_, err = New("file", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+// Package problem writes error responses as RFC 7807 problem details. Every
+// problem carries a stable, machine-readable code next to the human-readable
+// detail, so clients can branch on the code while the wording changes.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the code to form the problem type URI
+const TypeBase = "/problems/"
+
+// Generic codes, one per status, for errors without a more specific code
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeUnprocessable      = "unprocessable"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInternal           = "internal_error"
+	CodeBadGateway         = "bad_gateway"
+	CodeServiceUnavailable = "service_unavailable"
+)
+
+// Specific codes
+const (
+	CodeValidationFailed   = "validation_failed"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeTokenInvalid       = "token_invalid"
+	CodeTokenRevoked       = "token_revoked"
+	CodeUserNotFound       = "user_not_found"
+	CodeWalletNotFound     = "wallet_not_found"
+	CodeDuplicate          = "duplicate"
+	CodeEmailTaken         = "email_taken"
+	CodePhoneTaken         = "phone_number_taken"
+	CodeWalletExists       = "wallet_exists"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeWalletFrozen       = "wallet_frozen"
+	CodeCurrencyMismatch   = "currency_mismatch"
+	CodeCurrencyRequired   = "currency_required"
+	CodeQuoteNotFound      = "quote_not_found"
+	CodeQuoteExpired       = "quote_expired"
+	CodeQuoteUsed          = "quote_used"
+	CodeQuoteMismatch      = "quote_mismatch"
+	CodeRateNotFound       = "rate_not_found"
+	CodeFeeRuleNotFound    = "fee_rule_not_found"
+	CodeInvalidFeeRule     = "invalid_fee_rule"
+	CodeSessionNotFound    = "session_not_found"
+	CodeRefreshTokenReused = "refresh_token_reused"
+	CodeMFARequired        = "mfa_required"
+	CodeMFAChallenge       = "mfa_challenge_invalid"
+	CodeInvalidTOTPCode    = "invalid_totp_code"
+	CodeTOTPNotEnrolled    = "totp_not_enrolled"
+	CodeTOTPEnabled        = "totp_already_enabled"
+	CodeWrongPassword      = "wrong_password"
+	CodePasswordUnchanged  = "password_not_changed"
+	CodeResetTokenInvalid  = "reset_token_invalid"
+	CodeUnknownContact     = "unknown_contact_channel"
+	CodeVerificationCode   = "verification_code_invalid"
+	CodeVerifyThrottled    = "verification_throttled"
+	CodeResendTooSoon      = "resend_too_soon"
+	CodeResetThrottled     = "reset_throttled"
+	CodeUnverified         = "contact_not_verified"
+	CodeLoginThrottled     = "login_throttled"
+	CodeTOTPThrottled      = "totp_throttled"
+	CodeIdempotencyKey     = "idempotency_key_conflict"
+	CodeHoldNotFound       = "hold_not_found"
+	CodeHoldNotActive      = "hold_not_active"
+	CodeCaptureExceedsHold = "capture_exceeds_hold"
+	CodeTxNotFound         = "transaction_not_found"
+	CodeNotReversible      = "transaction_not_reversible"
+	CodeAlreadyRefunded    = "already_refunded"
+	CodeRefundExceeds      = "refund_exceeds_original"
+	CodeScheduleNotFound   = "schedule_not_found"
+	CodeScheduleNotActive  = "schedule_not_active"
+	CodeInvalidSchedule    = "invalid_schedule"
+	CodeRequestNotFound    = "payment_request_not_found"
+	CodeRequestNotPending  = "payment_request_not_pending"
+	CodePayerUnavailable   = "payer_unavailable"
+	CodeRecipientNotFound  = "recipient_not_found"
+)
+
+// Problem is an RFC 7807 problem details object. Fields lists invalid
+// request fields for validation problems.
+type Problem struct {
+	Type   string  `json:"type"`
+	Title  string  `json:"title"`
+	Status int     `json:"status"`
+	Code   string  `json:"code"`
+	Detail string  `json:"detail,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Field is an invalid request field
+type Field struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New returns the problem for status with code and detail
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   TypeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// CodeFor returns the generic code of status
+func CodeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusBadGateway:
+		return CodeBadGateway
+	case http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	}
+
+	return CodeInternal
+}
+
+// Write sends p
+func (p *Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error sends the problem for status with code and detail. An empty code
+// stands for the generic code of status.
+func Error(w http.ResponseWriter, status int, code, detail string) {
+	if code == "" {
+		code = CodeFor(status)
+	}
+
+	New(status, code, detail).Write(w)
+}
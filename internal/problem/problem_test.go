@@ -0,0 +1,25 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	w := httptest.NewRecorder()
+	Error(w, http.StatusNotFound, "", "User not found")
+
+	var p Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, Problem{Type: "/problems/not_found", Title: "Not Found", Status: 404, Code: CodeNotFound, Detail: "User not found"}, p)
+
+	w = httptest.NewRecorder()
+	Error(w, http.StatusConflict, CodeWalletExists, "")
+	assert.JSONEq(t, `{"type": "/problems/wallet_exists", "title": "Conflict", "status": 409, "code": "wallet_exists"}`, w.Body.String())
+}
@@ -1,67 +1,123 @@
 package utils
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
+	"encoding/hex"
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/masudcsesust04/ewallet-api/internal/db"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
 )
 
-var (
-	JWTSecretKey = []byte(os.Getenv("JWT_SECRET"))
-)
+type contextKey string
+
+const userIDContextKey contextKey = "user_id"
+
+// ContextWithUserID returns a copy of ctx carrying the authenticated user ID
+func ContextWithUserID(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, userIDContextKey, userID)
+}
+
+// UserIDFromContext returns the authenticated user ID stored by JWTMiddleware
+func UserIDFromContext(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(int64)
+	return userID, ok
+}
 
 // JWTMiddleware is a middleware to validate JWT token in Authorization header
 func JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+			problem.Error(w, http.StatusUnauthorized, "", "Authorization header missing")
 			return
 		}
 
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+			problem.Error(w, http.StatusUnauthorized, "", "Invalid Authorization header format")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if string(JWTSecretKey) == "" {
-			http.Error(w, "Server configuration error", http.StatusInternalServerError)
+		if keyRing == nil {
+			problem.Error(w, http.StatusInternalServerError, "", "Server configuration error")
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(JWTSecretKey), nil
-		})
+		token, err := keyRing.Parse(tokenString)
 
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			problem.Error(w, http.StatusUnauthorized, problem.CodeTokenInvalid, "Invalid or expired token")
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			problem.Error(w, http.StatusUnauthorized, problem.CodeTokenInvalid, "Invalid token claims")
+			return
+		}
+
+		userID, ok := claims["user_id"].(float64)
+		if !ok {
+			problem.Error(w, http.StatusUnauthorized, problem.CodeTokenInvalid, "Invalid token claims")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// tokens issued before sessions existed carry no sid claim; for
+		// those only the user's status is checked
+		sessionID, _ := claims["sid"].(float64)
+		if tokenState != nil {
+			active, err := tokenState.Active(int64(userID), int64(sessionID))
+			if err != nil {
+				log.Printf("failed to validate token: %v", err)
+				problem.Error(w, http.StatusInternalServerError, "", "Failed to validate token")
+				return
+			}
+			if !active {
+				problem.Error(w, http.StatusUnauthorized, problem.CodeTokenRevoked, "Token has been revoked")
+				return
+			}
+		}
+
+		// tokens issued before roles existed carry no role claim
+		role, _ := claims["role"].(string)
+		if role == "" {
+			role = RoleCustomer
+		}
+
+		ctx := ContextWithUserID(r.Context(), int64(userID))
+		next.ServeHTTP(w, r.WithContext(ContextWithRole(ctx, role)))
 	}
 }
 
-func GenerateAccessToken(userID int64) (string, error) {
+// GenerateAccessToken issues a short-lived access token carrying the user's
+// ID and role, and the session it was issued for
+func GenerateAccessToken(userID, sessionID int64, role string) (string, error) {
+	jti, err := GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
+		"jti":     jti,
 		"user_id": userID,
+		"sid":     sessionID,
+		"role":    role,
 		"exp":     time.Now().Add(15 * time.Minute).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JWTSecretKey)
+	if keyRing == nil {
+		return "", errors.New("no signing keys configured")
+	}
+
+	return keyRing.Sign(claims)
 }
 
 func GenerateRefreshToken() string {
@@ -75,14 +131,6 @@ func GenerateRefreshToken() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func ValidateRefreshToken(refreshToken *db.RefreshToken) error {
-	if time.Now().After(refreshToken.ExpiresAt) {
-		return fmt.Errorf("refresh token has expired")
-	}
-
-	return nil
-}
-
 func GenerateSecureToken(length int) (string, error) {
 	bytes := make([]byte, length)
 	_, err := rand.Read(bytes)
@@ -93,11 +141,10 @@ func GenerateSecureToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
-func HashToken(token string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
-	return string(hash), err
-}
-
-func CompareToken(hash, token string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(token))
+// HashToken returns the hex SHA-256 digest of a random token. Tokens are
+// stored hashed and looked up by their digest, so a leaked table can not be
+// replayed; the tokens carry enough entropy that a salt is not needed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
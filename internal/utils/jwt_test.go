@@ -3,7 +3,6 @@ package utils
 import (
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 	"time"
 
@@ -17,10 +16,6 @@ type Claims struct {
 }
 
 func TestJWTMiddleware(t *testing.T) {
-	// Set the JWT_SECRET environment variable for testing
-	os.Setenv("JWT_SECRET", "testsecretkey")
-	defer os.Unsetenv("JWT_SECRET")
-
 	// A simple handler to test middleware passing
 	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -73,6 +68,27 @@ func TestJWTMiddleware(t *testing.T) {
 	}
 }
 
+func TestJWTMiddlewareSetsUserID(t *testing.T) {
+	var gotUserID int64
+	handler := JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = UserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+generateTestToken(t))
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if gotUserID != 1 {
+		t.Fatalf("expected user id 1 in context, got %d", gotUserID)
+	}
+}
+
 func generateTestToken(t *testing.T) string {
 	t.Helper()
 
@@ -81,8 +97,7 @@ func generateTestToken(t *testing.T) string {
 		"exp":     time.Now().Add(time.Hour).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JWTSecretKey)
+	tokenString, err := keyRing.Sign(claims)
 	if err != nil {
 		t.Fatalf("Failed to generate test token: %v", err)
 	}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRingManifest is the optional file in a key directory that picks the
+// signing key and lists retired keys
+const KeyRingManifest = "keyring.json"
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("retired signing key")
+)
+
+// SigningKey is one key of a KeyRing. Keys without a private half can only
+// verify tokens, e.g. the previous key of a rotation whose private key was
+// already destroyed.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+	Retired bool
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private or public key. RSA keys sign
+// with RS256 and Ed25519 keys with EdDSA.
+func NewSigningKey(id string, key any) (*SigningKey, error) {
+	k := &SigningKey{ID: id}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.Private, k.Public = key, &key.PublicKey
+	case ed25519.PrivateKey:
+		k.Private, k.Public = key, key.Public()
+	case *rsa.PublicKey, ed25519.PublicKey:
+		k.Public = key
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, key)
+	}
+
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least 2048 bits", id)
+		}
+		k.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		k.Method = jwt.SigningMethodEdDSA
+	}
+
+	return k, nil
+}
+
+// KeyRing holds the keys access tokens are signed and verified with. New
+// tokens are signed with the active key and carry its ID in the kid header;
+// tokens signed with any other key that is not retired are still accepted,
+// so keys can be rolled without logging everyone out.
+type KeyRing struct {
+	keys   map[string]*SigningKey
+	active *SigningKey
+}
+
+// NewKeyRing returns a key ring that signs with the key activeID
+func NewKeyRing(activeID string, keys ...*SigningKey) (*KeyRing, error) {
+	k := &KeyRing{keys: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+	}
+
+	active, ok := k.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, activeID)
+	}
+	if active.Private == nil || active.Retired {
+		return nil, fmt.Errorf("active key %q must be a private key that is not retired", activeID)
+	}
+
+	k.active = active
+	return k, nil
+}
+
+// LoadKeyRing loads every *.pem file in dir as a key named after the file,
+// e.g. 2026-10.pem becomes kid "2026-10". A file holds a PKCS#8 or PKCS#1
+// private key, or a PKIX public key for verify-only keys.
+//
+// keyring.json in dir may name the active key and the retired ones:
+//
+//	{"active": "2026-10", "retired": ["2025-04"]}
+//
+// Without it the private key whose name sorts last is active.
+func LoadKeyRing(dir string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var manifest struct {
+		Active  string   `json:"active"`
+		Retired []string `json:"retired"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, KeyRingManifest))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", KeyRingManifest, err)
+		}
+	}
+
+	retired := make(map[string]bool)
+	for _, id := range manifest.Retired {
+		retired[id] = true
+	}
+
+	var keys []*SigningKey
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadPEMKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+
+		key.Retired = retired[id]
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+
+	if manifest.Active == "" {
+		for i := len(keys) - 1; i >= 0; i-- {
+			if keys[i].Private != nil && !keys[i].Retired {
+				manifest.Active = keys[i].ID
+				break
+			}
+		}
+	}
+
+	return NewKeyRing(manifest.Active, keys...)
+}
+
+func loadPEMKey(id, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	return NewSigningKey(id, key)
+}
+
+// Sign signs claims with the active key
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.Private)
+}
+
+// Parse verifies tokenString against the key named in its kid header
+func (k *KeyRing) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, k.verificationKey, jwt.WithValidMethods([]string{
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}))
+}
+
+func (k *KeyRing) verificationKey(token *jwt.Token) (any, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	if key.Retired {
+		return nil, fmt.Errorf("%w: %q", ErrRetiredKey, id)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", id, token.Method.Alg())
+	}
+
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of all keys that are not retired, sorted
+// by key ID
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if key.Retired {
+			continue
+		}
+
+		jwk := JWK{KeyID: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+var keyRing *KeyRing
+
+// SetKeyRing sets the keys GenerateAccessToken and JWTMiddleware use
+func SetKeyRing(k *KeyRing) {
+	keyRing = k
+}
+
+// CurrentKeyRing returns the key ring set with SetKeyRing
+func CurrentKeyRing() *KeyRing {
+	return keyRing
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMain(m *testing.M) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	key, _ := NewSigningKey("test", priv)
+	ring, err := NewKeyRing("test", key)
+	if err != nil {
+		panic(err)
+	}
+	SetKeyRing(ring)
+
+	os.Exit(m.Run())
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoadKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	writePEM(t, filepath.Join(dir, "2026-01.pem"), "PRIVATE KEY", der)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ = x509.MarshalPKCS8PrivateKey(edKey)
+	writePEM(t, filepath.Join(dir, "2026-07.pem"), "PRIVATE KEY", der)
+
+	old, err := LoadKeyRing(dir)
+	if err != nil {
+		t.Fatalf("LoadKeyRing failed: %v", err)
+	}
+	if old.active.ID != "2026-07" {
+		t.Fatalf("expected the last key to be active, got %s", old.active.ID)
+	}
+
+	// sign with the RSA key, then roll over to the Ed25519 key
+	os.WriteFile(filepath.Join(dir, KeyRingManifest), []byte(`{"active": "2026-01"}`), 0o600)
+	ring, _ := LoadKeyRing(dir)
+	rsaToken, err := ring.Sign(jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, KeyRingManifest), []byte(`{"active": "2026-07"}`), 0o600)
+	ring, _ = LoadKeyRing(dir)
+	if _, err := ring.Parse(rsaToken); err != nil {
+		t.Fatalf("expected a token of the previous key to verify, got %v", err)
+	}
+	if jwks := ring.JWKS(); len(jwks.Keys) != 2 || jwks.Keys[0].KeyType != "RSA" || jwks.Keys[1].KeyType != "OKP" {
+		t.Fatalf("unexpected JWKS: %+v", jwks)
+	}
+
+	os.WriteFile(filepath.Join(dir, KeyRingManifest), []byte(`{"active": "2026-07", "retired": ["2026-01"]}`), 0o600)
+	ring, _ = LoadKeyRing(dir)
+	if _, err := ring.Parse(rsaToken); !errors.Is(err, ErrRetiredKey) {
+		t.Fatalf("expected ErrRetiredKey, got %v", err)
+	}
+	if jwks := ring.JWKS(); len(jwks.Keys) != 1 {
+		t.Fatalf("expected the retired key to be unpublished, got %+v", jwks)
+	}
+}
+
+func TestKeyRingRejectsHMACTokens(t *testing.T) {
+	claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "test"
+	signed, _ := token.SignedString([]byte("secret"))
+
+	if _, err := keyRing.Parse(signed); err == nil {
+		t.Fatal("expected an HS256 token to be rejected")
+	}
+}
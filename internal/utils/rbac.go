@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/masudcsesust04/ewallet-api/internal/problem"
+)
+
+// Roles a user can hold. Every user has exactly one role; new users are customers.
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+)
+
+// Permission names an action on resources that belong to other users.
+// Acting on one's own user and wallets needs no permission.
+type Permission string
+
+const (
+	PermReadUsers   Permission = "users:read"
+	PermListUsers   Permission = "users:list"
+	PermManageUsers Permission = "users:manage" // edit, ban, delete and change roles
+	PermReadWallets Permission = "wallets:read"
+	PermManageFees  Permission = "fees:manage"
+
+	// PermReverseTransactions reverses transfers received by other users
+	PermReverseTransactions Permission = "transactions:reverse"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleCustomer: {},
+	RoleSupport:  {PermReadUsers, PermReadWallets},
+	RoleAdmin:    {PermReadUsers, PermListUsers, PermManageUsers, PermReadWallets, PermManageFees, PermReverseTransactions},
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+const roleContextKey contextKey = "role"
+
+// ContextWithRole returns a copy of ctx carrying the authenticated user's role
+func ContextWithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey, role)
+}
+
+// RoleFromContext returns the role stored by JWTMiddleware, or RoleCustomer
+// when none is set
+func RoleFromContext(ctx context.Context) string {
+	if role, ok := ctx.Value(roleContextKey).(string); ok {
+		return role
+	}
+
+	return RoleCustomer
+}
+
+// RequirePermission is a middleware, placed inside JWTMiddleware, that
+// rejects callers whose role does not grant perm with 403
+func RequirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !HasPermission(RoleFromContext(r.Context()), perm) {
+			problem.Error(w, http.StatusForbidden, "", "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRolePermissions(t *testing.T) {
+	if HasPermission(RoleCustomer, PermReadUsers) {
+		t.Error("customers must not read other users")
+	}
+	if !HasPermission(RoleSupport, PermReadWallets) || HasPermission(RoleSupport, PermManageUsers) {
+		t.Error("support must read wallets but not manage users")
+	}
+	if !HasPermission(RoleAdmin, PermManageUsers) || !HasPermission(RoleAdmin, PermListUsers) {
+		t.Error("admins must manage and list users")
+	}
+	if HasPermission("root", PermReadUsers) {
+		t.Error("unknown roles must have no permissions")
+	}
+}
+
+func TestRequirePermissionUsesTokenRole(t *testing.T) {
+	handler := JWTMiddleware(RequirePermission(PermListUsers, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for role, expected := range map[string]int{
+		RoleCustomer: http.StatusForbidden,
+		RoleSupport:  http.StatusForbidden,
+		RoleAdmin:    http.StatusOK,
+		"":           http.StatusForbidden,
+	} {
+		claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+		if role != "" {
+			claims["role"] = role
+		}
+		token, err := keyRing.Sign(claims)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("role %q: expected status %d, got %d", role, expected, rr.Code)
+		}
+	}
+}
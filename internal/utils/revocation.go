@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTokenStateTTL is how long JWTMiddleware trusts a cached session
+// check. A revoked session or banned user is locked out at most this long
+// after the change, or immediately when the change goes through
+// ForgetTokenState in this process.
+const DefaultTokenStateTTL = 30 * time.Second
+
+// TokenStateStore reports whether access tokens of a user and session may
+// still be used. sessionID is 0 for tokens issued without a session.
+type TokenStateStore interface {
+	SessionActive(userID, sessionID int64) (bool, error)
+}
+
+type tokenStateKey struct {
+	userID    int64
+	sessionID int64
+}
+
+type tokenStateEntry struct {
+	active    bool
+	expiresAt time.Time
+}
+
+// TokenStateCache caches TokenStateStore answers for a short TTL so that
+// JWTMiddleware does not query the database on every request
+type TokenStateCache struct {
+	store TokenStateStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[tokenStateKey]tokenStateEntry
+	now     func() time.Time
+}
+
+// NewTokenStateCache returns a cache in front of store
+func NewTokenStateCache(store TokenStateStore, ttl time.Duration) *TokenStateCache {
+	return &TokenStateCache{
+		store:   store,
+		ttl:     ttl,
+		entries: make(map[tokenStateKey]tokenStateEntry),
+		now:     time.Now,
+	}
+}
+
+// Active reports whether tokens of the user and session are still accepted
+func (c *TokenStateCache) Active(userID, sessionID int64) (bool, error) {
+	key := tokenStateKey{userID: userID, sessionID: sessionID}
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.active, nil
+	}
+
+	active, err := c.store.SessionActive(userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	// drop stale entries while the lock is held anyway, so the map only
+	// holds users seen within the last TTL
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = tokenStateEntry{active: active, expiresAt: now.Add(c.ttl)}
+	c.mu.Unlock()
+
+	return active, nil
+}
+
+// Forget drops the cached state of every session of the user
+func (c *TokenStateCache) Forget(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if k.userID == userID {
+			delete(c.entries, k)
+		}
+	}
+}
+
+var tokenState *TokenStateCache
+
+// SetTokenStateCache makes JWTMiddleware reject access tokens whose session
+// was revoked or whose user is no longer active. Without it only the token
+// signature and expiry are checked.
+func SetTokenStateCache(c *TokenStateCache) {
+	tokenState = c
+}
+
+// ForgetTokenState drops the cached session state of the user, so that a
+// logout, revocation or status change applies to the next request
+func ForgetTokenState(userID int64) {
+	if tokenState != nil {
+		tokenState.Forget(userID)
+	}
+}
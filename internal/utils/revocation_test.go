@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeTokenStateStore struct {
+	active map[int64]bool // by session ID
+	calls  int
+}
+
+func (f *fakeTokenStateStore) SessionActive(userID, sessionID int64) (bool, error) {
+	f.calls++
+	return f.active[sessionID], nil
+}
+
+func TestTokenStateCacheExpires(t *testing.T) {
+	store := &fakeTokenStateStore{active: map[int64]bool{7: true}}
+	cache := NewTokenStateCache(store, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if active, _ := cache.Active(1, 7); !active {
+			t.Fatal("expected session 7 to be active")
+		}
+	}
+	if store.calls != 1 {
+		t.Fatalf("expected one store lookup, got %d", store.calls)
+	}
+
+	// the revocation is seen once the entry expires or is forgotten
+	store.active[7] = false
+	if active, _ := cache.Active(1, 7); !active {
+		t.Fatal("expected the cached answer within the TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if active, _ := cache.Active(1, 7); active {
+		t.Fatal("expected the revocation after the TTL")
+	}
+
+	store.active[7] = true
+	cache.Forget(1)
+	if active, _ := cache.Active(1, 7); !active {
+		t.Fatal("expected a fresh lookup after Forget")
+	}
+}
+
+func TestJWTMiddlewareRejectsRevokedSession(t *testing.T) {
+	store := &fakeTokenStateStore{active: map[int64]bool{7: true}}
+	SetTokenStateCache(NewTokenStateCache(store, time.Minute))
+	defer SetTokenStateCache(nil)
+
+	handler := JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	send := func(sessionID int64) int {
+		token, err := GenerateAccessToken(1, sessionID, RoleCustomer)
+		if err != nil {
+			t.Fatalf("failed to generate token: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := send(7); code != http.StatusOK {
+		t.Fatalf("expected status 200 for an active session, got %d", code)
+	}
+	if code := send(8); code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for a revoked session, got %d", code)
+	}
+}
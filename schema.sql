@@ -6,38 +6,332 @@ CREATE TABLE IF NOT EXISTS users (
     email VARCHAR(100) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     status VARCHAR(16) NOT NULL CHECK (status IN ('active', 'inactive', 'banned')),
+    role VARCHAR(16) NOT NULL DEFAULT 'customer' CHECK (role IN ('customer', 'support', 'admin')),
+    -- base32 TOTP secret; set on enrolment and active once totp_enabled.
+    -- totp_last_step is the time step of the last accepted code.
+    totp_secret VARCHAR(64) NULL,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    -- reset whenever the address changes
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    phone_verified BOOLEAN NOT NULL DEFAULT FALSE,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- users are looked up by email regardless of case, so no two emails may
+-- differ only in case
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_label VARCHAR(100) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+-- pending email and phone verification codes; code_hash is SHA-256 and
+-- destination the address the code was sent to
+CREATE TABLE IF NOT EXISTS verification_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('email', 'phone')),
+    destination VARCHAR(100) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, channel)
+);
+
+-- single-use password reset tokens; token_hash is SHA-256
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- single-use codes that stand in for the authenticator; code_hash is SHA-256
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- logins that passed the password check and wait for a TOTP code
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    device_label VARCHAR(100) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
+
+-- failed logins per account (the lower-cased email tried) and per IP
+-- address; locked_until is set once too many failures pile up
+CREATE TABLE IF NOT EXISTS login_failures (
+    subject VARCHAR(10) NOT NULL CHECK (subject IN ('account', 'ip', 'totp', 'verify', 'reset', 'reset_ip')),
+    key VARCHAR(100) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ NULL,
+
+    PRIMARY KEY (subject, key)
+);
+
+-- audit trail of lockouts and of admins lifting them
+CREATE TABLE IF NOT EXISTS lockout_events (
+    id SERIAL PRIMARY KEY,
+    subject VARCHAR(10) NOT NULL CHECK (subject IN ('account', 'ip', 'totp', 'verify', 'reset', 'reset_ip')),
+    key VARCHAR(100) NOT NULL,
+    event VARCHAR(10) NOT NULL CHECK (event IN ('locked', 'unlocked')),
+    failures INTEGER NOT NULL,
+    locked_until TIMESTAMPTZ NULL,
+    actor_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_lockout_events_subject_key ON lockout_events (subject, key);
+
+-- token holds the SHA-256 hash of the refresh token. A token is used once:
+-- refreshing sets used_at and issues a new token in the same session.
 CREATE TABLE IF NOT EXISTS refresh_tokens (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
     token VARCHAR(255) UNIQUE NOT NULL,
     expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE TABLE IF NOT EXISTS wallets (
     id SERIAL PRIMARY KEY,
-    user_id INTEGER UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     balance NUMERIC(20, 2) NOT NULL DEFAULT 0.0, 
     currency VARCHAR(3) NOT NULL DEFAULT 'USD',
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT wallets_user_id_currency_key UNIQUE (user_id, currency)
+);
+
+-- Double-entry ledger. Every money movement is a journal entry whose postings
+-- sum to zero per currency; wallets.balance is a cache of the postings on the
+-- wallet's ledger account.
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    wallet_id INTEGER UNIQUE NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    system_code VARCHAR(20) NULL CHECK (system_code IN ('cash_in', 'cash_out', 'fees', 'fx')),
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT ledger_accounts_owner_check CHECK ((wallet_id IS NULL) <> (system_code IS NULL)),
+    CONSTRAINT ledger_accounts_system_code_currency_key UNIQUE (system_code, currency)
+);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id SERIAL PRIMARY KEY,
+    description VARCHAR(256) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+CREATE TABLE IF NOT EXISTS postings (
+    id SERIAL PRIMARY KEY,
+    journal_entry_id INTEGER NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts(id) ON DELETE CASCADE,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount <> 0.0),
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_postings_account_id ON postings (account_id);
+CREATE INDEX IF NOT EXISTS idx_postings_journal_entry_id ON postings (journal_entry_id);
+
 CREATE TABLE IF NOT EXISTS transactions (
     id SERIAL PRIMARY KEY,
     type VARCHAR(20) NOT NULL CHECK (type IN('deposit', 'withdrawal', 'send', 'receive')), 
     from_wallet_id INTEGER NOT NULL,
     to_wallet_id INTEGER NULL,
     amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0.0), 
-    fee NUMERIC(8, 2) NOT NULL DEFAULT 0.0 CHECK(fee >= 0.0), 
+    fee NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK(fee >= 0.0), 
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
     note VARCHAR(256),
     status VARCHAR(16) NOT NULL CHECK (status IN('pending', 'processing', 'completed', 'failed', 'cancelled')),
+    journal_entry_id INTEGER NULL REFERENCES journal_entries(id),
+    exchange_rate NUMERIC(20, 10) NULL,
+    -- a reversal's records point at the send record of the transfer they
+    -- reverse, whose records count the amount refunded so far
+    reversal_of INTEGER NULL REFERENCES transactions(id),
+    refunded_amount NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK (refunded_amount >= 0.0 AND refunded_amount <= amount),
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 
     CONSTRAINT from_wallets_wallet_id_fkey FOREIGN KEY (from_wallet_id) REFERENCES wallets(id) ON DELETE CASCADE
 );
+
+-- Transaction histories are read a page at a time, newest first or by
+-- amount, from either side of a transfer
+CREATE INDEX IF NOT EXISTS idx_transactions_from_wallet_created_at ON transactions (from_wallet_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_from_wallet_amount ON transactions (from_wallet_id, amount, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_to_wallet_created_at ON transactions (to_wallet_id, created_at, id) WHERE to_wallet_id IS NOT NULL AND to_wallet_id <> 0;
+CREATE INDEX IF NOT EXISTS idx_transactions_journal_entry_id ON transactions (journal_entry_id);
+
+-- A hold reserves part of a wallet's balance for a payee without moving it,
+-- like a card authorization. Its pending send transaction is completed when
+-- the hold is captured and cancelled when it is voided or expires. Active
+-- holds count against the available balance until expires_at.
+CREATE TABLE IF NOT EXISTS holds (
+    id SERIAL PRIMARY KEY,
+    wallet_id INTEGER NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    payee_wallet_id INTEGER NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0.0),
+    captured_amount NUMERIC(20, 2) NULL CHECK (captured_amount > 0.0 AND captured_amount <= amount),
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_holds_wallet_id_active ON holds (wallet_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_holds_payee_wallet_id ON holds (payee_wallet_id);
+CREATE INDEX IF NOT EXISTS idx_holds_expires_at_active ON holds (expires_at) WHERE status = 'active';
+
+-- Scheduled transfers run once at start_at, or every day, week or month
+-- from start_at until end_at. next_run_at is when the scheduler runs the
+-- schedule next, a retry or the next occurrence; it is NULL once the schedule
+-- is over. occurrences counts the occurrences done and attempts the failed
+-- attempts at the current one.
+CREATE TABLE IF NOT EXISTS scheduled_transfers (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_wallet_id INTEGER NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    to_wallet_id INTEGER NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0.0),
+    currency VARCHAR(3) NOT NULL,
+    note VARCHAR(256) NOT NULL DEFAULT '',
+    frequency VARCHAR(16) NOT NULL CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+    start_at TIMESTAMPTZ NOT NULL,
+    end_at TIMESTAMPTZ NULL CHECK (end_at >= start_at),
+    next_run_at TIMESTAMPTZ NULL,
+    occurrences INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'completed', 'cancelled', 'failed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_user_id ON scheduled_transfers (user_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_due ON scheduled_transfers (next_run_at) WHERE status = 'active';
+
+-- Every attempt the scheduler makes at a scheduled transfer
+CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+    id SERIAL PRIMARY KEY,
+    schedule_id INTEGER NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    attempt INTEGER NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('completed', 'retrying', 'failed')),
+    transaction_id INTEGER NULL REFERENCES transactions(id),
+    error VARCHAR(256) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfer_runs_schedule_id ON scheduled_transfer_runs (schedule_id, id);
+
+-- Payment requests ask payer_id to pay amount into the requester's wallet
+-- to_wallet_id. Accepting one transfers from the payer's wallet
+-- from_wallet_id and records the transfer's send and receive transactions.
+-- A pending request past expires_at can no longer be accepted.
+CREATE TABLE IF NOT EXISTS payment_requests (
+    id SERIAL PRIMARY KEY,
+    requester_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    payer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE CHECK (payer_id <> requester_id),
+    to_wallet_id INTEGER NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    from_wallet_id INTEGER NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0.0),
+    currency VARCHAR(3) NOT NULL,
+    note VARCHAR(256) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled', 'expired')),
+    send_transaction_id INTEGER NULL REFERENCES transactions(id),
+    receive_transaction_id INTEGER NULL REFERENCES transactions(id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    responded_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_requests_payer_id ON payment_requests (payer_id, id);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_requester_id ON payment_requests (requester_id, id);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_expires_at_pending ON payment_requests (expires_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    request_fingerprint CHAR(64) NOT NULL,
+    response_status INTEGER NULL,
+    response_body BYTEA NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+-- Exchange-rate quotes. A quote locks a rate for a short time and can be
+-- used by a single currency conversion transfer.
+CREATE TABLE IF NOT EXISTS fx_quotes (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_currency VARCHAR(3) NOT NULL,
+    to_currency VARCHAR(3) NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0.0),
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0.0),
+    fee NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK (fee >= 0.0),
+    converted_amount NUMERIC(20, 2) NOT NULL CHECK (converted_amount > 0.0),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_fx_quotes_expires_at ON fx_quotes (expires_at);
+
+-- Fee rules price fees per transaction type, currency and amount band. The
+-- fee is flat_fee plus percent of the amount, clamped to min_fee and max_fee;
+-- a NULL max_amount or max_fee means no upper bound.
+CREATE TABLE IF NOT EXISTS fee_rules (
+    id SERIAL PRIMARY KEY,
+    tx_type VARCHAR(20) NOT NULL CHECK (tx_type IN ('transfer', 'withdrawal')),
+    currency VARCHAR(3) NOT NULL,
+    min_amount NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK (min_amount >= 0.0),
+    max_amount NUMERIC(20, 2) NULL CHECK (max_amount >= min_amount),
+    flat_fee NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK (flat_fee >= 0.0),
+    percent NUMERIC(9, 6) NOT NULL DEFAULT 0.0 CHECK (percent >= 0.0 AND percent <= 100.0),
+    min_fee NUMERIC(20, 2) NOT NULL DEFAULT 0.0 CHECK (min_fee >= 0.0),
+    max_fee NUMERIC(20, 2) NULL CHECK (max_fee >= min_fee),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_fee_rules_tx_type_currency ON fee_rules (tx_type, currency);